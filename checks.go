@@ -0,0 +1,88 @@
+package main
+
+import (
+	"github.com/chatwork/kibertas/cmd"
+	certmanager "github.com/chatwork/kibertas/cmd/cert-manager"
+	clusterautoscaler "github.com/chatwork/kibertas/cmd/cluster-autoscaler"
+	datadogagent "github.com/chatwork/kibertas/cmd/datadog-agent"
+	"github.com/chatwork/kibertas/cmd/fluent"
+	"github.com/chatwork/kibertas/cmd/ingress"
+)
+
+// definitions returns the built-in checks in the order "test all" runs them.
+func definitions(noDnsCheck bool) []cmd.Definition {
+	return []cmd.Definition{
+		{
+			Name: "cluster-autoscaler",
+			Tags: []string{"aws", "scaling", "critical"},
+			Check: func(checker *cmd.Checker) error {
+				ca, err := clusterautoscaler.NewClusterAutoscaler(checker)
+				if err != nil {
+					return err
+				}
+				return ca.Check()
+			},
+		},
+		{
+			Name:          "ingress",
+			Tags:          []string{"aws", "networking", "critical"},
+			Prerequisites: ingress.Prerequisites,
+			Check: func(checker *cmd.Checker) error {
+				i, err := ingress.NewIngress(checker, noDnsCheck)
+				if err != nil {
+					return err
+				}
+				return i.Check()
+			},
+		},
+		{
+			Name:          "fluent",
+			Tags:          []string{"aws", "logging"},
+			Prerequisites: fluent.Prerequisites,
+			// A failed scale-out may leave the cluster degraded, which would make
+			// missing logs inconclusive.
+			DependsOn: []string{"cluster-autoscaler"},
+			Check: func(checker *cmd.Checker) error {
+				f, err := fluent.NewFluent(checker)
+				if err != nil {
+					return err
+				}
+				return f.Check()
+			},
+		},
+		{
+			Name:          "datadog-agent",
+			Tags:          []string{"monitoring"},
+			Prerequisites: datadogagent.Prerequisites,
+			Check: func(checker *cmd.Checker) error {
+				da, err := datadogagent.NewDatadogAgent(checker)
+				if err != nil {
+					return err
+				}
+				return da.Check()
+			},
+		},
+		{
+			Name:          "cert-manager",
+			Tags:          []string{"tls"},
+			Prerequisites: certmanager.Prerequisites,
+			Check: func(checker *cmd.Checker) error {
+				cm, err := certmanager.NewCertManager(checker)
+				if err != nil {
+					return err
+				}
+				return cm.Check()
+			},
+		},
+	}
+}
+
+// definition returns the built-in check named name, to run it alone.
+func definition(name string, noDnsCheck bool) []cmd.Definition {
+	for _, d := range definitions(noDnsCheck) {
+		if d.Name == name {
+			return []cmd.Definition{d}
+		}
+	}
+	return nil
+}
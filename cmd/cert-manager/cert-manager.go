@@ -10,9 +10,10 @@ import (
 	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
 	"github.com/hashicorp/go-multierror"
 	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -20,14 +21,17 @@ import (
 	"github.com/chatwork/kibertas/config"
 	"github.com/chatwork/kibertas/util"
 	"github.com/chatwork/kibertas/util/k8s"
+	"github.com/chatwork/kibertas/util/report"
 )
 
 type CertManager struct {
 	*cmd.Checker
 	Namespace    string
 	ResourceName string
-	Clientset    *kubernetes.Clientset
+	Clientset    kubernetes.Interface
 	Client       client.Client
+
+	result *report.CheckResult
 }
 
 type certificates struct {
@@ -36,11 +40,41 @@ type certificates struct {
 	certificate *cmapiv1.Certificate
 }
 
+// Prerequisites skips the check when the cert-manager CRDs are not installed.
+func Prerequisites(checker *cmd.Checker) error {
+	k8sclientset, err := config.NewK8sClientset()
+	if err != nil {
+		return fmt.Errorf("error NewK8sClientset: %s", err)
+	}
+	return prerequisites(k8sclientset.Discovery())
+}
+
+func prerequisites(d discovery.DiscoveryInterface) error {
+	groupVersion := cmapiv1.SchemeGroupVersion.String()
+	resources, err := d.ServerResourcesForGroupVersion(groupVersion)
+	if apierrors.IsNotFound(err) {
+		return cmd.Skip("cert-manager CRDs (%s) not found", groupVersion)
+	}
+	if err != nil {
+		return err
+	}
+
+	found := map[string]bool{}
+	for _, r := range resources.APIResources {
+		found[r.Kind] = true
+	}
+	for _, kind := range []string{cmapiv1.CertificateKind, cmapiv1.IssuerKind} {
+		if !found[kind] {
+			return cmd.Skip("cert-manager CRD %s (%s) not found", kind, groupVersion)
+		}
+	}
+	return nil
+}
+
 func NewCertManager(checker *cmd.Checker) (*CertManager, error) {
 	t := time.Now()
 
 	namespace := fmt.Sprintf("cert-manager-test-%d%02d%02d-%s", t.Year(), t.Month(), t.Day(), util.GenerateRandomString(5))
-	location, _ := time.LoadLocation("Asia/Tokyo")
 
 	resourceName := "sample"
 
@@ -48,10 +82,7 @@ func NewCertManager(checker *cmd.Checker) (*CertManager, error) {
 		resourceName = v
 	}
 
-	checker.Chatwork.AddMessage(fmt.Sprintf("Start in %s at %s\n", checker.ClusterName, time.Now().In(location).Format("2006-01-02 15:04:05")))
-
 	checker.Logger().Infof("cert-manager check application Namespace: %s", namespace)
-	checker.Chatwork.AddMessage(fmt.Sprintf("cert-manager check application Namespace: %s\n", namespace))
 
 	k8sclientset, err := config.NewK8sClientset()
 
@@ -76,42 +107,50 @@ func NewCertManager(checker *cmd.Checker) (*CertManager, error) {
 	}, nil
 }
 
-func (c *CertManager) Check() error {
+func (c *CertManager) Check() (err error) {
+	c.result = c.StartCheck("cert-manager", c.Namespace)
+	defer func() { c.FinishCheck(c.result, err) }()
+
 	cert := c.createCertificateObject()
 
-	c.Chatwork.AddMessage("cert-manager check start\n")
-	defer c.Chatwork.Send()
+	defer func() {
+		if err := c.result.Step("clean up resources", func() error { return c.cleanUpResources(cert) }); err != nil {
+			c.Notify(c.result, fmt.Sprintf("Error Delete Resources: %s", err))
+		}
+	}()
 
 	defer func() {
-		if err := c.cleanUpResources(cert); err != nil {
-			c.Chatwork.AddMessage(fmt.Sprintf("Error Delete Resources: %s\n", err))
+		if err != nil {
+			c.CollectDiagnostics(k8s.NewK8s(c.Namespace, c.Clientset, c.Logger), c.result)
 		}
 	}()
+
 	if err := c.createResources(cert); err != nil {
 		return err
 	}
 
-	c.Chatwork.AddMessage("cert-manager check finished\n")
 	return nil
 }
 
 func (c *CertManager) createResources(cert certificates) error {
 	k := k8s.NewK8s(c.Namespace, c.Clientset, c.Logger)
 
-	if err := k.CreateNamespace(
-		c.Ctx,
-		&apiv1.Namespace{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: c.Namespace,
-			}}); err != nil {
+	if err := c.result.Step("create namespace", func() error {
+		return k.CreateNamespace(
+			c.Ctx,
+			&apiv1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: c.Namespace,
+				}})
+	}); err != nil {
 		c.Logger().Error("Error create Namespace:", err)
-		c.Chatwork.AddMessage(fmt.Sprint("Error create Namespace:", err))
+		c.Notify(c.result, fmt.Sprint("Error create Namespace:", err))
 		return err
 	}
 
-	if err := c.createCert(cert); err != nil {
+	if err := c.result.Step("create certificate", func() error { return c.createCert(cert) }); err != nil {
 		c.Logger().Error("Error create certificate:", err)
-		c.Chatwork.AddMessage(fmt.Sprint("Error create certificate:", err))
+		c.Notify(c.result, fmt.Sprint("Error create certificate:", err))
 		return err
 	}
 	return nil
@@ -122,7 +161,7 @@ func (c *CertManager) createResources(cert certificates) error {
 func (c *CertManager) cleanUpResources(cert certificates) error {
 	if c.Debug {
 		c.Logger().Info("Skip Delete Resources")
-		c.Chatwork.AddMessage("Skip Delete Resources\n")
+		c.Notify(c.result, "Skip Delete Resources")
 		return nil
 	}
 	k := k8s.NewK8s(c.Namespace, c.Clientset, c.Logger)
@@ -131,14 +170,14 @@ func (c *CertManager) cleanUpResources(cert certificates) error {
 
 	c.Logger().Infof("Delete Certificate: %s", cert.certificate.Name)
 	if err := c.Client.Delete(context.Background(), cert.certificate); err != nil {
-		c.Chatwork.AddMessage(fmt.Sprintf("Error Delete Certificate: %s\n", err))
+		c.Notify(c.result, fmt.Sprintf("Error Delete Certificate: %s", err))
 		c.Logger().Errorf("Error Delete Certificate: %s", err)
 		result = multierror.Append(result, err)
 	}
 
 	c.Logger().Infof("Delete Issuer: %s", cert.certificate.Name)
 	if err := c.Client.Delete(context.Background(), cert.issuer); err != nil {
-		c.Chatwork.AddMessage(fmt.Sprintf("Error Delete Issuer: %s\n", err))
+		c.Notify(c.result, fmt.Sprintf("Error Delete Issuer: %s", err))
 		c.Logger().Errorf("Error Delete Issuer: %s", err)
 		result = multierror.Append(result, err)
 	}
@@ -146,12 +185,12 @@ func (c *CertManager) cleanUpResources(cert certificates) error {
 	c.Logger().Infof("Delete RootCA: %s", cert.certificate.Name)
 	if err := c.Client.Delete(context.Background(), cert.rootCA); err != nil {
 		c.Logger().Errorf("Error Delete RootCA: %s", err)
-		c.Chatwork.AddMessage(fmt.Sprintf("Error Delete RootCA: %s\n", err))
+		c.Notify(c.result, fmt.Sprintf("Error Delete RootCA: %s", err))
 		result = multierror.Append(result, err)
 	}
 
-	if err = k.DeleteNamespace(); err != nil {
-		c.Chatwork.AddMessage(fmt.Sprintf("Error Delete Namespace: %s\n", err))
+	if err = c.DeleteNamespace(k); err != nil {
+		c.Notify(c.result, fmt.Sprintf("Error Delete Namespace: %s", err))
 		result = multierror.Append(result, err)
 	}
 	return result.ErrorOrNil()
@@ -190,7 +229,7 @@ func (c *CertManager) createCertificateObject() certificates {
 				Algorithm: cmapiv1.ECDSAKeyAlgorithm,
 				Size:      256,
 			},
-			IssuerRef: cmmeta.ObjectReference{
+			IssuerRef: cmmeta.IssuerReference{
 				// This is the issuer used by cert-manager
 				// to issue the root CA's certificate denoted by
 				// this resource.
@@ -226,7 +265,7 @@ func (c *CertManager) createCertificateObject() certificates {
 		},
 		Spec: cmapiv1.CertificateSpec{
 			SecretName: certificateSecretName,
-			IssuerRef: cmmeta.ObjectReference{
+			IssuerRef: cmmeta.IssuerReference{
 				Name: issuerName,
 				Kind: "Issuer",
 			},
@@ -243,22 +282,19 @@ func (c *CertManager) createCertificateObject() certificates {
 // createCert creates a certificate with cert-manager
 // CRなので、client-goではなく、client-runtimeを使う
 // ここでしか作らないリソースなので、utilのほうには入れない
+// Resources are applied, so that re-runs into the same namespace converge.
 func (c *CertManager) createCert(cert certificates) error {
 	c.Logger().Infof("Create RootCA: %s", cert.rootCA.Name)
-	c.Chatwork.AddMessage(fmt.Sprintf("Create RootCA: %s\n", cert.rootCA.Name))
-	err := c.Client.Create(c.Ctx, cert.rootCA)
+	c.Notify(c.result, fmt.Sprintf("Create RootCA: %s", cert.rootCA.Name))
+	err := c.apply(cert.rootCA)
 	if err != nil {
 		return err
 	}
 
 	secretClient := c.Clientset.CoreV1().Secrets(c.Namespace)
 
-	err = wait.PollUntilContextTimeout(c.Ctx, 5*time.Second, c.Timeout, true, func(ctx context.Context) (bool, error) {
-		secret, err := secretClient.Get(ctx, cert.rootCA.Spec.SecretName, metav1.GetOptions{})
-		if err != nil {
-			c.Logger().WithError(err).Infof("Waiting for Secret %s to be ready", cert.rootCA.Spec.SecretName)
-			return false, nil
-		}
+	c.Logger().Infof("Waiting for Secret %s to be ready", cert.rootCA.Spec.SecretName)
+	err = k8s.WaitFor(c.Ctx, secretClient, cert.rootCA.Spec.SecretName, c.Timeout, c.Logger, func(secret *apiv1.Secret) (bool, error) {
 		c.Logger().Infof("Created Secret:%s at %s", secret.Name, secret.CreationTimestamp)
 		return true, nil
 	})
@@ -269,26 +305,22 @@ func (c *CertManager) createCert(cert certificates) error {
 
 	//Create Issuer
 	c.Logger().Infof("Create Issuer: %s", cert.issuer.Name)
-	c.Chatwork.AddMessage(fmt.Sprintf("Create Issuer: %s\n", cert.issuer.Name))
-	err = c.Client.Create(c.Ctx, cert.issuer)
+	c.Notify(c.result, fmt.Sprintf("Create Issuer: %s", cert.issuer.Name))
+	err = c.apply(cert.issuer)
 	if err != nil {
 		return err
 	}
 
 	c.Logger().Infof("Create Certificate: %s", cert.certificate.Name)
-	c.Chatwork.AddMessage(fmt.Sprintf("Create Certificate: %s\n", cert.certificate.Name))
-	err = c.Client.Create(c.Ctx, cert.certificate)
+	c.Notify(c.result, fmt.Sprintf("Create Certificate: %s", cert.certificate.Name))
+	err = c.apply(cert.certificate)
 
 	if err != nil {
 		return err
 	}
 
-	err = wait.PollUntilContextTimeout(c.Ctx, 5*time.Second, c.Timeout, true, func(ctx context.Context) (bool, error) {
-		secret, err := secretClient.Get(ctx, cert.certificate.Spec.SecretName, metav1.GetOptions{})
-		if err != nil {
-			c.Logger().WithError(err).Infof("Waiting for Secret %s to be ready", cert.certificate.Spec.SecretName)
-			return false, nil
-		}
+	c.Logger().Infof("Waiting for Secret %s to be ready", cert.certificate.Spec.SecretName)
+	err = k8s.WaitFor(c.Ctx, secretClient, cert.certificate.Spec.SecretName, c.Timeout, c.Logger, func(secret *apiv1.Secret) (bool, error) {
 		c.Logger().Infof("Created Secret:%s at %s", secret.Name, secret.CreationTimestamp)
 		return true, nil
 	})
@@ -299,3 +331,12 @@ func (c *CertManager) createCert(cert certificates) error {
 
 	return nil
 }
+
+// apply applies obj server-side with the field manager of util/k8s.
+func (c *CertManager) apply(obj client.Object) error {
+	u, err := k8s.ToUnstructured(c.Client.Scheme(), obj)
+	if err != nil {
+		return err
+	}
+	return c.Client.Apply(c.Ctx, client.ApplyConfigurationFromUnstructured(u), client.FieldOwner(k8s.FieldManager), client.ForceOwnership)
+}
@@ -2,6 +2,7 @@ package certmanager
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"testing"
@@ -10,17 +11,33 @@ import (
 	cmapiv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	"github.com/chatwork/kibertas/util"
 	"github.com/chatwork/kibertas/util/notify"
+	"github.com/chatwork/kibertas/util/report"
 	"github.com/mumoshu/testkit"
 	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
 
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 
 	"github.com/chatwork/kibertas/cmd"
 	"github.com/chatwork/kibertas/config"
+	"github.com/chatwork/kibertas/internal/ktesting"
 )
 
 func TestMain(m *testing.M) {
+	// The unit tests don't need the kind cluster the other tests run against.
+	flag.Parse()
+	if testing.Short() {
+		os.Exit(m.Run())
+	}
+
 	h, err := testkit.Build(
 		testkit.Providers(
 			&testkit.KindProvider{
@@ -64,6 +81,10 @@ func TestMain(m *testing.M) {
 }
 
 func TestCertManagerNew(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping test in short mode.")
+	}
+
 	logger := func() *logrus.Entry {
 		return logrus.NewEntry(logrus.New())
 	}
@@ -80,6 +101,10 @@ func TestCertManagerNew(t *testing.T) {
 }
 
 func TestCertManagerCheck(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping test in short mode.")
+	}
+
 	helm := testkit.NewHelm(os.Getenv("KUBECONFIG"))
 	helm.AddRepo(t, "jetstack", "https://charts.jetstack.io")
 
@@ -137,3 +162,80 @@ func TestCertManagerCheck(t *testing.T) {
 		t.Fatalf("Expected No Error, but got error: %s", err)
 	}
 }
+
+// newFakeCertManagerClient returns a client that issues the Secret of every Certificate
+// applied through it in clientset, as cert-manager would.
+func newFakeCertManagerClient(t *testing.T, clientset kubernetes.Interface) client.Client {
+	scheme := runtime.NewScheme()
+	require.NoError(t, cmapiv1.AddToScheme(scheme))
+	return fakeclient.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+		Apply: func(ctx context.Context, c client.WithWatch, obj runtime.ApplyConfiguration, opts ...client.ApplyOption) error {
+			if err := c.Apply(ctx, obj, opts...); err != nil || clientset == nil {
+				return err
+			}
+			certs := &cmapiv1.CertificateList{}
+			if err := c.List(ctx, certs); err != nil {
+				return err
+			}
+			for _, cert := range certs.Items {
+				_, err := clientset.CoreV1().Secrets(cert.Namespace).Create(ctx, &apiv1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: cert.Spec.SecretName, Namespace: cert.Namespace},
+				}, metav1.CreateOptions{})
+				if err != nil && !apierrors.IsAlreadyExists(err) {
+					return err
+				}
+			}
+			return nil
+		},
+	}).Build()
+}
+
+func newTestCertManager(clientset kubernetes.Interface, c client.Client, timeout time.Duration) *CertManager {
+	logger := func() *logrus.Entry {
+		return logrus.NewEntry(logrus.New())
+	}
+	return &CertManager{
+		Checker:      cmd.NewChecker(context.Background(), false, logger, nil, "test", timeout),
+		Namespace:    "cert-manager-test",
+		ResourceName: "sample",
+		Clientset:    clientset,
+		Client:       c,
+	}
+}
+
+func TestCertManagerCheckFake(t *testing.T) {
+	clientset := fake.NewClientset()
+	c := newFakeCertManagerClient(t, clientset)
+	cm := newTestCertManager(clientset, c, time.Minute)
+
+	require.NoError(t, cm.Check())
+	require.Equal(t, report.StatusPassed, cm.result.Status)
+
+	// The Certificates and the Issuer were deleted, then the namespace.
+	var certs cmapiv1.CertificateList
+	require.NoError(t, c.List(context.Background(), &certs))
+	require.Empty(t, certs.Items)
+	var issuers cmapiv1.IssuerList
+	require.NoError(t, c.List(context.Background(), &issuers))
+	require.Empty(t, issuers.Items)
+	require.True(t, ktesting.Deleted(clientset, "namespaces", "cert-manager-test"))
+}
+
+func TestCertManagerCheckSecretTimeout(t *testing.T) {
+	// Nothing issues the Secrets.
+	clientset := fake.NewClientset()
+	c := newFakeCertManagerClient(t, nil)
+	cm := newTestCertManager(clientset, c, 100*time.Millisecond)
+
+	require.ErrorContains(t, cm.Check(), "waiting for RootCA secret to be ready")
+	require.Equal(t, "create certificate", cm.result.FailedStep().Name)
+	// The Issuer and Certificate were never created, so deleting them fails,
+	// but the RootCA and the namespace are still cleaned up.
+	cleanUp := cm.result.Steps[len(cm.result.Steps)-1]
+	require.Equal(t, "clean up resources", cleanUp.Name)
+	require.Equal(t, report.StatusFailed, cleanUp.Status)
+	var certs cmapiv1.CertificateList
+	require.NoError(t, c.List(context.Background(), &certs))
+	require.Empty(t, certs.Items)
+	require.True(t, ktesting.Deleted(clientset, "namespaces", "cert-manager-test"))
+}
@@ -2,30 +2,136 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"sort"
 	"time"
 
+	"github.com/chatwork/kibertas/util/k8s"
 	"github.com/chatwork/kibertas/util/notify"
+	"github.com/chatwork/kibertas/util/report"
 	"github.com/sirupsen/logrus"
 )
 
+// diagnosticsLogLines is how many lines of each container log are kept
+// in the report when a check fails.
+const diagnosticsLogLines = 100
+
 type Checker struct {
 	Ctx         context.Context
 	Debug       bool
 	Logger      func() *logrus.Entry
-	Chatwork    *notify.Chatwork
+	Notifier    notify.Notifier
 	ClusterName string
 	Timeout     time.Duration
+	// Deadline bounds the whole run when set. See RunDefinitions.
+	Deadline time.Time
+	// KeepGoing has RunDefinitions run the remaining checks after one fails,
+	// instead of stopping there.
+	KeepGoing bool
+	// NamespaceDeletionTimeout is how long DeleteNamespace waits for the test namespace to be gone.
+	NamespaceDeletionTimeout time.Duration
+	// Report is the structured result of the run the checks are recorded into.
+	Report *report.Run
 }
 
-func NewChecker(ctx context.Context, debug bool, logger func() *logrus.Entry, chatwork *notify.Chatwork, clusterName string, timeout time.Duration) *Checker {
+// NewChecker returns a Checker. notifier may be nil to send no notifications.
+func NewChecker(ctx context.Context, debug bool, logger func() *logrus.Entry, notifier notify.Notifier, clusterName string, timeout time.Duration) *Checker {
 	logger().Info("Checker timeout: ", timeout)
 
+	if notifier == nil {
+		notifier = notify.Discard
+	}
+
 	return &Checker{
 		Ctx:         ctx,
 		Debug:       debug,
 		Logger:      logger,
-		Chatwork:    chatwork,
+		Notifier:    notifier,
 		ClusterName: clusterName,
 		Timeout:     timeout,
+		Report:      report.NewRun(clusterName),
+
+		NamespaceDeletionTimeout: k8s.DefaultNamespaceDeletionTimeout,
+	}
+}
+
+// StartCheck records the start of a check in the run report and notifies it.
+func (c *Checker) StartCheck(name, namespace string) *report.CheckResult {
+	if c.Report == nil {
+		c.Report = report.NewRun(c.ClusterName)
+	}
+	result := c.Report.StartCheck(name, namespace)
+	c.Notifier.Start(c.Report, result)
+	return result
+}
+
+// FinishCheck records the outcome of a check started with StartCheck and notifies it.
+func (c *Checker) FinishCheck(result *report.CheckResult, err error) {
+	result.Finish(err)
+	c.Notifier.Result(c.Report, result)
+}
+
+// Notify reports the progress of a check. result may be nil for
+// messages that are not about a particular check.
+func (c *Checker) Notify(result *report.CheckResult, message string) {
+	c.Notifier.Step(c.Report, result, message)
+}
+
+// MinCleanupTimeout is the least time DeleteNamespace waits for the test namespace,
+// even past the Deadline, so that a check that used up the time of the run still cleans up.
+var MinCleanupTimeout = time.Minute
+
+// DeleteNamespace deletes the test namespace of k and waits up to NamespaceDeletionTimeout
+// for it to be gone, but not past the Deadline of the run, unless that leaves less than MinCleanupTimeout.
+// Unlike the checks, it is not interrupted with Ctx, so that interrupted checks clean up too.
+func (c *Checker) DeleteNamespace(k *k8s.K8s) error {
+	timeout := k.NamespaceDeletionTimeout
+	if c.NamespaceDeletionTimeout > 0 {
+		timeout = c.NamespaceDeletionTimeout
+	}
+	if !c.Deadline.IsZero() {
+		timeout = min(timeout, max(time.Until(c.Deadline), MinCleanupTimeout))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	k.NamespaceDeletionTimeout = timeout
+	return k.DeleteNamespace(ctx)
+}
+
+// CollectDiagnostics attaches the events, objects and pod logs of the test namespace
+// to the check result so that failures can be triaged from the report.
+// It must be called before the namespace is cleaned up.
+func (c *Checker) CollectDiagnostics(k *k8s.K8s, result *report.CheckResult) {
+	if result == nil {
+		return
+	}
+	ctx := context.Background()
+
+	events, err := k.Events(ctx)
+	if err != nil {
+		c.Logger().Warnf("Error collecting events: %s", err)
+	} else if events != "" {
+		result.AddArtifact(report.Artifact{Kind: report.KindEvents, Name: fmt.Sprintf("events in %s", result.Namespace), Content: events})
+	}
+
+	objects, err := k.Objects(ctx)
+	if err != nil {
+		c.Logger().Warnf("Error collecting objects: %s", err)
+	} else if objects != "" {
+		result.AddArtifact(report.Artifact{Kind: report.KindObjects, Name: fmt.Sprintf("objects in %s", result.Namespace), Content: objects})
+	}
+
+	logs, err := k.PodLogs(ctx, diagnosticsLogLines)
+	if err != nil {
+		c.Logger().Warnf("Error collecting pod logs: %s", err)
+		return
+	}
+	names := make([]string, 0, len(logs))
+	for name := range logs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result.AddArtifact(report.Artifact{Kind: report.KindPodLogs, Name: name, Content: logs[name]})
 	}
 }
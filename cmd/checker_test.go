@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/chatwork/kibertas/util/k8s"
+)
+
+func TestDeleteNamespacePastDeadline(t *testing.T) {
+	old := MinCleanupTimeout
+	MinCleanupTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { MinCleanupTimeout = old })
+
+	clientset := fake.NewClientset(&apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}})
+	// The namespace is slow to go.
+	clientset.PrependReactor("delete", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+	logger := func() *logrus.Entry { return logrus.NewEntry(logrus.New()) }
+	checker := NewChecker(context.Background(), false, logger, nil, "test", time.Minute)
+	// The last check used up the time of the run.
+	checker.Deadline = time.Now().Add(-time.Second)
+
+	err := checker.DeleteNamespace(k8s.NewK8s("test", clientset, logger))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorContains(t, err, "namespace test is still terminating after 100ms")
+}
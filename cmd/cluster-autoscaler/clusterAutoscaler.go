@@ -14,6 +14,7 @@ import (
 	"github.com/chatwork/kibertas/config"
 	"github.com/chatwork/kibertas/util"
 	"github.com/chatwork/kibertas/util/k8s"
+	"github.com/chatwork/kibertas/util/report"
 	"github.com/hashicorp/go-multierror"
 )
 
@@ -23,13 +24,15 @@ type DeploymentOption struct {
 
 type ClusterAutoscaler struct {
 	*cmd.Checker
-	Clientset        *kubernetes.Clientset
+	Clientset        kubernetes.Interface
 	Namespace        string
 	ResourceName     string
 	ReplicaCount     int
 	NodeLabelKey     string
 	NodeLabelValue   string
 	DeploymentOption DeploymentOption
+
+	result *report.CheckResult
 }
 
 func NewClusterAutoscaler(checker *cmd.Checker) (*ClusterAutoscaler, error) {
@@ -37,10 +40,7 @@ func NewClusterAutoscaler(checker *cmd.Checker) (*ClusterAutoscaler, error) {
 
 	namespace := fmt.Sprintf("cluster-autoscaler-test-%d%02d%02d-%s", t.Year(), t.Month(), t.Day(), util.GenerateRandomString(5))
 
-	location, _ := time.LoadLocation("Asia/Tokyo")
-	checker.Chatwork.AddMessage(fmt.Sprintf("Start in %s at %s\n", checker.ClusterName, time.Now().In(location).Format("2006-01-02 15:04:05")))
 	checker.Logger().Infof("cluster-autoscaler check application Namespace: %s", namespace)
-	checker.Chatwork.AddMessage(fmt.Sprintf("cluster-autoscaler check application Namespace: %s\n", namespace))
 
 	resourceName := "sample-for-scale"
 	nodeLabelKey := "eks.amazonaws.com/capacityType"
@@ -76,9 +76,9 @@ func NewClusterAutoscaler(checker *cmd.Checker) (*ClusterAutoscaler, error) {
 
 // Check is check cluster-autoscaler
 // replicaをノード数+1でdeploymentを作成する
-func (c *ClusterAutoscaler) Check() error {
-	c.Chatwork.AddMessage("cluster-autoscaler check start\n")
-	defer c.Chatwork.Send()
+func (c *ClusterAutoscaler) Check() (err error) {
+	c.result = c.StartCheck("cluster-autoscaler", c.Namespace)
+	defer func() { c.FinishCheck(c.result, err) }()
 
 	nodeListOption := metav1.ListOptions{
 		LabelSelector: fmt.Sprintf("%s=%s", c.NodeLabelKey, c.NodeLabelValue),
@@ -87,17 +87,23 @@ func (c *ClusterAutoscaler) Check() error {
 	nodes, err := c.Clientset.CoreV1().Nodes().List(c.Ctx, nodeListOption)
 	if err != nil {
 		c.Logger().Errorf("Error List Nodes: %s", err)
-		c.Chatwork.AddMessage(fmt.Sprintf("Error List Nodes: %s\n", err))
+		c.Notify(c.result, fmt.Sprintf("Error List Nodes: %s", err))
 		return err
 	}
 
 	c.ReplicaCount = len(nodes.Items) + 1
 	c.Logger().Infof("Nodes(have label: %s=%s): %d", c.NodeLabelKey, c.NodeLabelValue, len(nodes.Items))
-	c.Chatwork.AddMessage(fmt.Sprintf("Nodes(have label: %s=%s): %d\n", c.NodeLabelKey, c.NodeLabelValue, len(nodes.Items)))
+	c.Notify(c.result, fmt.Sprintf("Nodes(have label: %s=%s): %d", c.NodeLabelKey, c.NodeLabelValue, len(nodes.Items)))
 
 	defer func() {
-		if err := c.cleanUpResources(); err != nil {
-			c.Chatwork.AddMessage(fmt.Sprintf("Error Delete Resources: %s\n", err))
+		if err := c.result.Step("clean up resources", c.cleanUpResources); err != nil {
+			c.Notify(c.result, fmt.Sprintf("Error Delete Resources: %s", err))
+		}
+	}()
+
+	defer func() {
+		if err != nil {
+			c.CollectDiagnostics(k8s.NewK8s(c.Namespace, c.Clientset, c.Logger), c.result)
 		}
 	}()
 
@@ -110,43 +116,45 @@ func (c *ClusterAutoscaler) Check() error {
 func (c *ClusterAutoscaler) createResources() error {
 	k := k8s.NewK8s(c.Namespace, c.Clientset, c.Logger)
 
-	if err := k.CreateNamespace(
-		c.Ctx,
-		&apiv1.Namespace{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: c.Namespace,
-			}}); err != nil {
-		c.Chatwork.AddMessage(fmt.Sprintf("Error Create Namespace: %s\n", err))
+	if err := c.result.Step("create namespace", func() error {
+		return k.CreateNamespace(
+			c.Ctx,
+			&apiv1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: c.Namespace,
+				}})
+	}); err != nil {
+		c.Notify(c.result, fmt.Sprintf("Error Create Namespace: %s", err))
 		return err
 	}
 
-	c.Chatwork.AddMessage(fmt.Sprintf("Create Deployment with desire replicas %d\n", c.ReplicaCount))
-	if err := k.CreateDeployment(c.Ctx, c.createDeploymentObject(), c.Timeout); err != nil {
-		c.Chatwork.AddMessage(fmt.Sprintf("Error Create Deployment: %s\n", err))
+	c.Notify(c.result, fmt.Sprintf("Create Deployment with desire replicas %d", c.ReplicaCount))
+	if err := c.result.Step("scale out", func() error {
+		return k.CreateDeployment(c.Ctx, c.createDeploymentObject(), c.Timeout)
+	}); err != nil {
+		c.Notify(c.result, fmt.Sprintf("Error Create Deployment: %s", err))
 		return err
 	}
 
-	c.Chatwork.AddMessage("cluster-autoscaler check finished\n")
-
 	return nil
 }
 
 func (c *ClusterAutoscaler) cleanUpResources() error {
 	if c.Debug {
 		c.Logger().Info("Skip Delete Resources")
-		c.Chatwork.AddMessage("Skip Delete Resources\n")
+		c.Notify(c.result, "Skip Delete Resources")
 		return nil
 	}
 	k := k8s.NewK8s(c.Namespace, c.Clientset, c.Logger)
 	var result *multierror.Error
 	var err error
 	if err = k.DeleteDeployment(c.ResourceName); err != nil {
-		c.Chatwork.AddMessage(fmt.Sprintf("Error Delete Deployment: %s", err))
+		c.Notify(c.result, fmt.Sprintf("Error Delete Deployment: %s", err))
 		result = multierror.Append(result, err)
 	}
 
-	if err = k.DeleteNamespace(); err != nil {
-		c.Chatwork.AddMessage(fmt.Sprintf("Error Delete Namespace: %s", err))
+	if err = c.DeleteNamespace(k); err != nil {
+		c.Notify(c.result, fmt.Sprintf("Error Delete Namespace: %s", err))
 		result = multierror.Append(result, err)
 	}
 	return result.ErrorOrNil()
@@ -2,6 +2,7 @@ package clusterautoscaler
 
 import (
 	"context"
+	"errors"
 	"os"
 	"testing"
 	"time"
@@ -11,8 +12,13 @@ import (
 	"github.com/chatwork/kibertas/cmd"
 	"github.com/chatwork/kibertas/internal/ktesting"
 	"github.com/chatwork/kibertas/util/notify"
+	"github.com/chatwork/kibertas/util/report"
 	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 
 	"github.com/mumoshu/testkit"
 	"github.com/sirupsen/logrus"
@@ -146,3 +152,52 @@ func mustSetenv(t *testing.T, key, value string) {
 		t.Fatalf("os.Setenv %s=%s: %s", key, value, err)
 	}
 }
+
+func newTestClusterAutoscaler(clientset kubernetes.Interface, timeout time.Duration) *ClusterAutoscaler {
+	logger := func() *logrus.Entry {
+		return logrus.NewEntry(logrus.New())
+	}
+	return &ClusterAutoscaler{
+		Checker:        cmd.NewChecker(context.Background(), false, logger, nil, "test", timeout),
+		Clientset:      clientset,
+		Namespace:      "cluster-autoscaler-test",
+		ResourceName:   "sample-for-scale",
+		NodeLabelKey:   "eks.amazonaws.com/capacityType",
+		NodeLabelValue: "SPOT",
+	}
+}
+
+func TestClusterAutoscalerCheck(t *testing.T) {
+	spot := map[string]string{"eks.amazonaws.com/capacityType": "SPOT"}
+	clientset := ktesting.NewReadyClientset(ktesting.Node("node-1", spot), ktesting.Node("node-2", nil))
+	c := newTestClusterAutoscaler(clientset, time.Minute)
+
+	require.NoError(t, c.Check())
+	// One replica more than there are nodes, so that a node has to be added.
+	require.Equal(t, 2, c.ReplicaCount)
+	require.Equal(t, report.StatusPassed, c.result.Status)
+	require.True(t, ktesting.Deleted(clientset, "deployments", "sample-for-scale"))
+	require.True(t, ktesting.Deleted(clientset, "namespaces", "cluster-autoscaler-test"))
+}
+
+func TestClusterAutoscalerCheckTimeout(t *testing.T) {
+	clientset := fake.NewClientset()
+	c := newTestClusterAutoscaler(clientset, 100*time.Millisecond)
+
+	require.ErrorContains(t, c.Check(), "waiting for Pods to be ready")
+	require.Equal(t, "scale out", c.result.FailedStep().Name)
+	require.True(t, ktesting.Deleted(clientset, "deployments", "sample-for-scale"))
+	require.True(t, ktesting.Deleted(clientset, "namespaces", "cluster-autoscaler-test"))
+}
+
+func TestClusterAutoscalerCheckListNodesError(t *testing.T) {
+	clientset := fake.NewClientset()
+	clientset.PrependReactor("list", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	c := newTestClusterAutoscaler(clientset, time.Minute)
+
+	require.EqualError(t, c.Check(), "forbidden")
+	// Nothing was created, so there is nothing to clean up.
+	require.False(t, ktesting.Deleted(clientset, "namespaces", "cluster-autoscaler-test"))
+}
@@ -0,0 +1,420 @@
+package custom
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/miekg/dns"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/chatwork/kibertas/cmd"
+	"github.com/chatwork/kibertas/config"
+	"github.com/chatwork/kibertas/util"
+	"github.com/chatwork/kibertas/util/k8s"
+	"github.com/chatwork/kibertas/util/report"
+)
+
+// DNSExchanger sends a DNS query to a server. *dns.Client implements it.
+type DNSExchanger interface {
+	Exchange(m *dns.Msg, address string) (r *dns.Msg, rtt time.Duration, err error)
+}
+
+// Custom runs a check described by a YAML Spec instead of Go code.
+type Custom struct {
+	*cmd.Checker
+	Spec      *Spec
+	Namespace string
+	Clientset kubernetes.Interface
+	Dynamic   dynamic.Interface
+	Mapper    meta.RESTMapper
+	// HTTPClient and DNSClient run the probes. http.DefaultClient and a
+	// plain *dns.Client if nil.
+	HTTPClient *http.Client
+	DNSClient  DNSExchanger
+
+	// createdNamespace is set when the check creates its namespace, and only then
+	// deletes it, so that an existing namespace given by the spec is left alone.
+	createdNamespace bool
+	// objects are the applied manifests, in the order they were applied.
+	objects []*unstructured.Unstructured
+	result  *report.CheckResult
+}
+
+func NewCustom(checker *cmd.Checker, specPath string) (*Custom, error) {
+	spec, err := LoadSpec(specPath)
+	if err != nil {
+		return nil, err
+	}
+
+	t := time.Now()
+
+	namespace := fmt.Sprintf("%s-test-%d%02d%02d-%s", spec.Name, t.Year(), t.Month(), t.Day(), util.GenerateRandomString(5))
+	if spec.Namespace != "" {
+		namespace = spec.Namespace
+	}
+
+	checker.Logger().Infof("%s check application Namespace: %s", spec.Name, namespace)
+
+	k8sclientset, err := config.NewK8sClientset()
+	if err != nil {
+		return nil, fmt.Errorf("error NewK8sClientset: %s", err)
+	}
+
+	dynamicClient, mapper, err := config.NewK8sDynamicClient()
+	if err != nil {
+		return nil, fmt.Errorf("error NewK8sDynamicClient: %s", err)
+	}
+
+	return &Custom{
+		Checker:   checker,
+		Spec:      spec,
+		Namespace: namespace,
+		Clientset: k8sclientset,
+		Dynamic:   dynamicClient,
+		Mapper:    mapper,
+	}, nil
+}
+
+func (c *Custom) Check() (err error) {
+	c.result = c.StartCheck(c.Spec.Name, c.Namespace)
+	defer func() { c.FinishCheck(c.result, err) }()
+
+	defer func() {
+		if err := c.result.Step("clean up resources", c.cleanUpResources); err != nil {
+			c.Notify(c.result, fmt.Sprintf("Error Delete Resources: %s", err))
+		}
+	}()
+
+	defer func() {
+		if err != nil {
+			c.CollectDiagnostics(k8s.NewK8s(c.Namespace, c.Clientset, c.Logger), c.result)
+		}
+	}()
+
+	if err := c.createResources(); err != nil {
+		return err
+	}
+
+	for _, w := range c.Spec.Waits {
+		if err := c.result.Step("wait: "+w.Name, func() error { return c.wait(w) }); err != nil {
+			c.Notify(c.result, fmt.Sprintf("Error waiting for %s: %s", w.Name, err))
+			return err
+		}
+	}
+
+	for _, p := range c.Spec.Probes {
+		if err := c.result.Step("probe: "+p.Name, func() error { return c.probe(p) }); err != nil {
+			c.Notify(c.result, fmt.Sprintf("Error probing %s: %s", p.Name, err))
+			return err
+		}
+	}
+
+	for _, a := range c.Spec.Assertions {
+		if err := c.result.Step("assert: "+a.Name, func() error { return c.assert(a) }); err != nil {
+			c.Notify(c.result, fmt.Sprintf("Assertion %s failed: %s", a.Name, err))
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Custom) templateData() TemplateData {
+	data := TemplateData{
+		Namespace:   c.Namespace,
+		ClusterName: c.ClusterName,
+	}
+	if c.Report != nil {
+		data.RunID = c.Report.ID
+	}
+	return data
+}
+
+func (c *Custom) createResources() error {
+	k := k8s.NewK8s(c.Namespace, c.Clientset, c.Logger)
+	o := k8s.NewObjects(c.Namespace, c.Dynamic, c.Mapper, c.Logger)
+
+	if err := c.result.Step("create namespace", func() error {
+		exists, err := k.NamespaceExists(c.Ctx)
+		if err != nil {
+			return err
+		}
+		if exists {
+			c.Notify(c.result, fmt.Sprintf("Using existing Namespace %s, which is not deleted", c.Namespace))
+			return nil
+		}
+		c.createdNamespace = true
+		return k.CreateNamespace(
+			c.Ctx,
+			&apiv1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: c.Namespace,
+				}})
+	}); err != nil {
+		c.Notify(c.result, fmt.Sprintf("Error Create Namespace: %s", err))
+		return err
+	}
+
+	var objs []*unstructured.Unstructured
+	for _, m := range c.Spec.Manifests {
+		text, err := c.Spec.manifest(m, c.templateData())
+		if err != nil {
+			c.Notify(c.result, fmt.Sprintf("Error rendering manifest: %s", err))
+			return err
+		}
+		decoded, err := k8s.DecodeObjects([]byte(text))
+		if err != nil {
+			c.Notify(c.result, fmt.Sprintf("Error decoding manifest: %s", err))
+			return err
+		}
+		objs = append(objs, decoded...)
+	}
+
+	if err := c.result.Step("apply manifests", func() error {
+		applied, err := o.ApplyAll(c.Ctx, objs, c.Timeout)
+		c.objects = applied
+		return err
+	}); err != nil {
+		c.Notify(c.result, fmt.Sprintf("Error Apply Manifests: %s", err))
+		return err
+	}
+
+	if err := c.result.Step("wait for resources to be ready", func() error {
+		return o.WaitAllReady(c.Ctx, c.objects, c.Timeout)
+	}); err != nil {
+		c.Notify(c.result, fmt.Sprintf("Error waiting for resources: %s", err))
+		return err
+	}
+	return nil
+}
+
+// cleanUpResources deletes the applied objects in reverse order, then the namespace
+// if the check created it.
+func (c *Custom) cleanUpResources() error {
+	if c.Debug {
+		c.Logger().Info("Skip Delete Resources")
+		c.Notify(c.result, "Skip Delete Resources")
+		return nil
+	}
+	k := k8s.NewK8s(c.Namespace, c.Clientset, c.Logger)
+	o := k8s.NewObjects(c.Namespace, c.Dynamic, c.Mapper, c.Logger)
+	var result *multierror.Error
+
+	if err := o.DeleteAll(context.Background(), c.objects); err != nil {
+		c.Notify(c.result, fmt.Sprintf("Error Delete Resources: %s", err))
+		result = multierror.Append(result, err)
+	}
+
+	if !c.createdNamespace {
+		return result.ErrorOrNil()
+	}
+	if err := c.DeleteNamespace(k); err != nil {
+		c.Notify(c.result, fmt.Sprintf("Error Delete Namespace: %s", err))
+		result = multierror.Append(result, err)
+	}
+	return result.ErrorOrNil()
+}
+
+func (c *Custom) timeout(d Duration) time.Duration {
+	if d > 0 {
+		return time.Duration(d)
+	}
+	return c.Timeout
+}
+
+// object renders the name and namespace of ref.
+func (c *Custom) object(ref ObjectRef) (gvk schema.GroupVersionKind, namespace, name string, err error) {
+	data := c.templateData()
+	if name, err = render("object name", ref.Name, data); err != nil {
+		return gvk, "", "", err
+	}
+	if namespace, err = render("object namespace", ref.Namespace, data); err != nil {
+		return gvk, "", "", err
+	}
+	return schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind), namespace, name, nil
+}
+
+func (c *Custom) getObject(ctx context.Context, ref ObjectRef) (*unstructured.Unstructured, error) {
+	gvk, namespace, name, err := c.object(ref)
+	if err != nil {
+		return nil, err
+	}
+	o := k8s.NewObjects(c.Namespace, c.Dynamic, c.Mapper, c.Logger)
+	return o.Get(ctx, gvk, namespace, name)
+}
+
+// wait watches the object of w until it satisfies w. Conditions that can't be
+// evaluated yet, e.g. because a field is not set, are waited for as well.
+func (c *Custom) wait(w Condition) error {
+	gvk, namespace, name, err := c.object(w.Object)
+	if err != nil {
+		return err
+	}
+	o := k8s.NewObjects(c.Namespace, c.Dynamic, c.Mapper, c.Logger)
+	err = o.WaitFor(c.Ctx, gvk, namespace, name, c.timeout(w.Timeout), func(obj *unstructured.Unstructured) (bool, error) {
+		ok, err := w.Evaluate(obj.Object)
+		if err != nil {
+			c.Logger().WithError(err).Infof("Waiting for %s", w.Name)
+			return false, nil
+		}
+		if !ok {
+			c.Logger().Infof("Waiting for %s", w.Name)
+		}
+		return ok, nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for %s: %w", w.Name, err)
+	}
+	c.Logger().Infof("%s is satisfied", w.Name)
+	return nil
+}
+
+func (c *Custom) assert(a Condition) error {
+	obj, err := c.getObject(c.Ctx, a.Object)
+	if err != nil {
+		return err
+	}
+	ok, err := a.Evaluate(obj.Object)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s/%s does not satisfy %s", a.Object.Kind, obj.GetName(), a.JSONPath+a.CEL)
+	}
+	return nil
+}
+
+func (c *Custom) probe(p Probe) error {
+	var try func() (bool, error)
+	if p.HTTP != nil {
+		try = func() (bool, error) { return c.probeHTTP(p.HTTP) }
+	} else {
+		try = func() (bool, error) { return c.probeDNS(p.DNS) }
+	}
+
+	err := wait.PollUntilContextTimeout(c.Ctx, 10*time.Second, c.timeout(p.Timeout), true, func(ctx context.Context) (bool, error) {
+		ok, err := try()
+		if err != nil {
+			c.Logger().Warn(err)
+			return false, nil
+		}
+		return ok, nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for %s: %w", p.Name, err)
+	}
+	return nil
+}
+
+func (c *Custom) probeHTTP(p *HTTPProbe) (bool, error) {
+	data := c.templateData()
+	url, err := render("url", p.URL, data)
+	if err != nil {
+		return false, err
+	}
+	host, err := render("host", p.Host, data)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(c.Ctx, "GET", url, nil)
+	if err != nil {
+		return false, err
+	}
+	if host != "" {
+		req.Host = host
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	c.Logger().Infof("Requesting %s", url)
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			c.Logger().Warn(err)
+		}
+	}()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return false, err
+	}
+
+	status := p.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if resp.StatusCode != status {
+		c.Logger().Infof("HTTP Status Code is not %d: %d", status, resp.StatusCode)
+		return false, nil
+	}
+	if p.BodyContains != "" && !strings.Contains(string(body), p.BodyContains) {
+		c.Logger().Infof("Response body does not contain %q", p.BodyContains)
+		return false, nil
+	}
+
+	c.result.AddArtifact(report.Artifact{Kind: report.KindHTTPResponse, Name: url, URL: url, Content: fmt.Sprintf("%s %s\n\n%s", resp.Proto, resp.Status, body)})
+	return true, nil
+}
+
+func (c *Custom) probeDNS(p *DNSProbe) (bool, error) {
+	name, err := render("dns name", p.Name, c.templateData())
+	if err != nil {
+		return false, err
+	}
+
+	server := "8.8.8.8:53"
+	if p.Server != "" {
+		server = p.Server
+	}
+	qtype := dns.TypeA
+	if p.Type != "" {
+		t, ok := dns.StringToType[strings.ToUpper(p.Type)]
+		if !ok {
+			return false, fmt.Errorf("unknown DNS record type %q", p.Type)
+		}
+		qtype = t
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	client := c.DNSClient
+	if client == nil {
+		client = new(dns.Client)
+	}
+	r, _, err := client.Exchange(m, server)
+	if err != nil {
+		return false, err
+	}
+
+	for _, ans := range r.Answer {
+		if ans.Header().Rrtype != qtype {
+			continue
+		}
+		if p.Contains == "" || strings.Contains(ans.String(), p.Contains) {
+			c.Logger().Infof("Record is available: %s", ans)
+			c.result.AddArtifact(report.Artifact{Kind: report.KindDNSAnswer, Name: name, Content: r.String()})
+			return true, nil
+		}
+	}
+
+	c.Logger().Infof("Record for %s is not yet available, retrying...", name)
+	return false, nil
+}
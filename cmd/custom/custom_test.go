@@ -0,0 +1,193 @@
+package custom
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/chatwork/kibertas/cmd"
+	"github.com/chatwork/kibertas/internal/ktesting"
+	"github.com/chatwork/kibertas/util/k8s"
+	"github.com/chatwork/kibertas/util/report"
+)
+
+// newTestCustom returns the nginx check of testdata, with its probe answered by handler.
+func newTestCustom(t *testing.T, ready bool, handler http.HandlerFunc, timeout time.Duration) (*Custom, *fake.Clientset, *dynamicfake.FakeDynamicClient) {
+	t.Helper()
+	spec, err := LoadSpec(filepath.Join("testdata", "nginx.yaml"))
+	require.NoError(t, err)
+	for i := range spec.Waits {
+		spec.Waits[i].Timeout = Duration(timeout)
+	}
+	for i := range spec.Probes {
+		spec.Probes[i].Timeout = Duration(timeout)
+	}
+
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial(network, ts.Listener.Addr().String())
+		},
+	}}
+
+	dynamicClient := ktesting.NewDynamicClient()
+	if ready {
+		dynamicClient.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			get := action.(k8stesting.GetAction)
+			obj, err := dynamicClient.Tracker().Get(get.GetResource(), get.GetNamespace(), get.GetName())
+			if err != nil {
+				return true, nil, err
+			}
+			d := obj.DeepCopyObject().(*unstructured.Unstructured)
+			replicas, _, _ := unstructured.NestedFieldCopy(d.Object, "spec", "replicas")
+			for _, field := range []string{"replicas", "updatedReplicas", "readyReplicas", "availableReplicas"} {
+				if err := unstructured.SetNestedField(d.Object, replicas, "status", field); err != nil {
+					return true, nil, err
+				}
+			}
+			return true, d, nil
+		})
+	}
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for _, gvk := range []schema.GroupVersionKind{
+		{Group: "apps", Version: "v1", Kind: "Deployment"},
+		{Version: "v1", Kind: "Service"},
+		{Version: "v1", Kind: "ConfigMap"},
+	} {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+
+	logger := func() *logrus.Entry {
+		return logrus.NewEntry(logrus.New())
+	}
+	clientset := fake.NewClientset()
+	return &Custom{
+		Checker:    cmd.NewChecker(context.Background(), false, logger, nil, "test", timeout),
+		Spec:       spec,
+		Namespace:  "nginx-test-20240101-abcde",
+		Clientset:  clientset,
+		Dynamic:    dynamicClient,
+		Mapper:     mapper,
+		HTTPClient: httpClient,
+	}, clientset, dynamicClient
+}
+
+func deletedObjects(client *dynamicfake.FakeDynamicClient) []string {
+	var deleted []string
+	for _, action := range client.Actions() {
+		if d, ok := action.(k8stesting.DeleteAction); ok {
+			deleted = append(deleted, d.GetResource().Resource+"/"+d.GetName())
+		}
+	}
+	return deleted
+}
+
+func TestCustomCheck(t *testing.T) {
+	c, clientset, dynamicClient := newTestCustom(t, true, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("Welcome to nginx!"))
+	}, time.Minute)
+
+	require.NoError(t, c.Check())
+	require.Equal(t, report.StatusPassed, c.result.Status)
+
+	var steps []string
+	for _, s := range c.result.Steps {
+		steps = append(steps, s.Name)
+	}
+	require.Equal(t, []string{
+		"create namespace",
+		"apply manifests",
+		"wait for resources to be ready",
+		"wait: deployment is available",
+		"probe: service responds",
+		"assert: configmap has namespace",
+		"clean up resources",
+	}, steps)
+
+	// Objects are applied ConfigMaps first and deleted in reverse order, then the namespace.
+	require.Equal(t, []string{"deployments/nginx", "services/nginx", "configmaps/run-" + c.Report.ID}, deletedObjects(dynamicClient))
+	require.True(t, ktesting.Deleted(clientset, "namespaces", "nginx-test-20240101-abcde"))
+}
+
+func TestCustomCheckExistingNamespace(t *testing.T) {
+	c, clientset, _ := newTestCustom(t, true, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("Welcome to nginx!"))
+	}, time.Minute)
+	c.Namespace = "default"
+	c.Spec.Assertions = nil
+	_, err := clientset.CoreV1().Namespaces().Create(context.Background(), &apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	clientset.ClearActions()
+
+	require.NoError(t, c.Check())
+	require.False(t, ktesting.Deleted(clientset, "namespaces", "default"))
+	for _, action := range clientset.Actions() {
+		require.Equal(t, "get", action.GetVerb(), "%s namespaces/default", action.GetVerb())
+	}
+}
+
+func TestCustomCheckNotReady(t *testing.T) {
+	c, clientset, dynamicClient := newTestCustom(t, false, func(w http.ResponseWriter, r *http.Request) {}, 100*time.Millisecond)
+
+	require.ErrorContains(t, c.Check(), "waiting for Deployment nginx to be ready")
+	require.Equal(t, "wait for resources to be ready", c.result.FailedStep().Name)
+	require.Len(t, deletedObjects(dynamicClient), 3)
+	require.True(t, ktesting.Deleted(clientset, "namespaces", "nginx-test-20240101-abcde"))
+}
+
+func TestCustomCheckWaitTimeout(t *testing.T) {
+	c, _, _ := newTestCustom(t, true, func(w http.ResponseWriter, r *http.Request) {}, 100*time.Millisecond)
+	c.Spec.Waits[0].Equals = "2"
+
+	require.ErrorContains(t, c.Check(), "waiting for deployment is available")
+	require.Equal(t, "wait: deployment is available", c.result.FailedStep().Name)
+}
+
+func TestCustomCheckProbeTimeout(t *testing.T) {
+	c, _, _ := newTestCustom(t, true, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}, 100*time.Millisecond)
+
+	require.ErrorContains(t, c.Check(), "waiting for service responds")
+	require.Equal(t, "probe: service responds", c.result.FailedStep().Name)
+}
+
+func TestCustomWaitWatches(t *testing.T) {
+	// Only a watch can see the Deployment becoming available in time.
+	pollInterval := k8s.PollInterval
+	k8s.PollInterval = time.Hour
+	t.Cleanup(func() { k8s.PollInterval = pollInterval })
+
+	c, _, dynamicClient := newTestCustom(t, false, func(w http.ResponseWriter, r *http.Request) {}, 10*time.Second)
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "nginx", "namespace": c.Namespace},
+		"status":     map[string]interface{}{"readyReplicas": int64(1)},
+	}}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = dynamicClient.Tracker().Create(schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, deployment, c.Namespace)
+	}()
+
+	started := time.Now()
+	require.NoError(t, c.wait(c.Spec.Waits[0]))
+	require.Less(t, time.Since(started), 5*time.Second)
+}
@@ -0,0 +1,255 @@
+package custom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"cel.dev/cel-go/cel"
+	"gopkg.in/yaml.v2"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// Spec describes a custom check:
+// apply manifests into a test namespace, wait for conditions, probe and assert.
+//
+// Manifests, object references and probe targets are Go templates
+// rendered with TemplateData.
+type Spec struct {
+	// Name identifies the check in logs, notifications and reports.
+	Name string `yaml:"name"`
+	// Namespace is the namespace to create and run in.
+	// Defaults to <name>-test-<date>-<random>. An existing namespace is used
+	// as is, and not deleted after the check.
+	Namespace  string      `yaml:"namespace"`
+	Manifests  []Manifest  `yaml:"manifests"`
+	Waits      []Condition `yaml:"waits"`
+	Probes     []Probe     `yaml:"probes"`
+	Assertions []Condition `yaml:"assertions"`
+
+	// dir is the directory of the spec file, relative manifest paths are resolved against it.
+	dir string
+}
+
+// Manifest is either a path to a YAML file or inline YAML, each possibly holding several documents.
+type Manifest struct {
+	File   string `yaml:"file"`
+	Inline string `yaml:"inline"`
+}
+
+// Condition is evaluated against a single object,
+// either until it holds (waits) or once (assertions).
+type Condition struct {
+	Name   string    `yaml:"name"`
+	Object ObjectRef `yaml:"object"`
+	// JSONPath is a kubectl-style JSONPath expression such as {.status.readyReplicas}.
+	// The condition holds when the result equals Equals, or is non-empty when Equals is not set.
+	JSONPath string `yaml:"jsonPath"`
+	Equals   string `yaml:"equals"`
+	// CEL is a boolean CEL expression over the object, which is bound to `object`.
+	CEL     string   `yaml:"cel"`
+	Timeout Duration `yaml:"timeout"`
+
+	program cel.Program
+	parser  *jsonpath.JSONPath
+}
+
+type ObjectRef struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Name       string `yaml:"name"`
+	// Namespace defaults to the test namespace for namespaced kinds.
+	Namespace string `yaml:"namespace"`
+}
+
+// Probe is retried until it succeeds or times out. Exactly one of HTTP and DNS is set.
+type Probe struct {
+	Name    string     `yaml:"name"`
+	HTTP    *HTTPProbe `yaml:"http"`
+	DNS     *DNSProbe  `yaml:"dns"`
+	Timeout Duration   `yaml:"timeout"`
+}
+
+type HTTPProbe struct {
+	URL string `yaml:"url"`
+	// Host overrides the Host header, for requesting an ingress by its load balancer address.
+	Host string `yaml:"host"`
+	// Status is the expected status code, 200 if not set.
+	Status       int    `yaml:"status"`
+	BodyContains string `yaml:"bodyContains"`
+}
+
+type DNSProbe struct {
+	Name string `yaml:"name"`
+	// Type is the record type to query, A if not set.
+	Type string `yaml:"type"`
+	// Server is the resolver address, 8.8.8.8:53 if not set.
+	Server string `yaml:"server"`
+	// Contains must appear in one of the answers if set.
+	Contains string `yaml:"contains"`
+}
+
+// Duration is a time.Duration written as a string like "5m" in the spec.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// TemplateData is available to the templates in a spec.
+type TemplateData struct {
+	Namespace   string
+	RunID       string
+	ClusterName string
+}
+
+func LoadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &Spec{}
+	if err := yaml.UnmarshalStrict(data, spec); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	spec.dir = filepath.Dir(path)
+
+	if err := spec.validate(); err != nil {
+		return nil, fmt.Errorf("invalid spec %s: %w", path, err)
+	}
+	return spec, nil
+}
+
+func (s *Spec) validate() error {
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+	for i := range s.Manifests {
+		m := &s.Manifests[i]
+		if (m.File == "") == (m.Inline == "") {
+			return fmt.Errorf("manifests[%d]: exactly one of file and inline is required", i)
+		}
+	}
+	for i := range s.Waits {
+		if err := s.Waits[i].compile(); err != nil {
+			return fmt.Errorf("waits[%d]: %w", i, err)
+		}
+	}
+	for i := range s.Assertions {
+		if err := s.Assertions[i].compile(); err != nil {
+			return fmt.Errorf("assertions[%d]: %w", i, err)
+		}
+	}
+	for i, p := range s.Probes {
+		if p.Name == "" {
+			return fmt.Errorf("probes[%d]: name is required", i)
+		}
+		if (p.HTTP == nil) == (p.DNS == nil) {
+			return fmt.Errorf("probes[%d]: exactly one of http and dns is required", i)
+		}
+	}
+	return nil
+}
+
+func (c *Condition) compile() error {
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+	if c.Object.APIVersion == "" || c.Object.Kind == "" || c.Object.Name == "" {
+		return errors.New("object.apiVersion, object.kind and object.name are required")
+	}
+	if (c.JSONPath == "") == (c.CEL == "") {
+		return errors.New("exactly one of jsonPath and cel is required")
+	}
+
+	if c.JSONPath != "" {
+		c.parser = jsonpath.New(c.Name).AllowMissingKeys(true)
+		if err := c.parser.Parse(c.JSONPath); err != nil {
+			return fmt.Errorf("parsing jsonPath: %w", err)
+		}
+		return nil
+	}
+
+	env, err := cel.NewEnv(cel.Variable("object", cel.DynType))
+	if err != nil {
+		return err
+	}
+	ast, issues := env.Compile(c.CEL)
+	if issues != nil && issues.Err() != nil {
+		return fmt.Errorf("compiling cel: %w", issues.Err())
+	}
+	c.program, err = env.Program(ast)
+	if err != nil {
+		return fmt.Errorf("compiling cel: %w", err)
+	}
+	return nil
+}
+
+// Evaluate reports whether the condition holds for obj.
+func (c *Condition) Evaluate(obj map[string]interface{}) (bool, error) {
+	if c.parser != nil {
+		var buf bytes.Buffer
+		if err := c.parser.Execute(&buf, obj); err != nil {
+			return false, err
+		}
+		if c.Equals != "" {
+			return buf.String() == c.Equals, nil
+		}
+		return buf.Len() > 0, nil
+	}
+
+	out, _, err := c.program.Eval(map[string]interface{}{"object": obj})
+	if err != nil {
+		return false, err
+	}
+	b, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("cel expression returned %T, not bool", out.Value())
+	}
+	return b, nil
+}
+
+func render(name, text string, data TemplateData) (string, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// manifest returns the rendered YAML of m.
+func (s *Spec) manifest(m Manifest, data TemplateData) (string, error) {
+	text := m.Inline
+	name := "inline manifest"
+	if m.File != "" {
+		path := m.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(s.dir, path)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		text = string(b)
+		name = m.File
+	}
+	return render(name, text, data)
+}
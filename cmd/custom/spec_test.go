@@ -0,0 +1,89 @@
+package custom
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chatwork/kibertas/util/k8s"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSpec(t *testing.T) {
+	spec, err := LoadSpec(filepath.Join("testdata", "nginx.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, "nginx", spec.Name)
+	require.Len(t, spec.Manifests, 2)
+	require.Len(t, spec.Waits, 1)
+	require.Len(t, spec.Probes, 1)
+	require.Len(t, spec.Assertions, 1)
+
+	data := TemplateData{Namespace: "nginx-test-20240101-abcde", RunID: "run1"}
+
+	var objs []string
+	for _, m := range spec.Manifests {
+		text, err := spec.manifest(m, data)
+		require.NoError(t, err)
+		decoded, err := k8s.DecodeObjects([]byte(text))
+		require.NoError(t, err)
+		for _, obj := range decoded {
+			objs = append(objs, obj.GetKind()+"/"+obj.GetName()+"@"+obj.GetNamespace())
+		}
+	}
+	require.Equal(t, []string{
+		"Deployment/nginx@nginx-test-20240101-abcde",
+		"Service/nginx@",
+		"ConfigMap/run-run1@",
+	}, objs)
+}
+
+func TestLoadSpecInvalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"no name":         "manifests: []",
+		"unknown field":   "name: x\nmanifest: []",
+		"bad wait":        "name: x\nwaits:\n- name: w\n  object: {apiVersion: v1, kind: Pod, name: p}",
+		"both conditions": "name: x\nwaits:\n- name: w\n  object: {apiVersion: v1, kind: Pod, name: p}\n  jsonPath: '{.status}'\n  cel: 'true'",
+		"bad cel":         "name: x\nassertions:\n- name: a\n  object: {apiVersion: v1, kind: Pod, name: p}\n  cel: 'object.'",
+		"empty probe":     "name: x\nprobes:\n- name: p",
+		"bad timeout":     "name: x\nprobes:\n- name: p\n  timeout: soon\n  dns: {name: example.com}",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "spec.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+			_, err := LoadSpec(path)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestConditionEvaluate(t *testing.T) {
+	obj := map[string]interface{}{
+		"status": map[string]interface{}{
+			"readyReplicas": int64(1),
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": "True"},
+			},
+		},
+	}
+
+	for name, tc := range map[string]struct {
+		cond Condition
+		want bool
+	}{
+		"jsonpath equals":     {Condition{JSONPath: "{.status.readyReplicas}", Equals: "1"}, true},
+		"jsonpath not equals": {Condition{JSONPath: "{.status.readyReplicas}", Equals: "2"}, false},
+		"jsonpath present":    {Condition{JSONPath: "{.status.conditions[0].type}"}, true},
+		"jsonpath missing":    {Condition{JSONPath: "{.status.availableReplicas}"}, false},
+		"cel true":            {Condition{CEL: `object.status.conditions.exists(c, c.type == "Ready" && c.status == "True")`}, true},
+		"cel false":           {Condition{CEL: `object.status.readyReplicas > 1`}, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tc.cond.Name = name
+			tc.cond.Object = ObjectRef{APIVersion: "apps/v1", Kind: "Deployment", Name: "x"}
+			require.NoError(t, tc.cond.compile())
+			got, err := tc.cond.Evaluate(obj)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
 	"github.com/chatwork/kibertas/cmd"
 	"github.com/chatwork/kibertas/util"
+	"github.com/chatwork/kibertas/util/report"
 )
 
 type DatadogAgent struct {
@@ -24,6 +25,8 @@ type DatadogAgent struct {
 	WaitTime time.Duration
 	// DatadogMetrics is the Datadog metrics API provider that provides metrics querying
 	DatadogMetrics DatadogMetrics
+
+	result *report.CheckResult
 }
 
 // DatadogMetrics interface abstracts the Datadog metrics API for testing
@@ -32,6 +35,14 @@ type DatadogMetrics interface {
 	QueryMetrics(ctx context.Context, from, to int64, query string) (datadogV1.MetricsQueryResponse, *http.Response, error)
 }
 
+// Prerequisites skips the check when no Datadog API keys are given.
+func Prerequisites(checker *cmd.Checker) error {
+	if _, err := newDatadogClientFromEnv(); err != nil {
+		return cmd.Skip("%s", err)
+	}
+	return nil
+}
+
 func NewDatadogAgent(checker *cmd.Checker) (*DatadogAgent, error) {
 	client, err := newDatadogClientFromEnv()
 	if err != nil {
@@ -64,9 +75,6 @@ func NewDatadogAgentWithClient(checker *cmd.Checker, metrics DatadogMetrics) (*D
 		queryMetrics = v
 	}
 
-	location, _ := time.LoadLocation("Asia/Tokyo")
-	checker.Chatwork.AddMessage(fmt.Sprintf("Start in %s at %s\n", checker.ClusterName, time.Now().In(location).Format("2006-01-02 15:04:05")))
-
 	return &DatadogAgent{
 		Checker:        checker,
 		MetricsQuery:   queryMetrics,
@@ -75,23 +83,21 @@ func NewDatadogAgentWithClient(checker *cmd.Checker, metrics DatadogMetrics) (*D
 	}, nil
 }
 
-func (d *DatadogAgent) Check() error {
-	defer d.Chatwork.Send()
-
-	d.Chatwork.AddMessage("datadog-agent check start\n")
+func (d *DatadogAgent) Check() (err error) {
+	d.result = d.StartCheck("datadog-agent", "")
+	defer func() { d.FinishCheck(d.result, err) }()
 
-	if err := d.checkMetrics(); err != nil {
-		d.Chatwork.AddMessage(fmt.Sprintf("checkMetrics error: %s\n", err.Error()))
+	if err := d.result.Step("check metrics", d.checkMetrics); err != nil {
+		d.Notify(d.result, fmt.Sprintf("checkMetrics error: %s", err.Error()))
 		return err
 	}
-	d.Chatwork.AddMessage("datadog-agent check finished\n")
 	return nil
 }
 
 func (d *DatadogAgent) checkMetrics() error {
 
 	d.Logger().Infof("Querying metrics with query: %s", d.MetricsQuery)
-	d.Chatwork.AddMessage(fmt.Sprintf("Querying metrics with query: %s\n", d.MetricsQuery))
+	d.Notify(d.result, fmt.Sprintf("Querying metrics with query: %s", d.MetricsQuery))
 
 	d.Logger().Info("Waiting metrics...")
 
@@ -127,9 +133,10 @@ func (d *DatadogAgent) checkMetrics() error {
 			return false, nil
 		} else if len(resp.GetSeries()) > 0 {
 			d.Logger().Info("Response from `MetricsApi.QueryMetrics`")
-			d.Chatwork.AddMessage("Response from `MetricsApi.QueryMetrics`\n")
+			d.Notify(d.result, "Response from `MetricsApi.QueryMetrics`")
 			responseContent, _ := json.MarshalIndent(resp, "", "  ")
 			d.Logger().Debugf("Response: %s", responseContent)
+			d.result.AddArtifact(report.Artifact{Kind: report.KindDatadogSeries, Name: d.MetricsQuery, Content: string(responseContent)})
 			return true, nil
 		}
 
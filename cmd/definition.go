@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+// Definition describes a check that can be selected by name or tag,
+// e.g. for "test all".
+type Definition struct {
+	Name string
+	// Tags group checks by what they need or cover, e.g. aws, logging, networking or critical.
+	Tags []string
+	// Prerequisites returns a SkipError when the check can't run on the cluster,
+	// e.g. because the add-on it tests is not installed. It may be nil.
+	Prerequisites func(checker *Checker) error
+	// DependsOn names the checks that must pass before this one runs.
+	// Dependencies that are not selected are ignored.
+	DependsOn []string
+	Check     func(checker *Checker) error
+}
+
+// SkipError is returned by Definition.Prerequisites to skip a check
+// instead of failing it.
+type SkipError struct {
+	Reason string
+}
+
+func (e *SkipError) Error() string {
+	return "skipped: " + e.Reason
+}
+
+func Skip(format string, a ...interface{}) error {
+	return &SkipError{Reason: fmt.Sprintf(format, a...)}
+}
+
+// SkipReason returns the reason of the SkipError in err's chain, if any.
+func SkipReason(err error) (string, bool) {
+	var skip *SkipError
+	if errors.As(err, &skip) {
+		return skip.Reason, true
+	}
+	return "", false
+}
+
+func (d Definition) matches(names []string) bool {
+	for _, n := range names {
+		if n == d.Name {
+			return true
+		}
+		for _, t := range d.Tags {
+			if n == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Select returns the definitions having one of tags, or all of them if tags is empty,
+// minus those matching skip. Both tags and skip accept check names as well as tags.
+func Select(defs []Definition, tags, skip []string) ([]Definition, error) {
+	for _, names := range [][]string{tags, skip} {
+		for _, n := range names {
+			if !(Definition{Name: n}).matches(known(defs)) {
+				return nil, fmt.Errorf("unknown check or tag %q, valid ones are: %s", n, strings.Join(known(defs), ", "))
+			}
+		}
+	}
+
+	var selected []Definition
+	for _, d := range defs {
+		if len(tags) > 0 && !d.matches(tags) {
+			continue
+		}
+		if d.matches(skip) {
+			continue
+		}
+		selected = append(selected, d)
+	}
+	return selected, nil
+}
+
+// known returns the names and tags of defs.
+func known(defs []Definition) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, d := range defs {
+		for _, n := range append([]string{d.Name}, d.Tags...) {
+			if !seen[n] {
+				seen[n] = true
+				names = append(names, n)
+			}
+		}
+	}
+	return names
+}
+
+// RunDefinitions runs defs after their dependencies and returns the errors of
+// the failed ones. It stops at the first failure unless KeepGoing is set.
+// Checks whose prerequisites are missing, or whose dependencies did not pass,
+// are recorded as skipped.
+//
+// With a Deadline, each check gets an equal share of the time left for the
+// checks still to run, up to Timeout, so that checks finishing early leave
+// more time to the following ones.
+func (c *Checker) RunDefinitions(defs []Definition) error {
+	defs, err := order(defs)
+	if err != nil {
+		return err
+	}
+
+	timeout := c.Timeout
+	defer func() { c.Timeout = timeout }()
+	if c.Report == nil {
+		c.Report = report.NewRun(c.ClusterName)
+	}
+
+	status := map[string]report.Status{}
+	var result *multierror.Error
+	for i, d := range defs {
+		if reason := blocked(d, status); reason != "" {
+			status[d.Name] = c.skip(d, reason)
+			continue
+		}
+
+		if !c.Deadline.IsZero() {
+			remaining := time.Until(c.Deadline)
+			if remaining <= 0 {
+				status[d.Name] = c.skip(d, "run deadline exceeded")
+				continue
+			}
+			c.Timeout = min(timeout, remaining/time.Duration(len(defs)-i))
+			c.Logger().Infof("%s timeout: %s", d.Name, c.Timeout)
+		}
+
+		if d.Prerequisites != nil {
+			if err := d.Prerequisites(c); err != nil {
+				if reason, ok := SkipReason(err); ok {
+					status[d.Name] = c.skip(d, reason)
+					continue
+				}
+				c.FinishCheck(c.StartCheck(d.Name, ""), err)
+				status[d.Name] = report.StatusFailed
+				result = multierror.Append(result, fmt.Errorf("checking prerequisites of %s: %w", d.Name, err))
+				if !c.KeepGoing {
+					break
+				}
+				continue
+			}
+		}
+
+		checks := len(c.Report.Checks)
+		if err := d.Check(c); err != nil {
+			// Checks failing before they start, e.g. when their client can't
+			// be created, are reported as well.
+			if len(c.Report.Checks) == checks {
+				c.FinishCheck(c.StartCheck(d.Name, ""), err)
+			}
+			status[d.Name] = report.StatusFailed
+			result = multierror.Append(result, err)
+			if !c.KeepGoing {
+				break
+			}
+			continue
+		}
+		status[d.Name] = report.StatusPassed
+	}
+
+	if result != nil && len(result.Errors) == 1 {
+		return result.Errors[0]
+	}
+	return result.ErrorOrNil()
+}
+
+func (c *Checker) skip(d Definition, reason string) report.Status {
+	c.Logger().Infof("Skipping %s: %s", d.Name, reason)
+	result := c.StartCheck(d.Name, "")
+	result.Skip(reason)
+	c.Notifier.Result(c.Report, result)
+	return report.StatusSkipped
+}
+
+// blocked returns why d can't run because of its dependencies, if it can't.
+func blocked(d Definition, status map[string]report.Status) string {
+	for _, dep := range d.DependsOn {
+		s, ok := status[dep]
+		if !ok || s == report.StatusPassed {
+			continue
+		}
+		return fmt.Sprintf("depends on %s, which %s", dep, map[report.Status]string{
+			report.StatusFailed:  "failed",
+			report.StatusSkipped: "was skipped",
+		}[s])
+	}
+	return ""
+}
+
+// order sorts defs so that every check comes after its dependencies,
+// otherwise keeping the given order.
+func order(defs []Definition) ([]Definition, error) {
+	selected := map[string]bool{}
+	for _, d := range defs {
+		selected[d.Name] = true
+	}
+
+	placed := map[string]bool{}
+	var sorted []Definition
+	for len(sorted) < len(defs) {
+		progress := false
+		for _, d := range defs {
+			if placed[d.Name] || !ready(d, selected, placed) {
+				continue
+			}
+			placed[d.Name] = true
+			sorted = append(sorted, d)
+			progress = true
+			// Start over to keep the given order as much as possible.
+			break
+		}
+		if !progress {
+			var cycle []string
+			for _, d := range defs {
+				if !placed[d.Name] {
+					cycle = append(cycle, d.Name)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between %s", strings.Join(cycle, ", "))
+		}
+	}
+	return sorted, nil
+}
+
+func ready(d Definition, selected, placed map[string]bool) bool {
+	for _, dep := range d.DependsOn {
+		if selected[dep] && !placed[dep] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func testDefinitions(ran *[]string) []Definition {
+	check := func(name string, err error) func(*Checker) error {
+		return func(*Checker) error {
+			*ran = append(*ran, name)
+			return err
+		}
+	}
+	return []Definition{
+		{Name: "cluster-autoscaler", Tags: []string{"aws", "critical"}, Check: check("cluster-autoscaler", nil)},
+		{Name: "cert-manager", Tags: []string{"tls"}, Prerequisites: func(*Checker) error { return Skip("CRDs not found") }, Check: check("cert-manager", nil)},
+		{Name: "datadog-agent", Tags: []string{"monitoring"}, Check: check("datadog-agent", errors.New("no metrics"))},
+		{Name: "fluent", Tags: []string{"aws", "logging"}, Check: check("fluent", nil)},
+		{Name: "metrics-pipeline", DependsOn: []string{"datadog-agent"}, Check: check("metrics-pipeline", nil)},
+	}
+}
+
+func names(defs []Definition) []string {
+	var names []string
+	for _, d := range defs {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
+func TestSelect(t *testing.T) {
+	defs := testDefinitions(nil)
+
+	for _, tc := range []struct {
+		tags, skip []string
+		want       []string
+	}{
+		{want: []string{"cluster-autoscaler", "cert-manager", "datadog-agent", "fluent", "metrics-pipeline"}},
+		{tags: []string{"aws"}, want: []string{"cluster-autoscaler", "fluent"}},
+		{tags: []string{"critical", "tls"}, want: []string{"cluster-autoscaler", "cert-manager"}},
+		{tags: []string{"aws"}, skip: []string{"logging"}, want: []string{"cluster-autoscaler"}},
+		{skip: []string{"datadog-agent", "aws"}, want: []string{"cert-manager", "metrics-pipeline"}},
+	} {
+		selected, err := Select(defs, tc.tags, tc.skip)
+		require.NoError(t, err)
+		require.Equal(t, tc.want, names(selected), "tags=%v skip=%v", tc.tags, tc.skip)
+	}
+
+	_, err := Select(defs, []string{"gcp"}, nil)
+	require.ErrorContains(t, err, `unknown check or tag "gcp"`)
+}
+
+func TestRunDefinitions(t *testing.T) {
+	var ran []string
+	logger := func() *logrus.Entry { return logrus.NewEntry(logrus.New()) }
+	checker := NewChecker(context.Background(), false, logger, nil, "test", time.Minute)
+	checker.KeepGoing = true
+
+	err := checker.RunDefinitions(testDefinitions(&ran))
+	require.EqualError(t, err, "no metrics")
+	require.Equal(t, []string{"cluster-autoscaler", "datadog-agent", "fluent"}, ran)
+
+	var statuses []string
+	for _, c := range checker.Report.Checks {
+		statuses = append(statuses, c.Name+" "+string(c.Status)+" "+c.Message)
+	}
+	// datadog-agent failed before starting its check, as when its client can't be created.
+	require.Equal(t, []string{
+		"cert-manager skipped CRDs not found",
+		"datadog-agent failed ",
+		"metrics-pipeline skipped depends on datadog-agent, which failed",
+	}, statuses)
+	require.Equal(t, "no metrics", checker.Report.Checks[1].Error)
+
+	reason, ok := SkipReason(fmt.Errorf("wrapped: %w", Skip("no %s", "bucket")))
+	require.True(t, ok)
+	require.Equal(t, "no bucket", reason)
+}
+
+func TestRunDefinitionsStopsAtFirstFailure(t *testing.T) {
+	var ran []string
+	logger := func() *logrus.Entry { return logrus.NewEntry(logrus.New()) }
+	checker := NewChecker(context.Background(), false, logger, nil, "test", time.Minute)
+
+	err := checker.RunDefinitions(testDefinitions(&ran))
+	require.EqualError(t, err, "no metrics")
+	require.Equal(t, []string{"cluster-autoscaler", "datadog-agent"}, ran)
+	require.Len(t, checker.Report.Checks, 2)
+}
+
+func TestRunDefinitionsStartedFailure(t *testing.T) {
+	logger := func() *logrus.Entry { return logrus.NewEntry(logrus.New()) }
+	checker := NewChecker(context.Background(), false, logger, nil, "test", time.Minute)
+
+	err := checker.RunDefinitions([]Definition{{Name: "ingress", Check: func(c *Checker) error {
+		err := errors.New("no endpoints")
+		c.FinishCheck(c.StartCheck("ingress", "ingress-test"), err)
+		return err
+	}}})
+	require.EqualError(t, err, "no endpoints")
+	require.Len(t, checker.Report.Checks, 1)
+	require.Equal(t, "ingress-test", checker.Report.Checks[0].Namespace)
+}
+
+func TestOrder(t *testing.T) {
+	check := func(*Checker) error { return nil }
+	defs, err := order([]Definition{
+		{Name: "ingress-tls", DependsOn: []string{"cert-manager", "ingress"}, Check: check},
+		{Name: "ingress", Check: check},
+		{Name: "cert-manager", DependsOn: []string{"not-selected"}, Check: check},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"ingress", "cert-manager", "ingress-tls"}, names(defs))
+
+	_, err = order([]Definition{
+		{Name: "a", DependsOn: []string{"b"}},
+		{Name: "b", DependsOn: []string{"a"}},
+		{Name: "c"},
+	})
+	require.EqualError(t, err, "dependency cycle between a, b")
+}
+
+func TestRunDefinitionsDeadline(t *testing.T) {
+	logger := func() *logrus.Entry { return logrus.NewEntry(logrus.New()) }
+	checker := NewChecker(context.Background(), false, logger, nil, "test", time.Hour)
+	checker.Deadline = time.Now().Add(30 * time.Minute)
+
+	var timeouts []time.Duration
+	record := func(c *Checker) error {
+		timeouts = append(timeouts, c.Timeout)
+		return nil
+	}
+	exhaust := func(c *Checker) error {
+		c.Deadline = time.Now()
+		return record(c)
+	}
+	require.NoError(t, checker.RunDefinitions([]Definition{
+		{Name: "a", Check: record},
+		{Name: "b", Check: record},
+		{Name: "c", Check: exhaust},
+		{Name: "d", Check: record},
+	}))
+
+	require.Len(t, timeouts, 3)
+	require.InDelta(t, float64(30*time.Minute/4), float64(timeouts[0]), float64(time.Second))
+	require.InDelta(t, float64(30*time.Minute/3), float64(timeouts[1]), float64(time.Second))
+	require.Equal(t, time.Hour, checker.Timeout)
+	require.Equal(t, "run deadline exceeded", checker.Report.Checks[0].Message)
+}
@@ -2,6 +2,7 @@ package fluent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -11,10 +12,12 @@ import (
 	"github.com/chatwork/kibertas/config"
 	"github.com/chatwork/kibertas/util"
 	"github.com/chatwork/kibertas/util/k8s"
+	"github.com/chatwork/kibertas/util/report"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	appsv1 "k8s.io/api/apps/v1"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -24,16 +27,68 @@ import (
 	"github.com/hashicorp/go-multierror"
 )
 
+// S3ListObjectsAPI lists the objects in a bucket. *s3.Client implements it.
+type S3ListObjectsAPI interface {
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
 type Fluent struct {
 	*cmd.Checker
 	Namespace     string
-	Clientset     *kubernetes.Clientset
+	Clientset     kubernetes.Interface
 	LogBucketName string
 	LogPath       string
 	UsePathStyle  bool
 	ResourceName  string
 	ReplicaCount  int
 	Awscfg        aws.Config
+	// S3Client is used to find the logs fluentd shipped to LogBucketName.
+	S3Client S3ListObjectsAPI
+
+	result *report.CheckResult
+}
+
+// Prerequisites skips the check when there is no S3 bucket for fluentd to ship logs to.
+// Other errors, such as a broken AWS config, fail it.
+func Prerequisites(checker *cmd.Checker) error {
+	awsConfig, err := config.NewAwsConfig(checker.Ctx)
+	if err != nil {
+		return fmt.Errorf("NewAwsConfig: %s", err)
+	}
+	logBucketName, usePathStyle := bucketFromEnv()
+	s3Client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		o.UsePathStyle = usePathStyle
+	})
+	return prerequisites(checker.Ctx, s3Client, logBucketName)
+}
+
+func prerequisites(ctx context.Context, s3Client S3ListObjectsAPI, bucket string) error {
+	_, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		MaxKeys: aws.Int32(1),
+	})
+	var noSuchBucket *types.NoSuchBucket
+	if errors.As(err, &noSuchBucket) {
+		return cmd.Skip("S3 bucket %s for fluentd logs not found", bucket)
+	}
+	return err
+}
+
+// bucketFromEnv returns the bucket fluentd ships logs to, from LOG_BUCKET_NAME and USE_PATH_STYLE.
+func bucketFromEnv() (string, bool) {
+	logBucketName := "kubernetes-logs"
+	if v := os.Getenv("LOG_BUCKET_NAME"); v != "" {
+		logBucketName = v
+	}
+
+	usePathStyle := false
+	if v := os.Getenv("USE_PATH_STYLE"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err == nil {
+			usePathStyle = b
+		}
+	}
+	return logBucketName, usePathStyle
 }
 
 func NewFluent(checker *cmd.Checker) (*Fluent, error) {
@@ -44,20 +99,12 @@ func NewFluent(checker *cmd.Checker) (*Fluent, error) {
 		namespace = v
 	}
 
-	location, _ := time.LoadLocation("Asia/Tokyo")
-	checker.Chatwork.AddMessage(fmt.Sprintf("Start in %s at %s\n", checker.ClusterName, time.Now().In(location).Format("2006-01-02 15:04:05")))
-
 	checker.Logger().Infof("fluent check application Namespace: %s", namespace)
-	checker.Chatwork.AddMessage(fmt.Sprintf("fluent check application Namespace: %s\n", namespace))
 
 	resourceName := "burst-log-generator"
 
 	env := "test"
 
-	logBucketName := "kubernetes-logs"
-
-	usePathStyle := false
-
 	if v := os.Getenv("RESOURCE_NAME"); v != "" {
 		resourceName = v
 	}
@@ -66,19 +113,6 @@ func NewFluent(checker *cmd.Checker) (*Fluent, error) {
 		env = v
 	}
 
-	if v := os.Getenv("LOG_BUCKET_NAME"); v != "" {
-		logBucketName = v
-	}
-
-	if v := os.Getenv("USE_PATH_STYLE"); v != "" {
-		b, err := strconv.ParseBool(v)
-		if err != nil {
-			usePathStyle = false
-		} else {
-			usePathStyle = b
-		}
-	}
-
 	// path s3bucket/fluentd/env(test,stg,etc...)/namespace/dt=yyyymmdd
 	logPath := fmt.Sprintf("fluentd/%s/%s/dt=%d%02d%02d", env, namespace, t.UTC().Year(), t.UTC().Month(), t.UTC().Day())
 	if v := os.Getenv("LOG_PATH"); v != "" {
@@ -95,6 +129,8 @@ func NewFluent(checker *cmd.Checker) (*Fluent, error) {
 		return nil, fmt.Errorf("NewAwsConfig: %s", err)
 	}
 
+	logBucketName, usePathStyle := bucketFromEnv()
+
 	return &Fluent{
 		Checker:       checker,
 		Namespace:     namespace,
@@ -104,12 +140,15 @@ func NewFluent(checker *cmd.Checker) (*Fluent, error) {
 		LogPath:       logPath,
 		UsePathStyle:  usePathStyle,
 		Awscfg:        awsConfig,
+		S3Client: s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+			o.UsePathStyle = usePathStyle
+		}),
 	}, nil
 }
 
-func (f *Fluent) Check() error {
-	f.Chatwork.AddMessage("fluent check start\n")
-	defer f.Chatwork.Send()
+func (f *Fluent) Check() (err error) {
+	f.result = f.StartCheck("fluent", f.Namespace)
+	defer func() { f.FinishCheck(f.result, err) }()
 
 	nodeListOption := metav1.ListOptions{
 		LabelSelector: "eks.amazonaws.com/capacityType=SPOT",
@@ -118,17 +157,23 @@ func (f *Fluent) Check() error {
 	nodes, err := f.Clientset.CoreV1().Nodes().List(f.Ctx, nodeListOption)
 	if err != nil {
 		f.Logger().Errorf("Error List Nodes: %s", err)
-		f.Chatwork.AddMessage(fmt.Sprintf("Error List Nodes: %s\n", err))
+		f.Notify(f.result, fmt.Sprintf("Error List Nodes: %s", err))
 		return err
 	}
 
 	f.ReplicaCount = (len(nodes.Items) / 3) + 1
 	f.Logger().Infof("%s replica counts: %d", f.ResourceName, f.ReplicaCount)
-	f.Chatwork.AddMessage(fmt.Sprintf("%s replica counts: %d\n", f.ResourceName, f.ReplicaCount))
+	f.Notify(f.result, fmt.Sprintf("%s replica counts: %d", f.ResourceName, f.ReplicaCount))
+
+	defer func() {
+		if err := f.result.Step("clean up resources", f.cleanUpResources); err != nil {
+			f.Notify(f.result, fmt.Sprintf("Error Delete Resources: %s", err))
+		}
+	}()
 
 	defer func() {
-		if err := f.cleanUpResources(); err != nil {
-			f.Chatwork.AddMessage(fmt.Sprintf("Error Delete Resources: %s\n", err))
+		if err != nil {
+			f.CollectDiagnostics(k8s.NewK8s(f.Namespace, f.Clientset, f.Logger), f.result)
 		}
 	}()
 
@@ -136,29 +181,32 @@ func (f *Fluent) Check() error {
 		return err
 	}
 
-	if err := f.checkS3Object(); err != nil {
+	if err := f.result.Step("check s3 object", f.checkS3Object); err != nil {
 		return err
 	}
 
-	f.Chatwork.AddMessage("fluent check finished\n")
 	return nil
 }
 
 func (f *Fluent) createResources() error {
 	k := k8s.NewK8s(f.Namespace, f.Clientset, f.Logger)
 
-	if err := k.CreateNamespace(
-		f.Ctx,
-		&apiv1.Namespace{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: f.Namespace,
-			}}); err != nil {
-		f.Chatwork.AddMessage(fmt.Sprintf("Error Create Namespace: %s\n", err))
+	if err := f.result.Step("create namespace", func() error {
+		return k.CreateNamespace(
+			f.Ctx,
+			&apiv1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: f.Namespace,
+				}})
+	}); err != nil {
+		f.Notify(f.result, fmt.Sprintf("Error Create Namespace: %s", err))
 		return err
 	}
 
-	if err := k.CreateDeployment(f.Ctx, f.createDeploymentObject(), f.Timeout); err != nil {
-		f.Chatwork.AddMessage(fmt.Sprintf("Error Create Deployment: %s\n", err))
+	if err := f.result.Step("create deployment", func() error {
+		return k.CreateDeployment(f.Ctx, f.createDeploymentObject(), f.Timeout)
+	}); err != nil {
+		f.Notify(f.result, fmt.Sprintf("Error Create Deployment: %s", err))
 		return err
 	}
 
@@ -168,7 +216,7 @@ func (f *Fluent) createResources() error {
 func (f *Fluent) cleanUpResources() error {
 	if f.Debug {
 		f.Logger().Info("Skip Delete Resources")
-		f.Chatwork.AddMessage("Skip Delete Resources\n")
+		f.Notify(f.result, "Skip Delete Resources")
 		return nil
 	}
 	k := k8s.NewK8s(f.Namespace, f.Clientset, f.Logger)
@@ -176,21 +224,24 @@ func (f *Fluent) cleanUpResources() error {
 	var err error
 
 	if err = k.DeleteDeployment(f.ResourceName); err != nil {
-		f.Chatwork.AddMessage(fmt.Sprintf("Error Delete Deployment: %s", err))
+		f.Notify(f.result, fmt.Sprintf("Error Delete Deployment: %s", err))
 		result = multierror.Append(result, err)
 	}
 
-	if err = k.DeleteNamespace(); err != nil {
-		f.Chatwork.AddMessage(fmt.Sprintf("Error Delete Namespace: %s", err))
+	if err = f.DeleteNamespace(k); err != nil {
+		f.Notify(f.result, fmt.Sprintf("Error Delete Namespace: %s", err))
 		result = multierror.Append(result, err)
 	}
 	return result.ErrorOrNil()
 }
 
 func (f *Fluent) checkS3Object() error {
-	client := s3.NewFromConfig(f.Awscfg, func(o *s3.Options) {
-		o.UsePathStyle = f.UsePathStyle
-	})
+	client := f.S3Client
+	if client == nil {
+		client = s3.NewFromConfig(f.Awscfg, func(o *s3.Options) {
+			o.UsePathStyle = f.UsePathStyle
+		})
+	}
 	t := time.Now()
 	targetBucket := f.LogBucketName
 	targetPrefix := f.LogPath
@@ -200,7 +251,7 @@ func (f *Fluent) checkS3Object() error {
 		Prefix: aws.String(targetPrefix),
 	}
 
-	err := wait.PollUntilContextTimeout(f.Ctx, 60*time.Second, f.Timeout, false, func(ctx context.Context) (bool, error) {
+	err := wait.PollUntilContextTimeout(f.Ctx, 60*time.Second, f.Timeout, true, func(ctx context.Context) (bool, error) {
 		f.Logger().Infof("Wait fluentd output to s3://%s/%s ...", targetBucket, targetPrefix)
 
 		result, err := client.ListObjectsV2(ctx, input)
@@ -212,7 +263,12 @@ func (f *Fluent) checkS3Object() error {
 		if len(result.Contents) != 0 {
 			for _, item := range result.Contents {
 				if item.LastModified.After(t) {
-					f.Chatwork.AddMessage(fmt.Sprintf("fluentd output to s3://%s/%s/%s\n", targetBucket, targetPrefix, *item.Key))
+					f.Notify(f.result, fmt.Sprintf("fluentd output to s3://%s/%s/%s", targetBucket, targetPrefix, *item.Key))
+					f.result.AddArtifact(report.Artifact{
+						Kind:    report.KindS3Object,
+						Name:    fmt.Sprintf("s3://%s/%s", targetBucket, *item.Key),
+						Content: fmt.Sprintf("LastModified: %s\nSize: %d\nStorageClass: %s\n", *item.LastModified, aws.ToInt64(item.Size), item.StorageClass),
+					})
 					f.Logger().Infof("Name: %s ", *item.Key)
 					f.Logger().Infof("Last modified: %s", *item.LastModified)
 					f.Logger().Infof("Size: %d", item.Size)
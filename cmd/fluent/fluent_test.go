@@ -12,10 +12,16 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/chatwork/kibertas/cmd"
 	"github.com/chatwork/kibertas/internal/ktesting"
 	"github.com/chatwork/kibertas/util/notify"
+	"github.com/chatwork/kibertas/util/report"
 	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
 
 	"github.com/mumoshu/testkit"
 	"github.com/sirupsen/logrus"
@@ -262,3 +268,102 @@ func mustSetenv(t *testing.T, key, value string) {
 		t.Fatalf("os.Setenv %s=%s: %s", key, value, err)
 	}
 }
+
+type fakeS3 struct {
+	objects []types.Object
+	err     error
+}
+
+func (f *fakeS3) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	return &s3.ListObjectsV2Output{Contents: f.objects}, f.err
+}
+
+func newTestFluent(clientset kubernetes.Interface, s3Client S3ListObjectsAPI, timeout time.Duration) *Fluent {
+	logger := func() *logrus.Entry {
+		return logrus.NewEntry(logrus.New())
+	}
+	return &Fluent{
+		Checker:       cmd.NewChecker(context.Background(), false, logger, nil, "test", timeout),
+		Namespace:     "fluent-test",
+		Clientset:     clientset,
+		ResourceName:  "burst-log-generator",
+		LogBucketName: "kubernetes-logs",
+		LogPath:       "fluentd/test/fluent-test",
+		S3Client:      s3Client,
+	}
+}
+
+func TestFluentCheck(t *testing.T) {
+	spot := map[string]string{"eks.amazonaws.com/capacityType": "SPOT"}
+	clientset := ktesting.NewReadyClientset(
+		ktesting.Node("node-1", spot), ktesting.Node("node-2", spot), ktesting.Node("node-3", spot),
+		ktesting.Node("node-4", map[string]string{"eks.amazonaws.com/capacityType": "ON_DEMAND"}),
+	)
+	s3Client := &fakeS3{objects: []types.Object{{
+		Key:          aws.String("fluentd/test/fluent-test/log.gz"),
+		LastModified: aws.Time(time.Now().Add(time.Minute)),
+		Size:         aws.Int64(128),
+	}}}
+	f := newTestFluent(clientset, s3Client, time.Minute)
+
+	require.NoError(t, f.Check())
+	require.Equal(t, 2, f.ReplicaCount)
+	require.Equal(t, report.StatusPassed, f.result.Status)
+	require.Equal(t, []report.Artifact{{
+		Kind:    report.KindS3Object,
+		Name:    "s3://kubernetes-logs/fluentd/test/fluent-test/log.gz",
+		Content: f.result.Artifacts[0].Content,
+	}}, f.result.Artifacts)
+	require.True(t, ktesting.Deleted(clientset, "deployments", "burst-log-generator"))
+	require.True(t, ktesting.Deleted(clientset, "namespaces", "fluent-test"))
+}
+
+func TestFluentCheckNoLogs(t *testing.T) {
+	// Only logs shipped after the check started count.
+	s3Client := &fakeS3{objects: []types.Object{{
+		Key:          aws.String("fluentd/test/fluent-test/old.gz"),
+		LastModified: aws.Time(time.Now().Add(-time.Hour)),
+	}}}
+	clientset := ktesting.NewReadyClientset()
+	f := newTestFluent(clientset, s3Client, 100*time.Millisecond)
+
+	require.ErrorContains(t, f.Check(), "error waiting for S3 objects to be ready")
+	require.Equal(t, "check s3 object", f.result.FailedStep().Name)
+	require.True(t, ktesting.Deleted(clientset, "namespaces", "fluent-test"))
+}
+
+func TestFluentCheckDeploymentTimeout(t *testing.T) {
+	clientset := fake.NewClientset()
+	f := newTestFluent(clientset, &fakeS3{}, 100*time.Millisecond)
+
+	require.ErrorContains(t, f.Check(), "waiting for Pods to be ready")
+	require.Equal(t, "create deployment", f.result.FailedStep().Name)
+	// The deployment is collected for triage before it is cleaned up.
+	var kinds []report.ArtifactKind
+	for _, a := range f.result.Artifacts {
+		kinds = append(kinds, a.Kind)
+	}
+	require.Contains(t, kinds, report.KindObjects)
+	require.True(t, ktesting.Deleted(clientset, "deployments", "burst-log-generator"))
+	require.True(t, ktesting.Deleted(clientset, "namespaces", "fluent-test"))
+}
+
+func TestFluentCheckDebugKeepsResources(t *testing.T) {
+	clientset := ktesting.NewReadyClientset()
+	f := newTestFluent(clientset, &fakeS3{objects: []types.Object{{
+		Key:          aws.String("fluentd/test/fluent-test/log.gz"),
+		LastModified: aws.Time(time.Now().Add(time.Minute)),
+	}}}, time.Minute)
+	f.Debug = true
+
+	require.NoError(t, f.Check())
+	require.False(t, ktesting.Deleted(clientset, "namespaces", "fluent-test"))
+}
+
+func TestPrerequisites(t *testing.T) {
+	require.NoError(t, prerequisites(context.Background(), &fakeS3{}, "kubernetes-logs"))
+
+	reason, ok := cmd.SkipReason(prerequisites(context.Background(), &fakeS3{err: &types.NoSuchBucket{}}, "kubernetes-logs"))
+	require.True(t, ok)
+	require.Equal(t, "S3 bucket kubernetes-logs for fluentd logs not found", reason)
+}
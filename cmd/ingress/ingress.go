@@ -3,19 +3,23 @@ package ingress
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/chatwork/kibertas/cmd"
 	"github.com/chatwork/kibertas/config"
 	"github.com/chatwork/kibertas/util"
 	"github.com/chatwork/kibertas/util/k8s"
+	"github.com/chatwork/kibertas/util/report"
 
 	"github.com/miekg/dns"
 	appsv1 "k8s.io/api/apps/v1"
 	apiv1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -24,10 +28,15 @@ import (
 	"github.com/hashicorp/go-multierror"
 )
 
+// DNSExchanger sends a DNS query to a server. *dns.Client implements it.
+type DNSExchanger interface {
+	Exchange(m *dns.Msg, address string) (r *dns.Msg, rtt time.Duration, err error)
+}
+
 type Ingress struct {
 	*cmd.Checker
 	Namespace        string
-	Clientset        *kubernetes.Clientset
+	Clientset        kubernetes.Interface
 	NoDnsCheck       bool
 	NoHTTPCheck      bool
 	IngressClassName string
@@ -38,6 +47,40 @@ type Ingress struct {
 	// This is usually set to the LoadBalancer IP of the Ingress Controller Service,
 	// in case the external hostname is not resolvable.
 	HTTPCheckEndpoint string
+	// HTTPClient requests HTTPCheckEndpoint.
+	HTTPClient *http.Client
+	// DNSClient resolves ExternalHostname against DNSServer.
+	DNSClient DNSExchanger
+	DNSServer string
+
+	result *report.CheckResult
+}
+
+// DefaultDNSServer is the resolver used to check that the Ingress record has been published.
+const DefaultDNSServer = "8.8.8.8:53"
+
+// Prerequisites skips the check when there is no IngressClass named INGRESS_CLASS_NAME.
+func Prerequisites(checker *cmd.Checker) error {
+	k8sclient, err := config.NewK8sClientset()
+	if err != nil {
+		return fmt.Errorf("error NewK8sClientset: %s", err)
+	}
+	return prerequisites(checker.Ctx, k8sclient, ingressClassName())
+}
+
+func prerequisites(ctx context.Context, clientset kubernetes.Interface, className string) error {
+	_, err := clientset.NetworkingV1().IngressClasses().Get(ctx, className, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return cmd.Skip("IngressClass %s not found", className)
+	}
+	return err
+}
+
+func ingressClassName() string {
+	if v := os.Getenv("INGRESS_CLASS_NAME"); v != "" {
+		return v
+	}
+	return "alb"
 }
 
 func NewIngress(checker *cmd.Checker, noDnsCheck bool) (*Ingress, error) {
@@ -45,15 +88,10 @@ func NewIngress(checker *cmd.Checker, noDnsCheck bool) (*Ingress, error) {
 
 	namespace := fmt.Sprintf("ingress-test-%d%02d%02d-%s", t.Year(), t.Month(), t.Day(), util.GenerateRandomString(5))
 
-	location, _ := time.LoadLocation("Asia/Tokyo")
-	checker.Chatwork.AddMessage(fmt.Sprintf("Start in %s at %s\n", checker.ClusterName, time.Now().In(location).Format("2006-01-02 15:04:05")))
-
 	checker.Logger().Infof("Ingress check application Namespace: %s", namespace)
-	checker.Chatwork.AddMessage(fmt.Sprintf("Ingress check application Namespace: %s\n", namespace))
 
 	resourceName := "sample"
 	externalHostName := "example.local"
-	ingressClassName := "alb"
 
 	if v := os.Getenv("RESOURCE_NAME"); v != "" {
 		resourceName = v
@@ -62,10 +100,6 @@ func NewIngress(checker *cmd.Checker, noDnsCheck bool) (*Ingress, error) {
 		externalHostName = v
 	}
 
-	if v := os.Getenv("INGRESS_CLASS_NAME"); v != "" {
-		ingressClassName = v
-	}
-
 	k8sclient, err := config.NewK8sClientset()
 	if err != nil {
 		return nil, fmt.Errorf("error NewK8sClientset: %s", err)
@@ -75,20 +109,29 @@ func NewIngress(checker *cmd.Checker, noDnsCheck bool) (*Ingress, error) {
 		Checker:          checker,
 		Namespace:        namespace,
 		Clientset:        k8sclient,
+		DNSClient:        new(dns.Client),
+		DNSServer:        DefaultDNSServer,
+		HTTPClient:       http.DefaultClient,
 		ResourceName:     resourceName,
 		NoDnsCheck:       noDnsCheck,
-		IngressClassName: ingressClassName,
+		IngressClassName: ingressClassName(),
 		ExternalHostname: externalHostName,
 	}, nil
 }
 
-func (i *Ingress) Check() error {
-	i.Chatwork.AddMessage("Ingress check start\n")
-	defer i.Chatwork.Send()
+func (i *Ingress) Check() (err error) {
+	i.result = i.StartCheck("ingress", i.Namespace)
+	defer func() { i.FinishCheck(i.result, err) }()
+
+	defer func() {
+		if err := i.result.Step("clean up resources", i.cleanUpResources); err != nil {
+			i.Notify(i.result, fmt.Sprintf("Error Delete Resources: %s", err))
+		}
+	}()
 
 	defer func() {
-		if err := i.cleanUpResources(); err != nil {
-			i.Chatwork.AddMessage(fmt.Sprintf("Error Delete Resources: %s\n", err))
+		if err != nil {
+			i.CollectDiagnostics(k8s.NewK8s(i.Namespace, i.Clientset, i.Logger), i.result)
 		}
 	}()
 
@@ -97,50 +140,59 @@ func (i *Ingress) Check() error {
 	}
 
 	if i.NoDnsCheck {
-		i.Chatwork.AddMessage("Skip Dns Check\n")
+		i.Notify(i.result, "Skip Dns Check")
 		i.Logger().Info("Skip Dns Check")
+		i.result.SkipStep("check dns record", "disabled by --no-dns-check")
 	} else {
-		if err := i.checkDNSRecord(); err != nil {
+		if err := i.result.Step("check dns record", i.checkDNSRecord); err != nil {
 			return err
 		}
 	}
 
 	if i.NoHTTPCheck {
-		i.Chatwork.AddMessage("Skip HTTP Check\n")
+		i.Notify(i.result, "Skip HTTP Check")
 		i.Logger().Info("Skip HTTP Check")
+		i.result.SkipStep("check http", "disabled")
 	} else {
-		if err := i.checkHTTP(); err != nil {
+		if err := i.result.Step("check http", i.checkHTTP); err != nil {
 			return err
 		}
 
 	}
 
-	i.Chatwork.AddMessage("Ingress check finished\n")
 	return nil
 }
 
 func (i *Ingress) createResources() error {
 	k := k8s.NewK8s(i.Namespace, i.Clientset, i.Logger)
 
-	if err := k.CreateNamespace(
-		i.Ctx,
-		&apiv1.Namespace{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: i.Namespace,
-			}}); err != nil {
-		i.Chatwork.AddMessage(fmt.Sprintf("Error Create Namespace: %s\n", err))
+	if err := i.result.Step("create namespace", func() error {
+		return k.CreateNamespace(
+			i.Ctx,
+			&apiv1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: i.Namespace,
+				}})
+	}); err != nil {
+		i.Notify(i.result, fmt.Sprintf("Error Create Namespace: %s", err))
 		return err
 	}
-	if err := k.CreateDeployment(i.Ctx, i.createDeploymentObject(), i.Timeout); err != nil {
-		i.Chatwork.AddMessage(fmt.Sprintf("Error Create Deployment: %s\n", err))
+	if err := i.result.Step("create deployment", func() error {
+		return k.CreateDeployment(i.Ctx, i.createDeploymentObject(), i.Timeout)
+	}); err != nil {
+		i.Notify(i.result, fmt.Sprintf("Error Create Deployment: %s", err))
 		return err
 	}
-	if err := k.CreateService(i.Ctx, i.createServiceObject()); err != nil {
-		i.Chatwork.AddMessage(fmt.Sprintf("Error Create Service: %s\n", err))
+	if err := i.result.Step("create service", func() error {
+		return k.CreateService(i.Ctx, i.createServiceObject())
+	}); err != nil {
+		i.Notify(i.result, fmt.Sprintf("Error Create Service: %s", err))
 		return err
 	}
-	if err := k.CreateIngress(i.Ctx, i.createIngressObject(), i.Timeout); err != nil {
-		i.Chatwork.AddMessage(fmt.Sprintf("Error Create Ingress: %s\n", err))
+	if err := i.result.Step("create ingress", func() error {
+		return k.CreateIngress(i.Ctx, i.createIngressObject(), i.Timeout)
+	}); err != nil {
+		i.Notify(i.result, fmt.Sprintf("Error Create Ingress: %s", err))
 		return err
 	}
 	return nil
@@ -149,29 +201,29 @@ func (i *Ingress) createResources() error {
 func (i *Ingress) cleanUpResources() error {
 	if i.Debug {
 		i.Logger().Info("Skip Delete Resources")
-		i.Chatwork.AddMessage("Skip Delete Resources\n")
+		i.Notify(i.result, "Skip Delete Resources")
 		return nil
 	}
 	k := k8s.NewK8s(i.Namespace, i.Clientset, i.Logger)
 	var result *multierror.Error
 	var err error
 	if err = k.DeleteIngress(i.ResourceName); err != nil {
-		i.Chatwork.AddMessage(fmt.Sprintf("Error Delete Ingress: %s\n", err))
+		i.Notify(i.result, fmt.Sprintf("Error Delete Ingress: %s", err))
 		result = multierror.Append(result, err)
 	}
 
 	if err = k.DeleteService(i.ResourceName); err != nil {
-		i.Chatwork.AddMessage(fmt.Sprintf("Error Delete Service: %s\n", err))
+		i.Notify(i.result, fmt.Sprintf("Error Delete Service: %s", err))
 		result = multierror.Append(result, err)
 	}
 
 	if err = k.DeleteDeployment(i.ResourceName); err != nil {
-		i.Chatwork.AddMessage(fmt.Sprintf("Error Delete Deployment: %s\n", err))
+		i.Notify(i.result, fmt.Sprintf("Error Delete Deployment: %s", err))
 		result = multierror.Append(result, err)
 	}
 
-	if err = k.DeleteNamespace(); err != nil {
-		i.Chatwork.AddMessage(fmt.Sprintf("Error Delete Namespace: %s\n", err))
+	if err = i.DeleteNamespace(k); err != nil {
+		i.Notify(i.result, fmt.Sprintf("Error Delete Namespace: %s", err))
 		result = multierror.Append(result, err)
 	}
 	return result.ErrorOrNil()
@@ -289,13 +341,20 @@ func (i *Ingress) createIngressObject() *networkingv1.Ingress {
 }
 
 func (i *Ingress) checkDNSRecord() error {
-	c := new(dns.Client)
+	c := i.DNSClient
+	if c == nil {
+		c = new(dns.Client)
+	}
+	server := i.DNSServer
+	if server == "" {
+		server = DefaultDNSServer
+	}
 	m := new(dns.Msg)
 
 	i.Logger().Infof("Check DNS Record for: %s", i.ExternalHostname)
-	err := wait.PollUntilContextTimeout(i.Ctx, 30*time.Second, i.Timeout, false, func(ctx context.Context) (bool, error) {
+	err := wait.PollUntilContextTimeout(i.Ctx, 30*time.Second, i.Timeout, true, func(ctx context.Context) (bool, error) {
 		m.SetQuestion(dns.Fqdn(i.ExternalHostname), dns.TypeA)
-		r, _, err := c.Exchange(m, "8.8.8.8:53")
+		r, _, err := c.Exchange(m, server)
 
 		if err != nil {
 			i.Logger().Warn(err)
@@ -310,7 +369,8 @@ func (i *Ingress) checkDNSRecord() error {
 		for _, ans := range r.Answer {
 			if a, ok := ans.(*dns.A); ok {
 				i.Logger().Infof("Record is available: %s", a.A)
-				i.Chatwork.AddMessage(fmt.Sprintf("Record is available: %s\n", a.A))
+				i.Notify(i.result, fmt.Sprintf("Record is available: %s", a.A))
+				i.result.AddArtifact(report.Artifact{Kind: report.KindDNSAnswer, Name: i.ExternalHostname, Content: r.String()})
 				return true, nil
 			}
 		}
@@ -337,7 +397,7 @@ func (i *Ingress) checkHTTP() error {
 	}
 
 	i.Logger().Infof("Check HTTP for: %s", endpoint)
-	err := wait.PollUntilContextTimeout(i.Ctx, 10*time.Second, i.Timeout, false, func(ctx context.Context) (bool, error) {
+	err := wait.PollUntilContextTimeout(i.Ctx, 10*time.Second, i.Timeout, true, func(ctx context.Context) (bool, error) {
 		req, err := http.NewRequest("GET", endpoint, nil)
 		if err != nil {
 			return false, err
@@ -346,11 +406,20 @@ func (i *Ingress) checkHTTP() error {
 
 		i.Logger().Infof("Requesting %s with headers %v", endpoint, req.Header)
 
-		resp, err := http.DefaultClient.Do(req)
+		client := i.HTTPClient
+		if client == nil {
+			client = http.DefaultClient
+		}
+		resp, err := client.Do(req)
 		if err != nil {
 			i.Logger().Warn(err)
 			return false, nil
 		}
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				i.Logger().Warn(err)
+			}
+		}()
 
 		if resp.StatusCode != 200 {
 			i.Logger().Infof("HTTP Status Code is not 200: %d", resp.StatusCode)
@@ -358,7 +427,8 @@ func (i *Ingress) checkHTTP() error {
 		}
 
 		i.Logger().Info("HTTP Status Code is 200")
-		i.Chatwork.AddMessage("HTTP Status Code is 200\n")
+		i.Notify(i.result, "HTTP Status Code is 200")
+		i.result.AddArtifact(report.Artifact{Kind: report.KindHTTPResponse, Name: endpoint, URL: endpoint, Content: dumpResponse(resp)})
 		return true, nil
 	})
 
@@ -368,3 +438,14 @@ func (i *Ingress) checkHTTP() error {
 
 	return nil
 }
+
+// dumpResponse formats the status, headers and the beginning of the body
+// of resp for the report.
+func dumpResponse(resp *http.Response) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s\n", resp.Proto, resp.Status)
+	_ = resp.Header.Write(&b)
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+	fmt.Fprintf(&b, "\n%s", body)
+	return b.String()
+}
@@ -3,6 +3,8 @@ package ingress
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"syscall"
@@ -13,10 +15,18 @@ import (
 	"github.com/chatwork/kibertas/config"
 	"github.com/chatwork/kibertas/internal/ktesting"
 	"github.com/chatwork/kibertas/util/notify"
+	"github.com/chatwork/kibertas/util/report"
+	"github.com/miekg/dns"
 	"github.com/mumoshu/testkit"
 	"github.com/stretchr/testify/require"
 
 	"github.com/sirupsen/logrus"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestIngressCheckE2E(t *testing.T) {
@@ -182,8 +192,119 @@ func TestIngressCheckDNSRecord(t *testing.T) {
 	}
 }
 
+func TestPrerequisites(t *testing.T) {
+	clientset := fake.NewClientset(&networkingv1.IngressClass{ObjectMeta: metav1.ObjectMeta{Name: "alb"}})
+
+	require.NoError(t, prerequisites(context.Background(), clientset, "alb"))
+
+	reason, ok := cmd.SkipReason(prerequisites(context.Background(), clientset, "nginx"))
+	require.True(t, ok)
+	require.Equal(t, "IngressClass nginx not found", reason)
+}
+
 func mustSetenv(t *testing.T, key, value string) {
 	if err := os.Setenv(key, value); err != nil {
 		t.Fatalf("os.Setenv %s=%s: %s", key, value, err)
 	}
 }
+
+type fakeDNS struct {
+	answer string
+}
+
+func (f *fakeDNS) Exchange(m *dns.Msg, address string) (*dns.Msg, time.Duration, error) {
+	r := new(dns.Msg)
+	r.SetReply(m)
+	if f.answer != "" {
+		rr, err := dns.NewRR(fmt.Sprintf("%s 60 IN A %s", m.Question[0].Name, f.answer))
+		if err != nil {
+			return nil, 0, err
+		}
+		r.Answer = append(r.Answer, rr)
+	}
+	return r, time.Millisecond, nil
+}
+
+func newTestIngress(clientset kubernetes.Interface, dnsClient DNSExchanger, endpoint string, timeout time.Duration) *Ingress {
+	logger := func() *logrus.Entry {
+		return logrus.NewEntry(logrus.New())
+	}
+	return &Ingress{
+		Checker:           cmd.NewChecker(context.Background(), false, logger, nil, "test", timeout),
+		Namespace:         "ingress-test",
+		Clientset:         clientset,
+		IngressClassName:  "alb",
+		ResourceName:      "sample",
+		ExternalHostname:  "sample.example.com",
+		HTTPCheckEndpoint: endpoint,
+		HTTPClient:        http.DefaultClient,
+		DNSClient:         dnsClient,
+		DNSServer:         "192.0.2.53:53",
+	}
+}
+
+func TestIngressCheck(t *testing.T) {
+	var host string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+		_, _ = w.Write([]byte("Welcome to nginx!"))
+	}))
+	defer ts.Close()
+
+	clientset := ktesting.NewReadyClientset()
+	i := newTestIngress(clientset, &fakeDNS{answer: "192.0.2.1"}, ts.URL, time.Minute)
+
+	require.NoError(t, i.Check())
+	require.Equal(t, "sample.example.com", host)
+	require.Equal(t, report.StatusPassed, i.result.Status)
+	require.Len(t, i.result.Artifacts, 2)
+	require.Equal(t, report.KindDNSAnswer, i.result.Artifacts[0].Kind)
+	require.Contains(t, i.result.Artifacts[0].Content, "192.0.2.1")
+	require.Equal(t, report.KindHTTPResponse, i.result.Artifacts[1].Kind)
+	require.Contains(t, i.result.Artifacts[1].Content, "Welcome to nginx!")
+
+	for _, resource := range []string{"ingresses", "services", "deployments"} {
+		require.True(t, ktesting.Deleted(clientset, resource, "sample"), resource)
+	}
+	require.True(t, ktesting.Deleted(clientset, "namespaces", "ingress-test"))
+}
+
+func TestIngressCheckSkipsChecks(t *testing.T) {
+	i := newTestIngress(ktesting.NewReadyClientset(), nil, "", time.Minute)
+	i.NoDnsCheck = true
+	i.NoHTTPCheck = true
+
+	require.NoError(t, i.Check())
+	var skipped []string
+	for _, s := range i.result.Steps {
+		if s.Status == report.StatusSkipped {
+			skipped = append(skipped, s.Name)
+		}
+	}
+	require.Equal(t, []string{"check dns record", "check http"}, skipped)
+}
+
+func TestIngressCheckDNSTimeout(t *testing.T) {
+	clientset := ktesting.NewReadyClientset()
+	i := newTestIngress(clientset, &fakeDNS{}, "", 100*time.Millisecond)
+
+	require.ErrorContains(t, i.Check(), "waiting for DNS Record to be ready")
+	require.Equal(t, "check dns record", i.result.FailedStep().Name)
+	require.True(t, ktesting.Deleted(clientset, "namespaces", "ingress-test"))
+}
+
+func TestIngressCheckLoadBalancerTimeout(t *testing.T) {
+	// Deployments become ready, but no controller gives the Ingress an address.
+	clientset := ktesting.NewReadyClientset()
+	clientset.PrependReactor("get", "ingresses", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		get := action.(k8stesting.GetAction)
+		obj, err := clientset.Tracker().Get(get.GetResource(), get.GetNamespace(), get.GetName())
+		return true, obj, err
+	})
+	i := newTestIngress(clientset, &fakeDNS{answer: "192.0.2.1"}, "", 100*time.Millisecond)
+
+	require.ErrorContains(t, i.Check(), "waiting for Ingress to be ready")
+	require.Equal(t, "create ingress", i.result.FailedStep().Name)
+	require.True(t, ktesting.Deleted(clientset, "ingresses", "sample"))
+	require.True(t, ktesting.Deleted(clientset, "namespaces", "ingress-test"))
+}
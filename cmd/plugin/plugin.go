@@ -0,0 +1,253 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/chatwork/kibertas/cmd"
+	"github.com/chatwork/kibertas/config"
+	"github.com/chatwork/kibertas/util"
+	"github.com/chatwork/kibertas/util/k8s"
+	"github.com/chatwork/kibertas/util/report"
+)
+
+// Prefix is the file name prefix of plugin executables.
+// kibertas-check-foo is run by `kibertas test foo`.
+const Prefix = "kibertas-check-"
+
+// Input is written to the plugin's stdin as JSON.
+// The same values are also passed as KIBERTAS_* environment variables.
+type Input struct {
+	Name           string `json:"name"`
+	Kubeconfig     string `json:"kubeconfig,omitempty"`
+	Namespace      string `json:"namespace"`
+	RunID          string `json:"runId"`
+	ClusterName    string `json:"clusterName"`
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+	Debug          bool   `json:"debug"`
+}
+
+// Output is what a plugin prints to stdout as JSON.
+// Steps and artifacts use the same schema as the JSON report.
+// The check fails when the plugin exits non-zero or any step failed.
+type Output struct {
+	Steps     []*report.Step    `json:"steps"`
+	Artifacts []report.Artifact `json:"artifacts,omitempty"`
+	// Error is a summary of the failure, used instead of the exit status in messages.
+	Error string `json:"error,omitempty"`
+}
+
+// Discover returns the plugins found in dirs, keyed by check name.
+// When several dirs have the same plugin, the first one wins, as with PATH lookup.
+func Discover(dirs []string) map[string]string {
+	plugins := map[string]string{}
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name, ok := strings.CutPrefix(e.Name(), Prefix)
+			if !ok || name == "" || e.IsDir() {
+				continue
+			}
+			if _, ok := plugins[name]; ok {
+				continue
+			}
+			path := filepath.Join(dir, e.Name())
+			if info, err := os.Stat(path); err != nil || info.Mode()&0o111 == 0 {
+				continue
+			}
+			plugins[name] = path
+		}
+	}
+	return plugins
+}
+
+// Names returns the sorted check names of the discovered plugins.
+func Names(plugins map[string]string) []string {
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Plugin runs an external executable as a check.
+type Plugin struct {
+	*cmd.Checker
+	Name      string
+	Path      string
+	Namespace string
+	Clientset kubernetes.Interface
+
+	result *report.CheckResult
+}
+
+func NewPlugin(checker *cmd.Checker, name, path string) (*Plugin, error) {
+	t := time.Now()
+
+	namespace := fmt.Sprintf("%s-test-%d%02d%02d-%s", name, t.Year(), t.Month(), t.Day(), util.GenerateRandomString(5))
+
+	checker.Logger().Infof("%s check application Namespace: %s", name, namespace)
+
+	k8sclientset, err := config.NewK8sClientset()
+	if err != nil {
+		return nil, fmt.Errorf("error NewK8sClientset: %s", err)
+	}
+
+	return &Plugin{
+		Checker:   checker,
+		Name:      name,
+		Path:      path,
+		Namespace: namespace,
+		Clientset: k8sclientset,
+	}, nil
+}
+
+func (p *Plugin) Check() (err error) {
+	p.result = p.StartCheck(p.Name, p.Namespace)
+	defer func() { p.FinishCheck(p.result, err) }()
+
+	k := k8s.NewK8s(p.Namespace, p.Clientset, p.Logger)
+
+	defer func() {
+		if err := p.result.Step("clean up resources", p.cleanUpResources); err != nil {
+			p.Notify(p.result, fmt.Sprintf("Error Delete Resources: %s", err))
+		}
+	}()
+
+	defer func() {
+		if err != nil {
+			p.CollectDiagnostics(k, p.result)
+		}
+	}()
+
+	if err := p.result.Step("create namespace", func() error {
+		return k.CreateNamespace(
+			p.Ctx,
+			&apiv1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: p.Namespace,
+				}})
+	}); err != nil {
+		p.Notify(p.result, fmt.Sprintf("Error Create Namespace: %s", err))
+		return err
+	}
+
+	return p.run()
+}
+
+func (p *Plugin) input() Input {
+	in := Input{
+		Name:           p.Name,
+		Kubeconfig:     os.Getenv("KUBECONFIG"),
+		Namespace:      p.Namespace,
+		ClusterName:    p.ClusterName,
+		TimeoutSeconds: int(p.Timeout.Seconds()),
+		Debug:          p.Debug,
+	}
+	if p.Report != nil {
+		in.RunID = p.Report.ID
+	}
+	return in
+}
+
+// run executes the plugin and merges the steps it reports into the check result.
+func (p *Plugin) run() error {
+	in := p.input()
+	stdin, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(p.Ctx, p.Timeout)
+	defer cancel()
+
+	c := exec.CommandContext(ctx, p.Path)
+	c.Env = append(os.Environ(),
+		"KIBERTAS_CHECK_NAME="+in.Name,
+		"KIBERTAS_NAMESPACE="+in.Namespace,
+		"KIBERTAS_RUN_ID="+in.RunID,
+		"KIBERTAS_CLUSTER_NAME="+in.ClusterName,
+		"KIBERTAS_TIMEOUT="+strconv.Itoa(in.TimeoutSeconds),
+		"KIBERTAS_DEBUG="+strconv.FormatBool(in.Debug),
+	)
+	var stdout bytes.Buffer
+	c.Stdin = bytes.NewReader(stdin)
+	c.Stdout = &stdout
+	c.Stderr = os.Stderr
+
+	p.Logger().Infof("Running plugin %s", p.Path)
+	started := time.Now()
+	runErr := c.Run()
+	finished := time.Now()
+
+	var out Output
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		p.result.AddArtifact(report.Artifact{Kind: report.KindPluginOutput, Name: p.Path, Content: stdout.String()})
+		if runErr != nil {
+			return fmt.Errorf("plugin %s: %w", p.Name, runErr)
+		}
+		return fmt.Errorf("plugin %s printed invalid JSON: %w", p.Name, err)
+	}
+
+	var failed *multierror.Error
+	for _, s := range out.Steps {
+		if s.StartedAt.IsZero() {
+			s.StartedAt = started
+		}
+		if s.FinishedAt.IsZero() {
+			s.FinishedAt = finished
+		}
+		if s.Status == "" {
+			s.Status = report.StatusPassed
+		}
+		p.result.AddStep(s)
+		p.Logger().Infof("Plugin step %s: %s", s.Name, s.Status)
+		p.Notify(p.result, fmt.Sprintf("%s: %s", s.Name, s.Status))
+		if s.Status == report.StatusFailed {
+			failed = multierror.Append(failed, fmt.Errorf("%s: %s", s.Name, s.Error))
+		}
+	}
+	for _, a := range out.Artifacts {
+		p.result.AddArtifact(a)
+	}
+
+	switch {
+	case out.Error != "":
+		return fmt.Errorf("plugin %s: %s", p.Name, out.Error)
+	case runErr != nil:
+		return fmt.Errorf("plugin %s: %w", p.Name, runErr)
+	}
+	return failed.ErrorOrNil()
+}
+
+func (p *Plugin) cleanUpResources() error {
+	if p.Debug {
+		p.Logger().Info("Skip Delete Resources")
+		p.Notify(p.result, "Skip Delete Resources")
+		return nil
+	}
+	k := k8s.NewK8s(p.Namespace, p.Clientset, p.Logger)
+	if err := p.DeleteNamespace(k); err != nil {
+		p.Notify(p.result, fmt.Sprintf("Error Delete Namespace: %s", err))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chatwork/kibertas/cmd"
+	"github.com/chatwork/kibertas/internal/ktesting"
+	"github.com/chatwork/kibertas/util/notify"
+	"github.com/chatwork/kibertas/util/report"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiscover(t *testing.T) {
+	plugins := Discover([]string{"testdata", filepath.Join("testdata", "missing")})
+	require.Equal(t, []string{"echo", "garbage"}, Names(plugins))
+	require.Equal(t, filepath.Join("testdata", "kibertas-check-echo"), plugins["echo"])
+}
+
+func newTestPlugin(t *testing.T, name, clusterName string) *Plugin {
+	t.Helper()
+	logger := func() *logrus.Entry {
+		return logrus.NewEntry(logrus.New())
+	}
+	checker := cmd.NewChecker(context.Background(), false, logger, &notify.Chatwork{Logger: logger}, clusterName, time.Minute)
+	path, err := filepath.Abs(filepath.Join("testdata", "kibertas-check-"+name))
+	require.NoError(t, err)
+	return &Plugin{
+		Checker:   checker,
+		Name:      name,
+		Path:      path,
+		Namespace: name + "-test",
+		result:    checker.StartCheck(name, name+"-test"),
+	}
+}
+
+func TestRun(t *testing.T) {
+	p := newTestPlugin(t, "echo", "test")
+	require.NoError(t, p.run())
+
+	require.Len(t, p.result.Steps, 2)
+	require.Equal(t, report.StatusPassed, p.result.Steps[0].Status)
+	require.Equal(t, report.StatusSkipped, p.result.Steps[1].Status)
+	require.False(t, p.result.Steps[0].StartedAt.IsZero())
+
+	var in Input
+	require.NoError(t, json.Unmarshal([]byte(p.result.Steps[0].Message), &in))
+	require.Equal(t, "echo-test", in.Namespace)
+	require.Equal(t, p.Report.ID, in.RunID)
+	require.Equal(t, 60, in.TimeoutSeconds)
+
+	require.Equal(t, []report.Artifact{{Kind: report.KindDNSAnswer, Name: "example.com", Content: "1.2.3.4"}}, p.result.Artifacts)
+}
+
+func TestRunFailure(t *testing.T) {
+	p := newTestPlugin(t, "echo", "broken")
+	require.EqualError(t, p.run(), "plugin echo: exit status 1")
+	require.Len(t, p.result.Steps, 1)
+	require.Equal(t, report.StatusFailed, p.result.Steps[0].Status)
+	require.Equal(t, "no answer", p.result.Steps[0].Error)
+}
+
+func TestRunInvalidOutput(t *testing.T) {
+	p := newTestPlugin(t, "garbage", "test")
+	require.ErrorContains(t, p.run(), "plugin garbage printed invalid JSON")
+	require.Len(t, p.result.Artifacts, 1)
+	require.Equal(t, "not json\n", p.result.Artifacts[0].Content)
+}
+
+func TestCheck(t *testing.T) {
+	clientset := fake.NewClientset()
+	p := newTestPlugin(t, "echo", "test")
+	p.Clientset = clientset
+
+	require.NoError(t, p.Check())
+	require.Equal(t, report.StatusPassed, p.result.Status)
+	require.Equal(t, "create namespace", p.result.Steps[0].Name)
+	require.Equal(t, "clean up resources", p.result.Steps[len(p.result.Steps)-1].Name)
+	require.True(t, ktesting.Deleted(clientset, "namespaces", "echo-test"))
+}
+
+func TestCheckFailure(t *testing.T) {
+	clientset := fake.NewClientset()
+	p := newTestPlugin(t, "echo", "broken")
+	p.Clientset = clientset
+
+	require.EqualError(t, p.Check(), "plugin echo: exit status 1")
+	require.Equal(t, report.StatusFailed, p.result.Status)
+	require.True(t, ktesting.Deleted(clientset, "namespaces", "echo-test"))
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chatwork/kibertas/util"
+	"github.com/chatwork/kibertas/util/notify"
+	"github.com/chatwork/kibertas/util/report"
+	"github.com/sirupsen/logrus"
+)
+
+// Soak runs the same checks repeatedly to catch intermittent failures.
+// Individual runs are not notified; only the final summary and
+// crossings of MaxFailureRate are.
+type Soak struct {
+	// Repeat is the number of runs. With Duration, it is an upper bound,
+	// and 0 means no bound.
+	Repeat int
+	// Duration keeps starting new runs until it has elapsed.
+	Duration time.Duration
+	// Interval is the pause between the end of a run and the start of the next.
+	Interval time.Duration
+	// MaxFailureRate is the fraction of failed runs above which the soak fails.
+	MaxFailureRate float64
+	Logger         func() *logrus.Entry
+	Notifier       notify.Notifier
+	ClusterName    string
+}
+
+// Enabled reports whether more than a single run was requested.
+func (s *Soak) Enabled() bool {
+	return s.Repeat > 1 || s.Duration > 0
+}
+
+// Run calls run until Repeat runs are done or Duration has elapsed, and returns
+// the summary. run must return the report of the run it did, even when it fails.
+func (s *Soak) Run(ctx context.Context, run func() (*report.Run, error)) (report.Summary, error) {
+	if s.Notifier == nil {
+		s.Notifier = notify.Discard
+	}
+
+	var runs []*report.Run
+	var deadline time.Time
+	if s.Duration > 0 {
+		deadline = time.Now().Add(s.Duration)
+	}
+	exceeded := false
+
+	for i := 1; ; i++ {
+		s.Logger().Infof("Soak run %d", i)
+		r, err := run()
+		if r != nil {
+			r.Finish()
+			runs = append(runs, r)
+		}
+		if err != nil {
+			s.Logger().Warnf("Soak run %d failed: %s", i, err)
+		}
+
+		summary := report.Summarize(runs)
+		s.Logger().Infof("Soak run %d done, success rate: %.1f%%", i, 100*summary.SuccessRate())
+		if over := summary.FailureRate() > s.MaxFailureRate; over != exceeded {
+			exceeded = over
+			s.notifyThreshold(r, summary, over)
+		}
+
+		if s.Repeat > 0 && i >= s.Repeat {
+			break
+		}
+		if deadline.IsZero() && s.Repeat <= 0 {
+			break
+		}
+		if !deadline.IsZero() && time.Now().Add(s.Interval).After(deadline) {
+			break
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if err := util.SleepContext(ctx, s.Interval); err != nil {
+			break
+		}
+	}
+
+	summary := report.Summarize(runs)
+	if len(runs) > 0 {
+		last := runs[len(runs)-1]
+		last.Soak = &summary
+		s.Notifier.Summary(last)
+	}
+
+	if summary.FailureRate() > s.MaxFailureRate {
+		return summary, fmt.Errorf("failure rate %.1f%% exceeds %.1f%%", 100*summary.FailureRate(), 100*s.MaxFailureRate)
+	}
+	return summary, nil
+}
+
+func (s *Soak) notifyThreshold(run *report.Run, summary report.Summary, exceeded bool) {
+	if exceeded {
+		s.Notifier.Step(run, nil, fmt.Sprintf("Soak test in %s: failure rate %.1f%% exceeds %.1f%% after %d runs", s.ClusterName, 100*summary.FailureRate(), 100*s.MaxFailureRate, summary.Runs))
+	} else {
+		s.Notifier.Step(run, nil, fmt.Sprintf("Soak test in %s: failure rate is back to %.1f%% after %d runs", s.ClusterName, 100*summary.FailureRate(), summary.Runs))
+	}
+}
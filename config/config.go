@@ -6,7 +6,11 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -46,6 +50,28 @@ func NewK8sClientset() (*kubernetes.Clientset, error) {
 	return clientset, nil
 }
 
+// NewK8sDynamicClient returns a dynamic client along with a REST mapper
+// for working with objects whose types are only known at runtime.
+func NewK8sDynamicClient() (dynamic.Interface, meta.RESTMapper, error) {
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery()))
+
+	return dynamicClient, mapper, nil
+}
+
 func NewK8sClient(options client.Options) (client.Client, error) {
 	// https://github.com/kubernetes-sigs/controller-runtime/blob/main/pkg/log/log.go#L58
 	log.SetLogger(zap.New(zap.UseDevMode(true)))
@@ -0,0 +1,104 @@
+package ktesting
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// FakeIngressHostname is the load balancer hostname NewReadyClientset gives to Ingresses.
+const FakeIngressHostname = "k8s-test.elb.example.com"
+
+// NewReadyClientset returns a fake clientset that plays the part of the controllers
+// the checkers wait for: Deployments have all their replicas ready and Ingresses
+// have a load balancer hostname, however they were created or applied.
+//
+// Use fake.NewClientset for objects that never become ready.
+func NewReadyClientset(objects ...runtime.Object) *fake.Clientset {
+	clientset := fake.NewClientset(objects...)
+	clientset.PrependReactor("get", "deployments", ready(clientset, func(obj runtime.Object) {
+		d := obj.(*appsv1.Deployment)
+		replicas := int32(1)
+		if d.Spec.Replicas != nil {
+			replicas = *d.Spec.Replicas
+		}
+		d.Status.ObservedGeneration = d.Generation
+		d.Status.Replicas = replicas
+		d.Status.UpdatedReplicas = replicas
+		d.Status.ReadyReplicas = replicas
+		d.Status.AvailableReplicas = replicas
+	}))
+	clientset.PrependReactor("get", "ingresses", ready(clientset, func(obj runtime.Object) {
+		i := obj.(*networkingv1.Ingress)
+		i.Status.LoadBalancer.Ingress = []networkingv1.IngressLoadBalancerIngress{{Hostname: FakeIngressHostname}}
+	}))
+	return clientset
+}
+
+// ready returns a reactor answering gets with the stored object, as set by setStatus.
+func ready(clientset *fake.Clientset, setStatus func(runtime.Object)) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		get := action.(k8stesting.GetAction)
+		obj, err := clientset.Tracker().Get(get.GetResource(), get.GetNamespace(), get.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		obj = obj.DeepCopyObject()
+		setStatus(obj)
+		return true, obj, nil
+	}
+}
+
+// Deleted tells whether the clientset got a delete request for the resource named name,
+// e.g. to check that a checker cleaned up after itself.
+func Deleted(clientset *fake.Clientset, resource, name string) bool {
+	for _, action := range clientset.Actions() {
+		if d, ok := action.(k8stesting.DeleteAction); ok && d.GetResource().Resource == resource && d.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Node returns a node with labels, to count the nodes of a capacity type.
+func Node(name string, labels map[string]string) *apiv1.Node {
+	node := &apiv1.Node{}
+	node.Name = name
+	node.Labels = labels
+	return node
+}
+
+// NewDynamicClient returns a fake dynamic client that takes server-side apply requests,
+// which the plain fake cannot do for unstructured objects. Applying creates the object
+// or replaces it with the applied one; fields set by others are not kept.
+func NewDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	client := dynamicfake.NewSimpleDynamicClient(scheme.Scheme, objects...)
+	client.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(patch.GetPatch()); err != nil {
+			return true, nil, err
+		}
+		obj.SetNamespace(patch.GetNamespace())
+		_, err := client.Tracker().Get(patch.GetResource(), patch.GetNamespace(), patch.GetName())
+		switch {
+		case kerrors.IsNotFound(err):
+			err = client.Tracker().Create(patch.GetResource(), obj, patch.GetNamespace())
+		case err == nil:
+			err = client.Tracker().Update(patch.GetResource(), obj, patch.GetNamespace())
+		}
+		return true, obj, err
+	})
+	return client
+}
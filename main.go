@@ -19,21 +19,27 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"maps"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/chatwork/kibertas/cmd"
-	certmanager "github.com/chatwork/kibertas/cmd/cert-manager"
-	clusterautoscaler "github.com/chatwork/kibertas/cmd/cluster-autoscaler"
-	datadogagent "github.com/chatwork/kibertas/cmd/datadog-agent"
-	"github.com/chatwork/kibertas/cmd/fluent"
-	"github.com/chatwork/kibertas/cmd/ingress"
+	"github.com/chatwork/kibertas/cmd/custom"
+	"github.com/chatwork/kibertas/cmd/plugin"
+	"github.com/chatwork/kibertas/config"
+	"github.com/chatwork/kibertas/util/inventory"
+	"github.com/chatwork/kibertas/util/k8s"
 	"github.com/chatwork/kibertas/util/notify"
+	"github.com/chatwork/kibertas/util/report"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -44,19 +50,88 @@ func main() {
 	var checker *cmd.Checker
 	var debug bool
 	var timeout int
+	var deadline time.Duration
+	var namespaceDeletionTimeout time.Duration
 	var logger func() *logrus.Entry
-	var chatwork *notify.Chatwork
+	var notifier notify.Notifier
+	// checkNotifier is given to the checkers. It is nil in soak mode,
+	// where only the summary is notified.
+	var checkNotifier notify.Notifier
 
 	var ctx context.Context
 
 	var noDnsCheck bool
 
+	var customSpecs []string
+
+	var tags []string
+	var skip []string
+	var keepGoing bool
+
+	var reports []string
+	var reportOutputs []report.Output
+
+	var soak cmd.Soak
+
+	var history report.History
+	// state is what notifications remember between runs. It is only kept with a store.
+	var stateRef string
+	var stateStore report.StateStore
+	state := &report.State{}
+	var inventoryNamespaces []string
+
 	clusterName := os.Getenv("CLUSTER_NAME")
 
 	var rootCmd = &cobra.Command{
 		Use:           "kibertas",
 		SilenceUsage:  true,
 		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			for _, r := range reports {
+				output, err := report.ParseOutput(r)
+				if err != nil {
+					return err
+				}
+				reportOutputs = append(reportOutputs, output)
+			}
+			checkNotifier = notifier
+			if soak.Enabled() {
+				checkNotifier = nil
+			}
+
+			// Only the checks notify, "kibertas diff" needs no state.
+			if !runsChecks(cmd) {
+				return nil
+			}
+			stateStore = newStateStore(logger, stateRef, history.Dir)
+			if sinks, ok := notifier.(notify.FanOut); ok && stateStore == nil && sinks.NeedsState() {
+				return errors.New("the on-change and digest notification policies need a state to compare runs with, set --state or --history-dir")
+			}
+			if stateStore == nil && len(mentionsFromEnv("CHATWORK_TASK_ASSIGNEES")) > 0 {
+				// Without it, every failure of a check would get a new task.
+				return errors.New("CHATWORK_TASK_ASSIGNEES needs a state to remember the open tasks in, set --state or --history-dir")
+			}
+			if stateStore != nil {
+				loaded, store, err := report.LoadState(ctx, stateStore)
+				if err != nil {
+					logger().Warnf("Error loading notification state, every check is taken as new and the state is left as it is: %s", err)
+				}
+				*state, stateStore = *loaded, store
+			}
+			return nil
+		},
+	}
+
+	newChecker := func() *cmd.Checker {
+		c := cmd.NewChecker(ctx, debug, logger, checkNotifier, clusterName, time.Duration(timeout)*time.Minute)
+		if stateStore != nil {
+			c.Report.Previous = maps.Clone(state.Checks)
+		}
+		if deadline > 0 {
+			c.Deadline = time.Now().Add(deadline)
+		}
+		c.NamespaceDeletionTimeout = namespaceDeletionTimeout
+		return c
 	}
 
 	var cmdTest = &cobra.Command{
@@ -73,12 +148,8 @@ func main() {
 		Short: "test cluster-autoscaler",
 		Long:  "test cluster-autoscaler",
 		RunE: func(cobra_cmd *cobra.Command, args []string) error {
-			checker = cmd.NewChecker(ctx, debug, logger, chatwork, clusterName, time.Duration(timeout)*time.Minute)
-			ca, err := clusterautoscaler.NewClusterAutoscaler(checker)
-			if err != nil {
-				return err
-			}
-			return ca.Check()
+			checker = newChecker()
+			return checker.RunDefinitions(definition("cluster-autoscaler", false))
 		},
 	}
 
@@ -87,12 +158,8 @@ func main() {
 		Short: "test ingress",
 		Long:  "test ingress(ingress-controller, external-dns)",
 		RunE: func(cobra_cmd *cobra.Command, args []string) error {
-			checker = cmd.NewChecker(ctx, debug, logger, chatwork, clusterName, time.Duration(timeout)*time.Minute)
-			i, err := ingress.NewIngress(checker, noDnsCheck)
-			if err != nil {
-				return err
-			}
-			return i.Check()
+			checker = newChecker()
+			return checker.RunDefinitions(definition("ingress", noDnsCheck))
 		},
 	}
 
@@ -101,12 +168,8 @@ func main() {
 		Short: "test fluent(fluent-bit, fluentd)",
 		Long:  "test fluent(fluent-bit, fluentd)",
 		RunE: func(cobra_cmd *cobra.Command, args []string) error {
-			checker = cmd.NewChecker(ctx, debug, logger, chatwork, clusterName, time.Duration(timeout)*time.Minute)
-			f, err := fluent.NewFluent(checker)
-			if err != nil {
-				return err
-			}
-			return f.Check()
+			checker = newChecker()
+			return checker.RunDefinitions(definition("fluent", false))
 		},
 	}
 
@@ -115,12 +178,8 @@ func main() {
 		Short: "test datadog-agent",
 		Long:  "test datadog-agent",
 		RunE: func(cobra_cmd *cobra.Command, args []string) error {
-			checker = cmd.NewChecker(ctx, debug, logger, chatwork, clusterName, time.Duration(timeout)*time.Minute)
-			da, err := datadogagent.NewDatadogAgent(checker)
-			if err != nil {
-				return err
-			}
-			return da.Check()
+			checker = newChecker()
+			return checker.RunDefinitions(definition("datadog-agent", false))
 		},
 	}
 
@@ -129,12 +188,30 @@ func main() {
 		Short: "test cert-manager",
 		Long:  "test cert-manager",
 		RunE: func(cobra_cmd *cobra.Command, args []string) error {
-			checker = cmd.NewChecker(ctx, debug, logger, chatwork, clusterName, time.Duration(timeout)*time.Minute)
-			cm, err := certmanager.NewCertManager(checker)
-			if err != nil {
-				return err
+			checker = newChecker()
+			return checker.RunDefinitions(definition("cert-manager", false))
+		},
+	}
+
+	var cmdCustom = &cobra.Command{
+		Use:   "custom",
+		Short: "test custom checks defined in YAML",
+		Long:  "test custom checks defined in YAML spec files(manifests to apply, waits, probes and assertions)",
+		RunE: func(cobra_cmd *cobra.Command, args []string) error {
+			if len(customSpecs) == 0 {
+				return errors.New("at least one --spec is required")
 			}
-			return cm.Check()
+			checker = newChecker()
+			for _, spec := range customSpecs {
+				c, err := custom.NewCustom(checker, spec)
+				if err != nil {
+					return err
+				}
+				if err := c.Check(); err != nil {
+					return err
+				}
+			}
+			return nil
 		},
 	}
 
@@ -144,58 +221,50 @@ func main() {
 		Long:  "test all application",
 		RunE: func(cobra_cmd *cobra.Command, args []string) error {
 			logger().Info("test all application")
-			checker = cmd.NewChecker(ctx, debug, logger, chatwork, clusterName, time.Duration(timeout)*time.Minute)
-			ca, err := clusterautoscaler.NewClusterAutoscaler(checker)
-			if err != nil {
-				return err
-			}
-			if err := ca.Check(); err != nil {
-				return err
-			}
-
-			//checker.Chatwork = initChatwork(logger)
-			i, err := ingress.NewIngress(checker, false)
+			defs, err := cmd.Select(definitions(false), tags, skip)
 			if err != nil {
 				return err
 			}
-			if err := i.Check(); err != nil {
-				return err
-			}
-
-			//checker.Chatwork = initChatwork(logger)
-			f, err := fluent.NewFluent(checker)
-			if err != nil {
-				return err
-			}
-			if err := f.Check(); err != nil {
-				return err
-			}
+			checker = newChecker()
+			checker.KeepGoing = keepGoing
+			return checker.RunDefinitions(defs)
+		},
+	}
 
-			//checker.Chatwork = initChatwork(logger)
-			da, err := datadogagent.NewDatadogAgent(checker)
+	var cmdDiff = &cobra.Command{
+		Use:   "diff <runA> <runB>",
+		Short: "show what changed between two runs",
+		Long:  "show which checks changed status and which cluster add-ons changed version between two runs, given by run ID in --history-dir or by path of a JSON report",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cobra_cmd *cobra.Command, args []string) error {
+			before, err := history.Load(args[0])
 			if err != nil {
 				return err
 			}
-			if err := da.Check(); err != nil {
-				return err
-			}
-
-			//checker.Chatwork = initChatwork(logger)
-			cm, err := certmanager.NewCertManager(checker)
+			after, err := history.Load(args[1])
 			if err != nil {
 				return err
 			}
-			if err := cm.Check(); err != nil {
-				return err
-			}
-			return nil
+			_, err = cobra_cmd.OutOrStdout().Write([]byte(report.Diff(before, after).String()))
+			return err
 		},
 	}
 
 	rootCmd.AddCommand(cmdTest)
+	rootCmd.AddCommand(cmdDiff)
 	rootCmd.PersistentFlags().IntVar(&timeout, "timeout", 15, "Check timeout. If you want to change the timeout, please specify the number of minutes.")
+	rootCmd.PersistentFlags().DurationVar(&deadline, "deadline", 0, "Total time budget for the checks, e.g. 45m. The time left is shared among the remaining checks, each getting at most --timeout, and checks that can't start before the deadline are skipped.")
+	rootCmd.PersistentFlags().DurationVar(&namespaceDeletionTimeout, "namespace-deletion-timeout", k8s.DefaultNamespaceDeletionTimeout, "How long cleanup waits for the test namespace to be gone. A namespace still terminating by then fails the cleanup, naming what blocks it.")
 	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Enable debug mode")
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "The log level to use. Valid values are \"debug\", \"info\", \"warn\", \"error\", and \"fatal\".")
+	rootCmd.PersistentFlags().StringVar(&history.Dir, "history-dir", os.Getenv("KIBERTAS_HISTORY_DIR"), "Directory to store every run in, for comparing them with \"kibertas diff\".")
+	rootCmd.PersistentFlags().StringVar(&stateRef, "state", os.Getenv("KIBERTAS_STATE"), "Where to keep the state of notifications between runs, for the on-change and digest policies and Chatwork tasks: a file path, or configmap:<namespace>/<name>. Defaults to state.json in --history-dir.")
+	rootCmd.PersistentFlags().StringArrayVar(&inventoryNamespaces, "inventory-namespace", inventory.DefaultNamespaces, "Namespace whose Deployments and DaemonSets are recorded in the inventory of the run. Can be repeated.")
+	rootCmd.PersistentFlags().IntVar(&soak.Repeat, "repeat", 0, "Soak mode: run the checks this many times. With --duration, this is an upper bound.")
+	rootCmd.PersistentFlags().DurationVar(&soak.Duration, "duration", 0, "Soak mode: keep running the checks until this duration has elapsed, e.g. 6h.")
+	rootCmd.PersistentFlags().DurationVar(&soak.Interval, "interval", time.Minute, "Soak mode: pause between runs.")
+	rootCmd.PersistentFlags().Float64Var(&soak.MaxFailureRate, "max-failure-rate", 0, "Soak mode: fail if more than this fraction of the runs fail, e.g. 0.05.")
+	rootCmd.PersistentFlags().StringArrayVar(&reports, "report", nil, "Write a report of the run in the form <format>=<path>. Valid formats are \"json\" and \"html\". Can be repeated.")
 	logger, err := initLogger(logLevel, debug)
 	if err != nil {
 		panic(err)
@@ -205,10 +274,17 @@ func main() {
 	}
 	logger().Debug("log level: ", logLevel)
 
-	chatwork = initChatwork(logger)
+	notifier, err = initNotifier(logger, state)
+	if err != nil {
+		logger().Fatal("Error: ", err)
+	}
 
-	ctx = newSignalContext(logger, chatwork)
+	ctx = newSignalContext(logger, notifier)
 
+	cmdAll.Flags().StringSliceVar(&tags, "tags", nil, "Run only the checks having one of these tags or names, e.g. critical,networking. Tags: aws, scaling, networking, logging, monitoring, tls, critical.")
+	cmdAll.Flags().StringSliceVar(&skip, "skip", nil, "Skip the checks having one of these tags or names, e.g. datadog-agent,logging.")
+	cmdAll.Flags().BoolVar(&keepGoing, "keep-going", false, "Run the remaining checks after one fails instead of stopping there. Those depending on it are skipped.")
+	cmdCustom.Flags().StringArrayVar(&customSpecs, "spec", nil, "Path to a custom check spec file. Can be repeated.")
 	cmdIngress.Flags().BoolVar(&noDnsCheck, "no-dns-check", false, "This is a flag for the dns check. If you want to skip the dns check, please specify false.(default: false)")
 
 	cmdTest.AddCommand(cmdAll)
@@ -217,15 +293,179 @@ func main() {
 	cmdTest.AddCommand(cmdIngress)
 	cmdTest.AddCommand(cmdCertManager)
 	cmdTest.AddCommand(cmdDatadogAgent)
+	cmdTest.AddCommand(cmdCustom)
+
+	plugins := plugin.Discover(pluginDirs())
+	for _, name := range plugin.Names(plugins) {
+		if c, _, err := cmdTest.Find([]string{name}); err == nil && c != cmdTest {
+			logger().Warnf("Ignoring plugin %s: %s is a built-in check", plugins[name], name)
+			continue
+		}
+		path := plugins[name]
+		cmdTest.AddCommand(&cobra.Command{
+			Use:   name,
+			Short: "test " + name + " (plugin)",
+			Long:  "test " + name + " with the plugin " + path,
+			RunE: func(cobra_cmd *cobra.Command, args []string) error {
+				checker = newChecker()
+				p, err := plugin.NewPlugin(checker, name, path)
+				if err != nil {
+					return err
+				}
+				return p.Check()
+			},
+		})
+	}
+
+	// In soak mode every check is run repeatedly and only the summary is notified.
+	for _, c := range cmdTest.Commands() {
+		runE := c.RunE
+		c.RunE = func(cobra_cmd *cobra.Command, args []string) error {
+			if !soak.Enabled() {
+				return runE(cobra_cmd, args)
+			}
+			soak.Logger = logger
+			soak.Notifier = notifier
+			soak.ClusterName = clusterName
+			summary, err := soak.Run(ctx, func() (*report.Run, error) {
+				checker = nil
+				err := runE(cobra_cmd, args)
+				if checker == nil {
+					return nil, err
+				}
+				return checker.Report, err
+			})
+			logger().Infof("Soak test finished\n%s", summary)
+			return err
+		}
+	}
+
+	err = rootCmd.Execute()
 
-	if err := rootCmd.Execute(); err != nil {
-		chatwork.AddMessage("Error: " + err.Error() + "\n")
-		chatwork.Send()
+	var run *report.Run
+	if checker != nil {
+		run = checker.Report
+		run.Finish()
+	}
+
+	if checker != nil && (history.Dir != "" || len(reportOutputs) > 0) {
+		checker.Report.Inventory = collectInventory(logger, inventoryNamespaces)
+		writeReports(logger, checker.Report, reportOutputs)
+		if history.Dir != "" {
+			if path, err := history.Save(checker.Report); err != nil {
+				logger().Errorf("Error saving run %s: %s", checker.Report.ID, err)
+			} else {
+				logger().Infof("Saved run %s to %s", checker.Report.ID, path)
+			}
+		}
+	}
+
+	if err != nil {
+		notifier.Step(run, nil, "Error: "+err.Error())
+	}
+	// In soak mode, the summary has already been sent at the end of the soak test.
+	if run != nil && !soak.Enabled() {
+		notifier.Summary(run)
+	}
+	if run != nil && !soak.Enabled() && stateStore != nil {
+		if digest := state.Record(run, digestInterval); digest != nil {
+			digestRun := report.NewRun(clusterName)
+			digestRun.StartedAt, digestRun.FinishedAt = digest.Since, digest.Until
+			digestRun.Digest = digest
+			notifier.Summary(digestRun)
+		}
+		if err := stateStore.Save(context.Background(), state); err != nil {
+			logger().Errorf("Error saving notification state: %s", err)
+		}
+	}
+	if err != nil {
 		logger().Fatal("Error: ", err)
 	}
 }
 
-func newSignalContext(logger func() *logrus.Entry, chatwork *notify.Chatwork) context.Context {
+// runsChecks tells whether c is one of the "kibertas test" subcommands.
+func runsChecks(c *cobra.Command) bool {
+	for p := c.Parent(); p != nil; p = p.Parent() {
+		if p.Name() == "test" && p.Parent() == c.Root() {
+			return true
+		}
+	}
+	return false
+}
+
+// digestInterval is how often the sinks with the digest policy are notified.
+const digestInterval = 24 * time.Hour
+
+// newStateStore returns the store given by ref, defaulting to a file in the
+// history directory. It returns nil when there is neither.
+func newStateStore(logger func() *logrus.Entry, ref, historyDir string) report.StateStore {
+	if ref == "" {
+		if historyDir == "" {
+			return nil
+		}
+		return report.FileState{Path: filepath.Join(historyDir, "state.json")}
+	}
+	name, ok := strings.CutPrefix(ref, "configmap:")
+	if !ok {
+		return report.FileState{Path: ref}
+	}
+	namespace, name, ok := strings.Cut(name, "/")
+	if !ok {
+		namespace, name = "default", namespace
+	}
+	clientset, err := config.NewK8sClientset()
+	if err != nil {
+		logger().Errorf("Error creating the client of the notification state: %s", err)
+		return nil
+	}
+	return report.ConfigMapState{Clientset: clientset, Namespace: namespace, Name: name}
+}
+
+func writeReports(logger func() *logrus.Entry, run *report.Run, outputs []report.Output) {
+	for _, output := range outputs {
+		if err := output.Write(run); err != nil {
+			logger().Errorf("Error writing %s report to %s: %s", output.Format, output.Path, err)
+			continue
+		}
+		logger().Infof("Wrote %s report to %s", output.Format, output.Path)
+	}
+}
+
+// collectInventory snapshots the cluster add-ons. The run is still reported
+// without it if the cluster can't be reached.
+func collectInventory(logger func() *logrus.Entry, namespaces []string) *inventory.Snapshot {
+	clientset, err := config.NewK8sClientset()
+	if err != nil {
+		logger().Errorf("Error collecting inventory: %s", err)
+		return nil
+	}
+	dynamicClient, _, err := config.NewK8sDynamicClient()
+	if err != nil {
+		logger().Errorf("Error collecting inventory: %s", err)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	snapshot, err := inventory.Collect(ctx, clientset, dynamicClient, namespaces)
+	if err != nil {
+		logger().Errorf("Error collecting inventory: %s", err)
+		return nil
+	}
+	return snapshot
+}
+
+// pluginDirs returns the directories searched for plugins:
+// KIBERTAS_PLUGIN_DIR first, then PATH.
+func pluginDirs() []string {
+	var dirs []string
+	if v := os.Getenv("KIBERTAS_PLUGIN_DIR"); v != "" {
+		dirs = append(dirs, filepath.SplitList(v)...)
+	}
+	return append(dirs, filepath.SplitList(os.Getenv("PATH"))...)
+}
+
+func newSignalContext(logger func() *logrus.Entry, notifier notify.Notifier) context.Context {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	c := make(chan os.Signal, 1)
@@ -234,19 +474,258 @@ func newSignalContext(logger func() *logrus.Entry, chatwork *notify.Chatwork) co
 	go func() {
 		<-c
 		logger().Info("Received Ctrl+C or SIGTERM. Exiting...")
-		chatwork.AddMessage("Received Ctrl+C or SIGERM. Exiting...\n")
+		notifier.Step(nil, nil, "Received Ctrl+C or SIGTERM. Exiting...")
 		cancel()
 	}()
 
 	return ctx
 }
 
-func initChatwork(logger func() *logrus.Entry) *notify.Chatwork {
+// initNotifier returns the sinks to notify, each filtered with filterFromEnv.
+// state is where they remember what they need to between runs.
+func initNotifier(logger func() *logrus.Entry, state *report.State) (notify.Notifier, error) {
 	apiToken := os.Getenv("CHATWORK_API_TOKEN")
 	roomId := os.Getenv("CHATWORK_ROOM_ID")
 	chatwork := notify.NewChatwork(apiToken, roomId, logger)
+	chatwork.Mentions = mentionsFromEnv("CHATWORK_MENTIONS")
+	chatwork.UploadDiagnostics, _ = strconv.ParseBool(os.Getenv("CHATWORK_UPLOAD_DIAGNOSTICS"))
+	chatwork.Live, _ = strconv.ParseBool(os.Getenv("CHATWORK_LIVE"))
+	if assignees := mentionsFromEnv("CHATWORK_TASK_ASSIGNEES"); len(assignees) > 0 {
+		chatwork.Tasks = &notify.ChatworkTasks{Assignees: assignees, Deadline: 24 * time.Hour, State: state}
+		if v := os.Getenv("CHATWORK_TASK_DEADLINE"); v != "" {
+			deadline, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("CHATWORK_TASK_DEADLINE: %w", err)
+			}
+			chatwork.Tasks.Deadline = deadline
+		}
+	}
+
+	location, timeFormat, err := timeFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	templates, err := notify.LoadTemplates(os.Getenv("CHATWORK_TEMPLATES"))
+	if err != nil {
+		return nil, fmt.Errorf("CHATWORK_TEMPLATES: %w", err)
+	}
+	templates.Location = location
+	templates.TimeFormat = timeFormat
+	chatwork.Templates = templates
+
+	var sinks notify.FanOut
+	var errs []error
+	add := func(prefix string, n notify.Notifier) {
+		filter, err := filterFromEnv(prefix, location)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		sinks = append(sinks, notify.Sink{Notifier: n, Filter: filter})
+	}
+
+	add("CHATWORK", chatwork)
+
+	webhookURL := os.Getenv("SLACK_WEBHOOK_URL")
+	token := os.Getenv("SLACK_BOT_TOKEN")
+	channel := os.Getenv("SLACK_CHANNEL")
+	if webhookURL != "" || (token != "" && channel != "") {
+		slack := notify.NewSlack(webhookURL, token, channel, logger)
+		slack.ReportURL = os.Getenv("SLACK_REPORT_URL")
+		slack.Location = location
+		slack.TimeFormat = timeFormat
+		if slack.Templates, err = templatesFromEnv("SLACK", location, timeFormat); err != nil {
+			return nil, err
+		}
+		slack.Live, _ = strconv.ParseBool(os.Getenv("SLACK_LIVE"))
+		if slack.Live && webhookURL != "" {
+			logger().Warn("SLACK_LIVE requires SLACK_BOT_TOKEN and SLACK_CHANNEL instead of SLACK_WEBHOOK_URL, as webhook messages can't be updated")
+		}
+		add("SLACK", slack)
+	}
+
+	if url := os.Getenv("WEBHOOK_URL"); url != "" {
+		webhook, err := webhookFromEnv(url, logger)
+		if err != nil {
+			return nil, fmt.Errorf("webhook: %w", err)
+		}
+		add("WEBHOOK", webhook)
+	}
+
+	if key := os.Getenv("PAGERDUTY_ROUTING_KEY"); key != "" {
+		pagerDuty := notify.NewPagerDuty(key, logger)
+		pagerDuty.ReportURL = os.Getenv("PAGERDUTY_REPORT_URL")
+		severity, err := severityFromEnv("PAGERDUTY")
+		if err != nil {
+			return nil, err
+		}
+		pagerDuty.Severity = severity
+		if pagerDuty.Templates, err = templatesFromEnv("PAGERDUTY", location, timeFormat); err != nil {
+			return nil, err
+		}
+		add("PAGERDUTY", pagerDuty)
+	}
+
+	if key := os.Getenv("OPSGENIE_API_KEY"); key != "" {
+		opsgenie := notify.NewOpsgenie(key, logger)
+		if v := os.Getenv("OPSGENIE_API_URL"); v != "" {
+			opsgenie.APIURL = v
+		}
+		opsgenie.ReportURL = os.Getenv("OPSGENIE_REPORT_URL")
+		severity, err := severityFromEnv("OPSGENIE")
+		if err != nil {
+			return nil, err
+		}
+		opsgenie.Severity = severity
+		if opsgenie.Templates, err = templatesFromEnv("OPSGENIE", location, timeFormat); err != nil {
+			return nil, err
+		}
+		add("OPSGENIE", opsgenie)
+	}
+
+	return sinks, errors.Join(errs...)
+}
+
+// templatesFromEnv reads the templates of a sink other than Chatwork from the directory
+// in <prefix>_TEMPLATES, if set. The sink keeps its own format for the missing ones.
+func templatesFromEnv(prefix string, location *time.Location, timeFormat string) (*notify.Templates, error) {
+	templates, err := notify.LoadTemplateOverrides(os.Getenv(prefix + "_TEMPLATES"))
+	if err != nil {
+		return nil, fmt.Errorf("%s_TEMPLATES: %w", prefix, err)
+	}
+	if templates != nil {
+		templates.Location = location
+		templates.TimeFormat = timeFormat
+	}
+	return templates, nil
+}
+
+// timeFromEnv reads the time zone of notifications from KIBERTAS_TIMEZONE, e.g. Europe/Paris,
+// and their time format from KIBERTAS_TIME_FORMAT, as a Go reference time.
+// Both are left to each notifier if unset.
+func timeFromEnv() (*time.Location, string, error) {
+	var location *time.Location
+	if v := os.Getenv("KIBERTAS_TIMEZONE"); v != "" {
+		var err error
+		if location, err = time.LoadLocation(v); err != nil {
+			return nil, "", fmt.Errorf("KIBERTAS_TIMEZONE: %w", err)
+		}
+	}
+	return location, os.Getenv("KIBERTAS_TIME_FORMAT"), nil
+}
 
-	return chatwork
+// mentionsFromEnv reads account IDs by check from key, as semicolon-separated
+// check=id,... entries, e.g. "fluent=111,222;ingress=333". "*" matches any check.
+func mentionsFromEnv(key string) map[string][]string {
+	mentions := map[string][]string{}
+	v := os.Getenv(key)
+	if v == "" {
+		return mentions
+	}
+	for _, entry := range strings.Split(v, ";") {
+		check, ids, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+		for _, id := range strings.Split(ids, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				mentions[strings.TrimSpace(check)] = append(mentions[strings.TrimSpace(check)], id)
+			}
+		}
+	}
+	return mentions
+}
+
+// severityFromEnv reads the severity of alerts from <prefix>_SEVERITY, a comma-separated
+// list of check=severity pairs, plus the severity of the other checks, e.g. "ingress=critical,warning".
+func severityFromEnv(prefix string) (notify.Severity, error) {
+	s := notify.Severity{Checks: map[string]string{}}
+	v := os.Getenv(prefix + "_SEVERITY")
+	if v == "" {
+		return s, nil
+	}
+	for _, entry := range strings.Split(v, ",") {
+		check, severity, ok := strings.Cut(entry, "=")
+		if !ok {
+			check, severity = "", check
+		}
+		switch severity {
+		case notify.SeverityCritical, notify.SeverityError, notify.SeverityWarning, notify.SeverityInfo:
+		default:
+			return s, fmt.Errorf("%s_SEVERITY: invalid severity %q, valid ones are: critical, error, warning, info", prefix, severity)
+		}
+		if check == "" {
+			s.Default = severity
+		} else {
+			s.Checks[check] = severity
+		}
+	}
+	return s, nil
+}
+
+// webhookFromEnv configures a webhook posting to url with the body template in
+// the file WEBHOOK_TEMPLATE, the comma-separated Name=value pairs of WEBHOOK_HEADERS
+// and the signing secret WEBHOOK_SECRET.
+func webhookFromEnv(url string, logger func() *logrus.Entry) (*notify.Webhook, error) {
+	text := notify.DefaultWebhookTemplate
+	if path := os.Getenv("WEBHOOK_TEMPLATE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		text = string(b)
+	}
+	tmpl, err := notify.ParseWebhookTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook := notify.NewWebhook(url, tmpl, logger)
+	webhook.Secret = os.Getenv("WEBHOOK_SECRET")
+	if v := os.Getenv("WEBHOOK_HEADERS"); v != "" {
+		for _, h := range strings.Split(v, ",") {
+			name, value, ok := strings.Cut(h, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid header %q, expected Name=value", h)
+			}
+			webhook.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	return webhook, nil
+}
+
+// filterFromEnv reads the filter of a sink from <prefix>_CHECKS, a comma-separated
+// list of checks, <prefix>_FAILURES_ONLY, <prefix>_POLICY and <prefix>_QUIET_HOURS,
+// e.g. "22-7" in location, or that of the messages if nil. The checks tagged critical
+// are notified of during quiet hours.
+func filterFromEnv(prefix string, location *time.Location) (notify.Filter, error) {
+	var f notify.Filter
+	if v := os.Getenv(prefix + "_CHECKS"); v != "" {
+		f.Checks = strings.Split(v, ",")
+	}
+	if v := os.Getenv(prefix + "_FAILURES_ONLY"); v != "" {
+		f.FailuresOnly, _ = strconv.ParseBool(v)
+	}
+	policy, err := notify.ParsePolicy(os.Getenv(prefix + "_POLICY"))
+	if err != nil {
+		return f, fmt.Errorf("%s_POLICY: %w", prefix, err)
+	}
+	f.Policy = policy
+	if v := os.Getenv(prefix + "_QUIET_HOURS"); v != "" {
+		start, end, ok := strings.Cut(v, "-")
+		startHour, err1 := strconv.Atoi(start)
+		endHour, err2 := strconv.Atoi(end)
+		if !ok || err1 != nil || err2 != nil || startHour < 0 || startHour > 23 || endHour < 0 || endHour > 23 {
+			return f, fmt.Errorf("%s_QUIET_HOURS: invalid quiet hours %q, expected <start hour>-<end hour>, e.g. 22-7", prefix, v)
+		}
+		f.QuietHours = &notify.QuietHours{Start: startHour, End: endHour, Location: location}
+		for _, d := range definitions(false) {
+			if slices.Contains(d.Tags, "critical") {
+				f.Critical = append(f.Critical, d.Name)
+			}
+		}
+	}
+	return f, nil
 }
 
 func initLogger(logLevel string, debug bool) (func() *logrus.Entry, error) {
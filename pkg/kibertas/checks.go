@@ -0,0 +1,205 @@
+package kibertas
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	certmanager "github.com/chatwork/kibertas/cmd/cert-manager"
+	clusterautoscaler "github.com/chatwork/kibertas/cmd/cluster-autoscaler"
+	datadogagent "github.com/chatwork/kibertas/cmd/datadog-agent"
+	"github.com/chatwork/kibertas/cmd/fluent"
+	"github.com/chatwork/kibertas/cmd/ingress"
+	"github.com/chatwork/kibertas/util/report"
+)
+
+type ClusterAutoscalerOptions struct {
+	Options
+	Clientset kubernetes.Interface
+	// Namespace defaults to cluster-autoscaler-test-<date>-<random>.
+	Namespace string
+	// ResourceName defaults to sample-for-scale.
+	ResourceName string
+	// NodeLabelKey and NodeLabelValue select the nodes to scale,
+	// eks.amazonaws.com/capacityType=SPOT by default.
+	NodeLabelKey   string
+	NodeLabelValue string
+	Tolerations    []apiv1.Toleration
+}
+
+// CheckClusterAutoscaler schedules one more pod than there are matching nodes
+// and waits for the autoscaler to add a node for it.
+func CheckClusterAutoscaler(opts ClusterAutoscalerOptions) (*report.CheckResult, error) {
+	if opts.Clientset == nil {
+		return nil, fmt.Errorf("cluster-autoscaler: Clientset: %w", ErrNoClient)
+	}
+	checker := opts.checker()
+	return check(checker, &clusterautoscaler.ClusterAutoscaler{
+		Checker:          checker,
+		Clientset:        opts.Clientset,
+		Namespace:        orDefault(opts.Namespace, namespace("cluster-autoscaler")),
+		ResourceName:     orDefault(opts.ResourceName, "sample-for-scale"),
+		NodeLabelKey:     orDefault(opts.NodeLabelKey, "eks.amazonaws.com/capacityType"),
+		NodeLabelValue:   orDefault(opts.NodeLabelValue, "SPOT"),
+		DeploymentOption: clusterautoscaler.DeploymentOption{Tolerations: opts.Tolerations},
+	})
+}
+
+type IngressOptions struct {
+	Options
+	Clientset kubernetes.Interface
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// DNS resolves ExternalHostname against DNSServer. Defaults to a plain *dns.Client.
+	DNS       ingress.DNSExchanger
+	DNSServer string
+	// Namespace defaults to ingress-test-<date>-<random>.
+	Namespace string
+	// ResourceName defaults to sample.
+	ResourceName string
+	// ExternalHostname defaults to example.local.
+	ExternalHostname string
+	// IngressClassName defaults to alb.
+	IngressClassName string
+	// HTTPCheckEndpoint defaults to http://<ExternalHostname>/.
+	HTTPCheckEndpoint string
+	SkipDNSCheck      bool
+	SkipHTTPCheck     bool
+}
+
+// CheckIngress exposes a Deployment through an Ingress and checks
+// that its DNS record is published and it answers HTTP requests.
+func CheckIngress(opts IngressOptions) (*report.CheckResult, error) {
+	if opts.Clientset == nil {
+		return nil, fmt.Errorf("ingress: Clientset: %w", ErrNoClient)
+	}
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	var dnsClient ingress.DNSExchanger = new(dns.Client)
+	if opts.DNS != nil {
+		dnsClient = opts.DNS
+	}
+
+	checker := opts.checker()
+	return check(checker, &ingress.Ingress{
+		Checker:           checker,
+		Clientset:         opts.Clientset,
+		HTTPClient:        httpClient,
+		DNSClient:         dnsClient,
+		DNSServer:         orDefault(opts.DNSServer, ingress.DefaultDNSServer),
+		Namespace:         orDefault(opts.Namespace, namespace("ingress")),
+		ResourceName:      orDefault(opts.ResourceName, "sample"),
+		ExternalHostname:  orDefault(opts.ExternalHostname, "example.local"),
+		IngressClassName:  orDefault(opts.IngressClassName, "alb"),
+		HTTPCheckEndpoint: opts.HTTPCheckEndpoint,
+		NoDnsCheck:        opts.SkipDNSCheck,
+		NoHTTPCheck:       opts.SkipHTTPCheck,
+	})
+}
+
+type FluentOptions struct {
+	Options
+	Clientset kubernetes.Interface
+	S3        fluent.S3ListObjectsAPI
+	// Namespace defaults to fluent-test-<date>-<random>.
+	Namespace string
+	// ResourceName defaults to burst-log-generator.
+	ResourceName string
+	// LogBucketName defaults to kubernetes-logs.
+	LogBucketName string
+	// LogPath defaults to fluentd/<Env>/<Namespace>/dt=<yyyymmdd>.
+	LogPath string
+	// Env defaults to test.
+	Env string
+}
+
+// CheckFluent runs a log generator and waits for its logs to be shipped to S3.
+func CheckFluent(opts FluentOptions) (*report.CheckResult, error) {
+	if opts.Clientset == nil {
+		return nil, fmt.Errorf("fluent: Clientset: %w", ErrNoClient)
+	}
+	if opts.S3 == nil {
+		return nil, fmt.Errorf("fluent: S3: %w", ErrNoClient)
+	}
+	ns := orDefault(opts.Namespace, namespace("fluent"))
+	t := time.Now().UTC()
+	logPath := orDefault(opts.LogPath, fmt.Sprintf("fluentd/%s/%s/dt=%d%02d%02d", orDefault(opts.Env, "test"), ns, t.Year(), t.Month(), t.Day()))
+
+	checker := opts.checker()
+	return check(checker, &fluent.Fluent{
+		Checker:       checker,
+		Clientset:     opts.Clientset,
+		S3Client:      opts.S3,
+		Namespace:     ns,
+		ResourceName:  orDefault(opts.ResourceName, "burst-log-generator"),
+		LogBucketName: orDefault(opts.LogBucketName, "kubernetes-logs"),
+		LogPath:       logPath,
+	})
+}
+
+type CertManagerOptions struct {
+	Options
+	Clientset kubernetes.Interface
+	// Client must have the cert-manager types in its scheme.
+	Client client.Client
+	// Namespace defaults to cert-manager-test-<date>-<random>.
+	Namespace string
+	// ResourceName defaults to sample.
+	ResourceName string
+}
+
+// CheckCertManager issues a certificate from a CA issuer signed by the
+// selfsigned-issuer ClusterIssuer.
+func CheckCertManager(opts CertManagerOptions) (*report.CheckResult, error) {
+	if opts.Clientset == nil {
+		return nil, fmt.Errorf("cert-manager: Clientset: %w", ErrNoClient)
+	}
+	if opts.Client == nil {
+		return nil, fmt.Errorf("cert-manager: Client: %w", ErrNoClient)
+	}
+
+	checker := opts.checker()
+	return check(checker, &certmanager.CertManager{
+		Checker:      checker,
+		Clientset:    opts.Clientset,
+		Client:       opts.Client,
+		Namespace:    orDefault(opts.Namespace, namespace("cert-manager")),
+		ResourceName: orDefault(opts.ResourceName, "sample"),
+	})
+}
+
+type DatadogAgentOptions struct {
+	Options
+	// Metrics is usually a *datadogagent.DatadogClient.
+	Metrics datadogagent.DatadogMetrics
+	// Query defaults to avg:kubernetes.cpu.user.total{*}.
+	Query string
+	// WaitTime is how long to wait for metrics to be reported before querying. Defaults to 3 minutes.
+	WaitTime time.Duration
+}
+
+// CheckDatadogAgent checks that the agent reports metrics to Datadog.
+func CheckDatadogAgent(opts DatadogAgentOptions) (*report.CheckResult, error) {
+	if opts.Metrics == nil {
+		return nil, fmt.Errorf("datadog-agent: Metrics: %w", ErrNoClient)
+	}
+	waitTime := opts.WaitTime
+	if waitTime == 0 {
+		waitTime = 3 * time.Minute
+	}
+
+	checker := opts.checker()
+	return check(checker, &datadogagent.DatadogAgent{
+		Checker:        checker,
+		DatadogMetrics: opts.Metrics,
+		MetricsQuery:   orDefault(opts.Query, "avg:kubernetes.cpu.user.total{*}"),
+		WaitTime:       waitTime,
+	})
+}
@@ -0,0 +1,88 @@
+// Package kibertas runs the kibertas checks from other Go programs.
+//
+// Unlike the CLI, nothing is read from environment variables:
+// every client and setting is passed in through the options,
+// and each check returns its structured result.
+package kibertas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/chatwork/kibertas/cmd"
+	"github.com/chatwork/kibertas/util"
+	"github.com/chatwork/kibertas/util/notify"
+	"github.com/chatwork/kibertas/util/report"
+)
+
+// DefaultTimeout is used when Options.Timeout is not set.
+const DefaultTimeout = 15 * time.Minute
+
+// Options are common to all checks.
+type Options struct {
+	// Context cancels the check. Defaults to context.Background().
+	Context context.Context
+	// Logger defaults to the logrus standard logger.
+	Logger func() *logrus.Entry
+	// Notifier receives the messages of the check. Nothing is sent if it is nil.
+	Notifier notify.Notifier
+	// ClusterName is shown in notifications and reports.
+	ClusterName string
+	// Timeout bounds each wait of the check. Defaults to DefaultTimeout.
+	Timeout time.Duration
+	// KeepResources skips deleting the test resources, like --debug does in the CLI.
+	KeepResources bool
+	// Run collects the results of several checks into one report.
+	// A new run is started for each check if it is nil.
+	Run *report.Run
+}
+
+// ErrNoClient is returned when a required client is not set in the options.
+var ErrNoClient = errors.New("client is required")
+
+func (o Options) checker() *cmd.Checker {
+	ctx := o.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	logger := o.Logger
+	if logger == nil {
+		logger = func() *logrus.Entry { return logrus.NewEntry(logrus.StandardLogger()) }
+	}
+	timeout := o.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	checker := cmd.NewChecker(ctx, o.KeepResources, logger, o.Notifier, o.ClusterName, timeout)
+	if o.Run != nil {
+		checker.Report = o.Run
+	}
+	return checker
+}
+
+// check runs c and returns the result it recorded.
+func check(checker *cmd.Checker, c interface{ Check() error }) (*report.CheckResult, error) {
+	before := len(checker.Report.Checks)
+	err := c.Check()
+	if len(checker.Report.Checks) == before {
+		return nil, err
+	}
+	return checker.Report.Checks[len(checker.Report.Checks)-1], err
+}
+
+func namespace(prefix string) string {
+	t := time.Now()
+	return fmt.Sprintf("%s-test-%d%02d%02d-%s", prefix, t.Year(), t.Month(), t.Day(), util.GenerateRandomString(5))
+}
+
+func orDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,67 @@
+package kibertas
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+type fakeMetrics struct {
+	series []datadogV1.MetricsQueryMetadata
+}
+
+func (f *fakeMetrics) QueryMetrics(ctx context.Context, from, to int64, query string) (datadogV1.MetricsQueryResponse, *http.Response, error) {
+	resp := datadogV1.MetricsQueryResponse{}
+	resp.SetSeries(f.series)
+	return resp, &http.Response{StatusCode: 200}, nil
+}
+
+func TestCheckDatadogAgent(t *testing.T) {
+	run := report.NewRun("test")
+
+	result, err := CheckDatadogAgent(DatadogAgentOptions{
+		Options: Options{ClusterName: "test", Run: run},
+		Metrics: &fakeMetrics{series: []datadogV1.MetricsQueryMetadata{{Scope: datadog.PtrString("*")}}},
+		// Skip most of the initial wait for metrics to be reported.
+		WaitTime: time.Millisecond,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "datadog-agent", result.Name)
+	require.Equal(t, report.StatusPassed, result.Status)
+	require.Len(t, result.Artifacts, 1)
+	require.Equal(t, report.KindDatadogSeries, result.Artifacts[0].Kind)
+	require.Same(t, result, run.Checks[0])
+
+	result, err = CheckDatadogAgent(DatadogAgentOptions{
+		Options:  Options{Timeout: time.Millisecond, Run: run},
+		Metrics:  &fakeMetrics{},
+		WaitTime: time.Millisecond,
+	})
+	require.Error(t, err)
+	require.Equal(t, report.StatusFailed, result.Status)
+	require.Len(t, run.Checks, 2)
+}
+
+func TestRequiredClients(t *testing.T) {
+	for name, run := range map[string]func() (*report.CheckResult, error){
+		"cluster-autoscaler": func() (*report.CheckResult, error) { return CheckClusterAutoscaler(ClusterAutoscalerOptions{}) },
+		"ingress":            func() (*report.CheckResult, error) { return CheckIngress(IngressOptions{}) },
+		"fluent":             func() (*report.CheckResult, error) { return CheckFluent(FluentOptions{}) },
+		"cert-manager":       func() (*report.CheckResult, error) { return CheckCertManager(CertManagerOptions{}) },
+		"datadog-agent":      func() (*report.CheckResult, error) { return CheckDatadogAgent(DatadogAgentOptions{}) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			result, err := run()
+			require.Nil(t, result)
+			require.True(t, errors.Is(err, ErrNoClient), "unexpected error: %v", err)
+		})
+	}
+}
@@ -0,0 +1,317 @@
+// Package inventory records the versions of the cluster add-ons,
+// so that runs before and after an upgrade can be compared.
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultNamespaces are the namespaces whose workloads are recorded
+// unless others are given.
+var DefaultNamespaces = []string{"kube-system"}
+
+var crdResource = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+const (
+	chartLabel   = "helm.sh/chart"
+	versionLabel = "app.kubernetes.io/version"
+)
+
+// Snapshot is the inventory of a cluster at a point in time.
+type Snapshot struct {
+	TakenAt   time.Time  `json:"takenAt"`
+	Workloads []Workload `json:"workloads"`
+	CRDs      []CRD      `json:"crds"`
+	Nodes     []Node     `json:"nodes"`
+}
+
+// Workload is a Deployment or DaemonSet of an add-on.
+type Workload struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Images maps container names to their images.
+	Images  map[string]string `json:"images"`
+	Chart   string            `json:"chart,omitempty"`
+	Version string            `json:"version,omitempty"`
+}
+
+type CRD struct {
+	Name string `json:"name"`
+	// Versions are the served versions.
+	Versions []string `json:"versions"`
+	Storage  string   `json:"storage,omitempty"`
+}
+
+type Node struct {
+	Name             string `json:"name"`
+	KubeletVersion   string `json:"kubeletVersion"`
+	OSImage          string `json:"osImage"`
+	KernelVersion    string `json:"kernelVersion"`
+	ContainerRuntime string `json:"containerRuntime"`
+}
+
+// Collect takes a snapshot of the Deployments and DaemonSets in namespaces,
+// the CRDs and the nodes. dynamicClient may be nil to skip the CRDs.
+func Collect(ctx context.Context, clientset kubernetes.Interface, dynamicClient dynamic.Interface, namespaces []string) (*Snapshot, error) {
+	s := &Snapshot{TakenAt: time.Now()}
+
+	for _, ns := range namespaces {
+		deployments, err := clientset.AppsV1().Deployments(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("listing deployments in %s: %w", ns, err)
+		}
+		for _, d := range deployments.Items {
+			s.Workloads = append(s.Workloads, newWorkload("Deployment", d.ObjectMeta, images(d.Spec.Template.Spec.InitContainers, d.Spec.Template.Spec.Containers)))
+		}
+
+		daemonSets, err := clientset.AppsV1().DaemonSets(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("listing daemonsets in %s: %w", ns, err)
+		}
+		for _, d := range daemonSets.Items {
+			s.Workloads = append(s.Workloads, newWorkload("DaemonSet", d.ObjectMeta, images(d.Spec.Template.Spec.InitContainers, d.Spec.Template.Spec.Containers)))
+		}
+	}
+	sort.Slice(s.Workloads, func(i, j int) bool { return s.Workloads[i].key() < s.Workloads[j].key() })
+
+	if dynamicClient != nil {
+		crds, err := dynamicClient.Resource(crdResource).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("listing crds: %w", err)
+		}
+		for _, item := range crds.Items {
+			s.CRDs = append(s.CRDs, newCRD(item))
+		}
+		sort.Slice(s.CRDs, func(i, j int) bool { return s.CRDs[i].Name < s.CRDs[j].Name })
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing nodes: %w", err)
+	}
+	for _, n := range nodes.Items {
+		info := n.Status.NodeInfo
+		s.Nodes = append(s.Nodes, Node{
+			Name:             n.Name,
+			KubeletVersion:   info.KubeletVersion,
+			OSImage:          info.OSImage,
+			KernelVersion:    info.KernelVersion,
+			ContainerRuntime: info.ContainerRuntimeVersion,
+		})
+	}
+	sort.Slice(s.Nodes, func(i, j int) bool { return s.Nodes[i].Name < s.Nodes[j].Name })
+
+	return s, nil
+}
+
+func newWorkload(kind string, meta metav1.ObjectMeta, images map[string]string) Workload {
+	return Workload{
+		Kind:      kind,
+		Namespace: meta.Namespace,
+		Name:      meta.Name,
+		Images:    images,
+		Chart:     meta.Labels[chartLabel],
+		Version:   meta.Labels[versionLabel],
+	}
+}
+
+func images(containerLists ...[]corev1.Container) map[string]string {
+	images := map[string]string{}
+	for _, containers := range containerLists {
+		for _, c := range containers {
+			images[c.Name] = c.Image
+		}
+	}
+	return images
+}
+
+func newCRD(u unstructured.Unstructured) CRD {
+	crd := CRD{Name: u.GetName()}
+	versions, _, _ := unstructured.NestedSlice(u.Object, "spec", "versions")
+	for _, v := range versions {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := m["name"].(string)
+		if served, _ := m["served"].(bool); served {
+			crd.Versions = append(crd.Versions, name)
+		}
+		if storage, _ := m["storage"].(bool); storage {
+			crd.Storage = name
+		}
+	}
+	return crd
+}
+
+func (w Workload) key() string {
+	return w.Kind + " " + w.Namespace + "/" + w.Name
+}
+
+// Change is a difference between two snapshots.
+// Before is empty for added items, and After for removed ones.
+type Change struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Field  string `json:"field,omitempty"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+func (c Change) String() string {
+	var parts []string
+	for _, p := range []string{c.Kind, c.Name, c.Field} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	name := strings.Join(parts, " ")
+	switch {
+	case c.Before == "":
+		return fmt.Sprintf("+ %s: %s", name, c.After)
+	case c.After == "":
+		return fmt.Sprintf("- %s: %s", name, c.Before)
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", name, c.Before, c.After)
+	}
+}
+
+// item is an object of a snapshot flattened to its versioned fields.
+type item struct {
+	kind, name string
+	fields     map[string]string
+}
+
+// Diff returns the changes from before to after, sorted by kind and name.
+// Nodes are compared by the number of nodes per version rather than one by one,
+// since they are usually replaced during an upgrade.
+func Diff(before, after *Snapshot) []Change {
+	a, b := before.items(), after.items()
+
+	var changes []Change
+	for key, x := range a {
+		y, ok := b[key]
+		if !ok {
+			changes = append(changes, Change{Kind: x.kind, Name: x.name, Before: x.summary()})
+			continue
+		}
+		for _, field := range fieldNames(x.fields, y.fields) {
+			if x.fields[field] != y.fields[field] {
+				changes = append(changes, Change{Kind: x.kind, Name: x.name, Field: field, Before: x.fields[field], After: y.fields[field]})
+			}
+		}
+	}
+	for key, y := range b {
+		if _, ok := a[key]; !ok {
+			changes = append(changes, Change{Kind: y.kind, Name: y.name, After: y.summary()})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		x, y := changes[i], changes[j]
+		if x.Kind != y.Kind {
+			return x.Kind < y.Kind
+		}
+		if x.Name != y.Name {
+			return x.Name < y.Name
+		}
+		return x.Field < y.Field
+	})
+	return changes
+}
+
+func (s *Snapshot) items() map[string]item {
+	items := map[string]item{}
+	if s == nil {
+		return items
+	}
+	add := func(kind, name string, fields map[string]string) {
+		items[kind+" "+name] = item{kind: kind, name: name, fields: fields}
+	}
+
+	for _, w := range s.Workloads {
+		fields := map[string]string{}
+		for container, image := range w.Images {
+			fields["image "+container] = image
+		}
+		if w.Chart != "" {
+			fields["chart"] = w.Chart
+		}
+		if w.Version != "" {
+			fields["version"] = w.Version
+		}
+		add(w.Kind, w.Namespace+"/"+w.Name, fields)
+	}
+
+	for _, c := range s.CRDs {
+		fields := map[string]string{"versions": strings.Join(c.Versions, ",")}
+		if c.Storage != "" {
+			fields["storage"] = c.Storage
+		}
+		add("CRD", c.Name, fields)
+	}
+
+	if len(s.Nodes) > 0 {
+		add("Nodes", "", map[string]string{
+			"kubelet": count(s.Nodes, func(n Node) string { return n.KubeletVersion }),
+			"os":      count(s.Nodes, func(n Node) string { return n.OSImage }),
+			"kernel":  count(s.Nodes, func(n Node) string { return n.KernelVersion }),
+			"runtime": count(s.Nodes, func(n Node) string { return n.ContainerRuntime }),
+		})
+	}
+	return items
+}
+
+func (i item) summary() string {
+	var parts []string
+	for _, field := range fieldNames(i.fields) {
+		parts = append(parts, field+"="+i.fields[field])
+	}
+	return strings.Join(parts, " ")
+}
+
+func fieldNames(maps ...map[string]string) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, m := range maps {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				names = append(names, k)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// count formats the number of nodes per value, e.g. "v1.29.0 x2, v1.30.1 x3".
+func count(nodes []Node, value func(Node) string) string {
+	counts := map[string]int{}
+	for _, n := range nodes {
+		counts[value(n)]++
+	}
+	var values []string
+	for v := range counts {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+
+	var parts []string
+	for _, v := range values {
+		parts = append(parts, fmt.Sprintf("%s x%d", v, counts[v]))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,101 @@
+package inventory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCollect(t *testing.T) {
+	clientset := fake.NewClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "coredns", Namespace: "kube-system", Labels: map[string]string{chartLabel: "coredns-1.29.0"}},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "coredns", Image: "coredns:v1.11.1"}},
+			}}},
+		},
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "aws-node", Namespace: "kube-system"},
+			Spec: appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "aws-vpc-cni-init", Image: "amazon-k8s-cni-init:v1.18.0"}},
+				Containers:     []corev1.Container{{Name: "aws-node", Image: "amazon-k8s-cni:v1.18.0"}},
+			}}},
+		},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "ignored", Namespace: "default"}},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+			Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{KubeletVersion: "v1.30.1", OSImage: "Bottlerocket OS 1.20.0"}},
+		},
+	)
+	crd := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata":   map[string]interface{}{"name": "certificates.cert-manager.io"},
+		"spec": map[string]interface{}{"versions": []interface{}{
+			map[string]interface{}{"name": "v1", "served": true, "storage": true},
+			map[string]interface{}{"name": "v1alpha2", "served": false, "storage": false},
+		}},
+	}}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{crdResource: "CustomResourceDefinitionList"}, crd)
+
+	s, err := Collect(context.Background(), clientset, dynamicClient, DefaultNamespaces)
+	require.NoError(t, err)
+
+	require.Equal(t, []Workload{
+		{Kind: "DaemonSet", Namespace: "kube-system", Name: "aws-node", Images: map[string]string{"aws-vpc-cni-init": "amazon-k8s-cni-init:v1.18.0", "aws-node": "amazon-k8s-cni:v1.18.0"}},
+		{Kind: "Deployment", Namespace: "kube-system", Name: "coredns", Images: map[string]string{"coredns": "coredns:v1.11.1"}, Chart: "coredns-1.29.0"},
+	}, s.Workloads)
+	require.Equal(t, []CRD{{Name: "certificates.cert-manager.io", Versions: []string{"v1"}, Storage: "v1"}}, s.CRDs)
+	require.Equal(t, []Node{{Name: "node-1", KubeletVersion: "v1.30.1", OSImage: "Bottlerocket OS 1.20.0"}}, s.Nodes)
+}
+
+func TestDiff(t *testing.T) {
+	before := &Snapshot{
+		Workloads: []Workload{
+			{Kind: "Deployment", Namespace: "kube-system", Name: "coredns", Images: map[string]string{"coredns": "coredns:v1.11.1"}},
+			{Kind: "Deployment", Namespace: "kube-system", Name: "removed", Images: map[string]string{"app": "app:v1"}},
+		},
+		CRDs: []CRD{{Name: "certificates.cert-manager.io", Versions: []string{"v1"}, Storage: "v1"}},
+		Nodes: []Node{
+			{Name: "node-1", KubeletVersion: "v1.29.0"},
+			{Name: "node-2", KubeletVersion: "v1.29.0"},
+		},
+	}
+	after := &Snapshot{
+		Workloads: []Workload{
+			{Kind: "Deployment", Namespace: "kube-system", Name: "coredns", Images: map[string]string{"coredns": "coredns:v1.11.3"}},
+		},
+		CRDs: []CRD{
+			{Name: "certificates.cert-manager.io", Versions: []string{"v1"}, Storage: "v1"},
+			{Name: "issuers.cert-manager.io", Versions: []string{"v1"}, Storage: "v1"},
+		},
+		Nodes: []Node{
+			{Name: "node-3", KubeletVersion: "v1.30.1"},
+			{Name: "node-4", KubeletVersion: "v1.30.1"},
+		},
+	}
+
+	var lines []string
+	for _, c := range Diff(before, after) {
+		lines = append(lines, c.String())
+	}
+	require.Equal(t, []string{
+		"+ CRD issuers.cert-manager.io: storage=v1 versions=v1",
+		"~ Deployment kube-system/coredns image coredns: coredns:v1.11.1 -> coredns:v1.11.3",
+		"- Deployment kube-system/removed: image app=app:v1",
+		"~ Nodes kubelet: v1.29.0 x2 -> v1.30.1 x2",
+	}, lines)
+
+	require.Empty(t, Diff(before, before))
+	require.Len(t, Diff(nil, after), 4)
+}
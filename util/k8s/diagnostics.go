@@ -0,0 +1,193 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// Events returns the events in the namespace, oldest first, one per line
+// in a format close to `kubectl get events`.
+func (k *K8s) Events(ctx context.Context) (string, error) {
+	events, err := k.clientset.CoreV1().Events(k.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	items := events.Items
+	sort.Slice(items, func(i, j int) bool {
+		return eventTime(items[i]).Time.Before(eventTime(items[j]).Time)
+	})
+
+	var b strings.Builder
+	for _, e := range items {
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s/%s\t%s\n",
+			eventTime(e).Format("15:04:05"), e.Type, e.Reason, e.InvolvedObject.Kind, e.InvolvedObject.Name, e.Message)
+	}
+	return b.String(), nil
+}
+
+// PodLogs returns the last tailLines lines of every container in the namespace,
+// keyed by "<pod>/<container>".
+func (k *K8s) PodLogs(ctx context.Context, tailLines int64) (map[string]string, error) {
+	pods, err := k.clientset.CoreV1().Pods(k.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	logs := map[string]string{}
+	for _, pod := range pods.Items {
+		for _, c := range pod.Spec.Containers {
+			name := pod.Name + "/" + c.Name
+			raw, err := k.clientset.CoreV1().Pods(k.namespace).GetLogs(pod.Name, &apiv1.PodLogOptions{
+				Container: c.Name,
+				TailLines: &tailLines,
+			}).DoRaw(ctx)
+			if err != nil {
+				k.logger().Warnf("Error getting logs of %s: %s", name, err)
+				continue
+			}
+			logs[name] = string(raw)
+		}
+	}
+	return logs, nil
+}
+
+// Objects returns the Pods, Deployments, Services and Ingresses in the namespace
+// as a multi-document YAML, without their managed fields.
+func (k *K8s) Objects(ctx context.Context) (string, error) {
+	var objects []interface{}
+
+	pods, err := k.clientset.CoreV1().Pods(k.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for i := range pods.Items {
+		pods.Items[i].TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+		pods.Items[i].ManagedFields = nil
+		objects = append(objects, &pods.Items[i])
+	}
+
+	deployments, err := k.clientset.AppsV1().Deployments(k.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for i := range deployments.Items {
+		deployments.Items[i].TypeMeta = metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"}
+		deployments.Items[i].ManagedFields = nil
+		objects = append(objects, &deployments.Items[i])
+	}
+
+	services, err := k.clientset.CoreV1().Services(k.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for i := range services.Items {
+		services.Items[i].TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Service"}
+		services.Items[i].ManagedFields = nil
+		objects = append(objects, &services.Items[i])
+	}
+
+	ingresses, err := k.clientset.NetworkingV1().Ingresses(k.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for i := range ingresses.Items {
+		ingresses.Items[i].TypeMeta = metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "Ingress"}
+		ingresses.Items[i].ManagedFields = nil
+		objects = append(objects, &ingresses.Items[i])
+	}
+
+	docs := make([]string, 0, len(objects))
+	for _, o := range objects {
+		doc, err := yaml.Marshal(o)
+		if err != nil {
+			return "", err
+		}
+		docs = append(docs, string(doc))
+	}
+	return strings.Join(docs, "---\n"), nil
+}
+
+// terminationBlockers returns an error listing what keeps the namespace terminating:
+// the conditions the namespace controller reports, such as finalizers remaining on
+// its content, and the objects still in it. waitErr is wrapped.
+func (k *K8s) terminationBlockers(ctx context.Context, waitErr error) error {
+	var blockers []string
+	ns, err := k.clientset.CoreV1().Namespaces().Get(ctx, k.namespace, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("%w (getting the namespace: %s)", waitErr, err)
+	}
+	for _, c := range ns.Status.Conditions {
+		if c.Status == apiv1.ConditionTrue {
+			blockers = append(blockers, fmt.Sprintf("%s: %s", c.Type, c.Message))
+		}
+	}
+	if remaining := k.remainingObjects(ctx); len(remaining) > 0 {
+		blockers = append(blockers, "remaining objects: "+strings.Join(remaining, ", "))
+	}
+	if len(blockers) == 0 {
+		return waitErr
+	}
+	return fmt.Errorf("%s (%w)", strings.Join(blockers, "; "), waitErr)
+}
+
+// remainingObjects returns the objects of the kinds kibertas creates that are left
+// in the namespace, with their finalizers. Custom resources show up in the
+// conditions terminationBlockers reports.
+func (k *K8s) remainingObjects(ctx context.Context) []string {
+	var remaining []string
+	add := func(resource string, list runtime.Object, err error) {
+		if err != nil {
+			k.logger().Warnf("Error listing %s: %s", resource, err)
+			return
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return
+		}
+		for _, item := range items {
+			m, err := meta.Accessor(item)
+			if err != nil {
+				continue
+			}
+			desc := resource + "/" + m.GetName()
+			if finalizers := m.GetFinalizers(); len(finalizers) > 0 {
+				desc += fmt.Sprintf(" (finalizers: %s)", strings.Join(finalizers, ", "))
+			}
+			remaining = append(remaining, desc)
+		}
+	}
+
+	opts := metav1.ListOptions{}
+	ingresses, err := k.clientset.NetworkingV1().Ingresses(k.namespace).List(ctx, opts)
+	add("ingresses.networking.k8s.io", ingresses, err)
+	services, err := k.clientset.CoreV1().Services(k.namespace).List(ctx, opts)
+	add("services", services, err)
+	deployments, err := k.clientset.AppsV1().Deployments(k.namespace).List(ctx, opts)
+	add("deployments.apps", deployments, err)
+	replicaSets, err := k.clientset.AppsV1().ReplicaSets(k.namespace).List(ctx, opts)
+	add("replicasets.apps", replicaSets, err)
+	pods, err := k.clientset.CoreV1().Pods(k.namespace).List(ctx, opts)
+	add("pods", pods, err)
+	claims, err := k.clientset.CoreV1().PersistentVolumeClaims(k.namespace).List(ctx, opts)
+	add("persistentvolumeclaims", claims, err)
+	return remaining
+}
+
+func eventTime(e apiv1.Event) metav1.Time {
+	if !e.LastTimestamp.IsZero() {
+		return e.LastTimestamp
+	}
+	if !e.EventTime.IsZero() {
+		return metav1.NewTime(e.EventTime.Time)
+	}
+	return e.CreationTimestamp
+}
@@ -0,0 +1,281 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+)
+
+// Objects applies, reads and deletes objects of any kind, including custom resources,
+// through the dynamic client. Namespaced objects without a namespace are put in the test namespace.
+type Objects struct {
+	namespace string
+	dynamic   dynamic.Interface
+	mapper    meta.RESTMapper
+	logger    func() *logrus.Entry
+}
+
+func NewObjects(namespace string, dynamic dynamic.Interface, mapper meta.RESTMapper, logger func() *logrus.Entry) *Objects {
+	return &Objects{
+		namespace: namespace,
+		dynamic:   dynamic,
+		mapper:    mapper,
+		logger:    logger,
+	}
+}
+
+func (o *Objects) resource(gvk schema.GroupVersionKind, namespace string) (dynamic.ResourceInterface, error) {
+	mapping, err := o.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("finding resource for %s: %w", gvk, err)
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return o.dynamic.Resource(mapping.Resource), nil
+	}
+	if namespace == "" {
+		namespace = o.namespace
+	}
+	return o.dynamic.Resource(mapping.Resource).Namespace(namespace), nil
+}
+
+// Apply applies obj server-side, so that re-runs converge on the same object.
+func (o *Objects) Apply(ctx context.Context, obj *unstructured.Unstructured) error {
+	client, err := o.resource(obj.GroupVersionKind(), obj.GetNamespace())
+	if err != nil {
+		return err
+	}
+
+	o.logger().Infof("Applying %s: %s", obj.GetKind(), obj.GetName())
+	if _, err := client.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{FieldManager: FieldManager, Force: true}); err != nil {
+		o.logger().Errorf("Error applying %s: %s", obj.GetKind(), err)
+		return err
+	}
+	o.logger().Infof("Applied %s: %s", obj.GetKind(), obj.GetName())
+	return nil
+}
+
+// ApplyAll applies objs in dependency order, e.g. CustomResourceDefinitions and
+// ConfigMaps before the workloads using them, and custom resources last.
+// A CustomResourceDefinition is waited for up to crdTimeout to be established before
+// the objects after it are applied.
+// It returns the objects applied so far, in the order DeleteAll expects, even on error.
+func (o *Objects) ApplyAll(ctx context.Context, objs []*unstructured.Unstructured, crdTimeout time.Duration) ([]*unstructured.Unstructured, error) {
+	sorted := append([]*unstructured.Unstructured(nil), objs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return applyOrder(sorted[i]) < applyOrder(sorted[j])
+	})
+
+	var applied []*unstructured.Unstructured
+	for _, obj := range sorted {
+		if err := o.Apply(ctx, obj); err != nil {
+			return applied, err
+		}
+		applied = append(applied, obj)
+
+		if obj.GroupVersionKind().GroupKind() == crdGroupKind {
+			// The custom resources that follow cannot be mapped until the API server serves them.
+			if err := o.WaitReady(ctx, obj, crdTimeout); err != nil {
+				return applied, err
+			}
+			if m, ok := o.mapper.(meta.ResettableRESTMapper); ok {
+				m.Reset()
+			}
+		}
+	}
+	return applied, nil
+}
+
+// WaitReady waits up to timeout for obj to be ready by the rules of ComputeStatus,
+// and fails as soon as it is StatusFailed.
+func (o *Objects) WaitReady(ctx context.Context, obj *unstructured.Unstructured, timeout time.Duration) error {
+	client, err := o.resource(obj.GroupVersionKind(), obj.GetNamespace())
+	if err != nil {
+		return err
+	}
+
+	err = WaitFor(ctx, dynamicWatchGetter{client}, obj.GetName(), timeout, o.logger, func(current *unstructured.Unstructured) (bool, error) {
+		status, message := ComputeStatus(current)
+		switch status {
+		case StatusCurrent:
+			o.logger().Infof("%s %s is ready: %s", obj.GetKind(), obj.GetName(), message)
+			return true, nil
+		case StatusFailed:
+			return false, errors.New(message)
+		}
+		o.logger().Infof("Waiting for %s %s: %s", obj.GetKind(), obj.GetName(), message)
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for %s %s to be ready: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	return nil
+}
+
+// WaitAllReady waits for every object of objs to be ready, within timeout altogether.
+func (o *Objects) WaitAllReady(ctx context.Context, objs []*unstructured.Unstructured, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for _, obj := range objs {
+		if err := o.WaitReady(ctx, obj, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WaitFor waits up to timeout for the object of kind gvk called name in namespace
+// to satisfy cond, watching it like the package WaitFor.
+func (o *Objects) WaitFor(ctx context.Context, gvk schema.GroupVersionKind, namespace, name string, timeout time.Duration, cond func(*unstructured.Unstructured) (bool, error)) error {
+	client, err := o.resource(gvk, namespace)
+	if err != nil {
+		return err
+	}
+	return WaitFor(ctx, dynamicWatchGetter{client}, name, timeout, o.logger, cond)
+}
+
+func (o *Objects) Get(ctx context.Context, gvk schema.GroupVersionKind, namespace, name string) (*unstructured.Unstructured, error) {
+	client, err := o.resource(gvk, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return client.Get(ctx, name, metav1.GetOptions{})
+}
+
+// Delete deletes obj. An object that is already gone is not an error.
+func (o *Objects) Delete(ctx context.Context, obj *unstructured.Unstructured) error {
+	client, err := o.resource(obj.GroupVersionKind(), obj.GetNamespace())
+	if err != nil {
+		return err
+	}
+
+	deletePolicy := metav1.DeletePropagationForeground
+	o.logger().Infof("Deleting %s: %s", obj.GetKind(), obj.GetName())
+	if err := client.Delete(ctx, obj.GetName(), metav1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	}); err != nil && !kerrors.IsNotFound(err) {
+		o.logger().Errorf("Error deleting %s: %s", obj.GetKind(), err)
+		return err
+	}
+	o.logger().Infof("Deleted %s: %s", obj.GetKind(), obj.GetName())
+	return nil
+}
+
+// DeleteAll deletes objs in reverse order, so that the objects ApplyAll returns
+// go before the ones they depend on. It goes on after errors and returns them all.
+func (o *Objects) DeleteAll(ctx context.Context, objs []*unstructured.Unstructured) error {
+	var result *multierror.Error
+	for i := len(objs) - 1; i >= 0; i-- {
+		if err := o.Delete(ctx, objs[i]); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+// DecodeObjects decodes a multi-document YAML or JSON stream into objects.
+// Empty documents are skipped.
+func DecodeObjects(data []byte) ([]*unstructured.Unstructured, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	var objs []*unstructured.Unstructured
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objs, nil
+			}
+			return nil, err
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		if obj.GetKind() == "" || obj.GetName() == "" {
+			return nil, fmt.Errorf("object without kind or metadata.name: %v", obj.Object)
+		}
+		objs = append(objs, obj)
+	}
+}
+
+// ToUnstructured converts a typed object, e.g. an *appsv1.Deployment, to one Apply takes.
+// scheme gives its apiVersion and kind. The fields the API server and controllers set,
+// like the status, are dropped so that applying them does not take them over.
+func ToUnstructured(scheme *runtime.Scheme, obj runtime.Object) (*unstructured.Unstructured, error) {
+	gvks, _, err := scheme.ObjectKinds(obj)
+	if err != nil {
+		return nil, err
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	u.SetGroupVersionKind(gvks[0])
+	unstructured.RemoveNestedField(u.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(u.Object, "status")
+	return u, nil
+}
+
+var crdGroupKind = schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}
+
+// installOrder is the order ApplyAll applies kinds in, after Helm's.
+// Kinds not listed, such as custom resources, come last.
+var installOrder = []string{
+	"Namespace",
+	"NetworkPolicy",
+	"ResourceQuota",
+	"LimitRange",
+	"PodDisruptionBudget",
+	"ServiceAccount",
+	"Secret",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicaSet",
+	"Deployment",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"Ingress",
+}
+
+func applyOrder(obj *unstructured.Unstructured) int {
+	for i, kind := range installOrder {
+		if obj.GetKind() == kind {
+			return i
+		}
+	}
+	return len(installOrder)
+}
+
+// dynamicWatchGetter lets WaitFor watch objects through the dynamic client.
+type dynamicWatchGetter struct {
+	dynamic.ResourceInterface
+}
+
+func (d dynamicWatchGetter) Get(ctx context.Context, name string, opts metav1.GetOptions) (*unstructured.Unstructured, error) {
+	return d.ResourceInterface.Get(ctx, name, opts)
+}
@@ -0,0 +1,254 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
+
+	"github.com/chatwork/kibertas/internal/ktesting"
+)
+
+func mustDecode(t *testing.T, manifest string) []*unstructured.Unstructured {
+	t.Helper()
+	objs, err := DecodeObjects([]byte(manifest))
+	require.NoError(t, err)
+	return objs
+}
+
+func TestComputeStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		manifest string
+		want     Status
+	}{
+		{
+			name: "deployment rolled out",
+			manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: a, generation: 2}
+spec: {replicas: 2}
+status: {observedGeneration: 2, replicas: 2, updatedReplicas: 2, readyReplicas: 2, availableReplicas: 2}`,
+			want: StatusCurrent,
+		},
+		{
+			name: "deployment with old replicas",
+			manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: a}
+spec: {replicas: 2}
+status: {replicas: 3, updatedReplicas: 2, readyReplicas: 2, availableReplicas: 2}`,
+			want: StatusInProgress,
+		},
+		{
+			name: "deployment generation not observed",
+			manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: a, generation: 3}
+spec: {replicas: 1}
+status: {observedGeneration: 2, replicas: 1, updatedReplicas: 1, readyReplicas: 1, availableReplicas: 1}`,
+			want: StatusInProgress,
+		},
+		{
+			name: "deployment past its progress deadline",
+			manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: a}
+status:
+  conditions:
+  - {type: Progressing, status: "False", reason: ProgressDeadlineExceeded}`,
+			want: StatusFailed,
+		},
+		{
+			name: "failed job",
+			manifest: `
+apiVersion: batch/v1
+kind: Job
+metadata: {name: a}
+status:
+  conditions:
+  - {type: Failed, status: "True", reason: BackoffLimitExceeded}`,
+			want: StatusFailed,
+		},
+		{
+			name: "running pod",
+			manifest: `
+apiVersion: v1
+kind: Pod
+metadata: {name: a}
+status:
+  phase: Running
+  conditions:
+  - {type: Ready, status: "True"}`,
+			want: StatusCurrent,
+		},
+		{
+			name: "load balancer without address",
+			manifest: `
+apiVersion: v1
+kind: Service
+metadata: {name: a}
+spec: {type: LoadBalancer}`,
+			want: StatusInProgress,
+		},
+		{
+			name: "config map",
+			manifest: `
+apiVersion: v1
+kind: ConfigMap
+metadata: {name: a}`,
+			want: StatusCurrent,
+		},
+		{
+			name: "custom resource not ready",
+			manifest: `
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata: {name: a}
+status:
+  conditions:
+  - {type: Ready, status: "False", reason: Issuing}`,
+			want: StatusInProgress,
+		},
+		{
+			name: "stalled custom resource",
+			manifest: `
+apiVersion: example.com/v1
+kind: Widget
+metadata: {name: a}
+status:
+  conditions:
+  - {type: Stalled, status: "True", reason: InvalidSpec}`,
+			want: StatusFailed,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status, message := ComputeStatus(mustDecode(t, tc.manifest)[0])
+			require.Equal(t, tc.want, status, message)
+		})
+	}
+}
+
+func newTestObjects(client *dynamicfake.FakeDynamicClient) *Objects {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for _, gvk := range []schema.GroupVersionKind{
+		{Group: "apps", Version: "v1", Kind: "Deployment"},
+		{Version: "v1", Kind: "Service"},
+		{Version: "v1", Kind: "ConfigMap"},
+		{Group: "example.com", Version: "v1", Kind: "Widget"},
+	} {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+	return NewObjects("test", client, mapper, testLogger)
+}
+
+const testManifest = `
+apiVersion: example.com/v1
+kind: Widget
+metadata: {name: widget}
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: app}
+spec: {replicas: 1}
+---
+apiVersion: v1
+kind: Service
+metadata: {name: app}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata: {name: config}
+`
+
+func actions(client *dynamicfake.FakeDynamicClient, verb string) []string {
+	var names []string
+	for _, action := range client.Actions() {
+		if action.GetVerb() != verb {
+			continue
+		}
+		switch a := action.(type) {
+		case k8stesting.PatchAction:
+			names = append(names, a.GetResource().Resource+"/"+a.GetName())
+		case k8stesting.DeleteAction:
+			names = append(names, a.GetResource().Resource+"/"+a.GetName())
+		}
+	}
+	return names
+}
+
+func TestApplyAllAndDeleteAll(t *testing.T) {
+	ctx := context.Background()
+	client := ktesting.NewDynamicClient()
+	o := newTestObjects(client)
+
+	applied, err := o.ApplyAll(ctx, mustDecode(t, testManifest), time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"configmaps/config", "services/app", "deployments/app", "widgets/widget"}, actions(client, "patch"))
+
+	// Applying again converges on the same objects.
+	_, err = o.ApplyAll(ctx, mustDecode(t, testManifest), time.Minute)
+	require.NoError(t, err)
+	d, err := o.Get(ctx, schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, "", "app")
+	require.NoError(t, err)
+	require.Equal(t, "test", d.GetNamespace())
+
+	require.NoError(t, o.DeleteAll(ctx, applied))
+	require.Equal(t, []string{"widgets/widget", "deployments/app", "services/app", "configmaps/config"}, actions(client, "delete"))
+	// Objects already gone are not an error.
+	require.NoError(t, o.DeleteAll(ctx, applied))
+}
+
+func TestWaitReady(t *testing.T) {
+	ctx := context.Background()
+	deployment := mustDecode(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: app, namespace: test}
+spec: {replicas: 1}
+status: {replicas: 1, updatedReplicas: 1, readyReplicas: 1, availableReplicas: 1}`)[0]
+	o := newTestObjects(dynamicfake.NewSimpleDynamicClient(scheme.Scheme, deployment))
+	require.NoError(t, o.WaitAllReady(ctx, []*unstructured.Unstructured{deployment}, time.Minute))
+
+	// A failed object fails the wait without waiting for the timeout.
+	require.NoError(t, unstructured.SetNestedSlice(deployment.Object, []interface{}{
+		map[string]interface{}{"type": "Progressing", "status": "False", "reason": "ProgressDeadlineExceeded", "message": "timed out"},
+	}, "status", "conditions"))
+	o = newTestObjects(dynamicfake.NewSimpleDynamicClient(scheme.Scheme, deployment))
+	err := o.WaitReady(ctx, deployment, time.Hour)
+	require.ErrorContains(t, err, "waiting for Deployment app to be ready: progress deadline exceeded: timed out")
+
+	// Objects that never become ready time out.
+	service := mustDecode(t, `
+apiVersion: v1
+kind: Service
+metadata: {name: lb, namespace: test}
+spec: {type: LoadBalancer}`)[0]
+	o = newTestObjects(dynamicfake.NewSimpleDynamicClient(scheme.Scheme, service))
+	require.ErrorIs(t, o.WaitReady(ctx, service, 100*time.Millisecond), context.DeadlineExceeded)
+}
+
+func TestToUnstructured(t *testing.T) {
+	u, err := ToUnstructured(scheme.Scheme, testDeployment(2))
+	require.NoError(t, err)
+	require.Equal(t, "apps/v1", u.GetAPIVersion())
+	require.Equal(t, "Deployment", u.GetKind())
+
+	data, err := yaml.Marshal(u.Object)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "status")
+	require.NotContains(t, string(data), "creationTimestamp")
+	require.Contains(t, string(data), "replicas: 2")
+}
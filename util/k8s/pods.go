@@ -0,0 +1,206 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// revisionAnnotation is the revision the Deployment controller gives a Deployment
+// and the ReplicaSet of that revision.
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+// fatalWaitingReasons are the reasons a container waits for that it does not get out of by itself.
+var fatalWaitingReasons = map[string]bool{
+	"ImagePullBackOff":           true,
+	"ErrImageNeverPull":          true,
+	"InvalidImageName":           true,
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+}
+
+// podDiagnosis is the latest reason the pods are not ready, shared between
+// the readiness wait and the goroutine checking the pods.
+type podDiagnosis struct {
+	mu      sync.Mutex
+	message string
+}
+
+func (d *podDiagnosis) set(message string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.message = message
+}
+
+func (d *podDiagnosis) get() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.message
+}
+
+// checkPods diagnoses the pods of the Deployment called name every PollInterval until ctx is done.
+// It cancels ctx with the diagnosis as cause when the pods cannot become ready.
+func (k *K8s) checkPods(ctx context.Context, cancel context.CancelCauseFunc, name string, diagnosis *podDiagnosis) {
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+	for {
+		message, err := k.diagnosePods(ctx, name)
+		if err != nil {
+			cancel(err)
+			return
+		}
+		diagnosis.set(message)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// diagnosePods inspects the pods of the current ReplicaSet of the Deployment called name.
+// It returns an error for states that need a fix, such as an image that cannot be
+// pulled, a container crashing in a loop or a pod cluster-autoscaler won't add a node for.
+// Otherwise, it describes why pods are not ready yet, e.g. while they wait for a scale-up.
+//
+// Pods of older ReplicaSets, e.g. left by an earlier run into the same namespace,
+// and pods being deleted or already replaced are not looked at.
+func (k *K8s) diagnosePods(ctx context.Context, name string) (string, error) {
+	hash, err := k.currentPodTemplateHash(ctx, name)
+	if err != nil {
+		k.logger().Warnf("Error finding the ReplicaSet of Deployment %s: %s", name, err)
+		return "", nil
+	}
+	if hash == "" {
+		// The Deployment controller has not created the ReplicaSet yet.
+		return "", nil
+	}
+	pods, err := k.clientset.CoreV1().Pods(k.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set{appsv1.DefaultDeploymentUniqueLabelKey: hash}.String(),
+	})
+	if err != nil {
+		k.logger().Warnf("Error listing Pods: %s", err)
+		return "", nil
+	}
+	var events []apiv1.Event
+	if list, err := k.clientset.CoreV1().Events(k.namespace).List(ctx, metav1.ListOptions{}); err == nil {
+		events = list.Items
+	}
+
+	var messages []string
+	for _, pod := range pods.Items {
+		// A ReplicaSet replaces failed pods, e.g. evicted ones, rather than waiting for them.
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == apiv1.PodFailed {
+			continue
+		}
+		message, err := diagnosePod(pod, podEvents(events, pod.Name))
+		if err != nil {
+			return "", err
+		}
+		if message != "" {
+			messages = append(messages, message)
+		}
+	}
+	return strings.Join(messages, "; "), nil
+}
+
+func diagnosePod(pod apiv1.Pod, events []apiv1.Event) (string, error) {
+	statuses := append(append([]apiv1.ContainerStatus(nil), pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		waiting := cs.State.Waiting
+		if waiting == nil {
+			continue
+		}
+		if fatalWaitingReasons[waiting.Reason] {
+			err := fmt.Errorf("pod %s: container %s is in %s: %s", pod.Name, cs.Name, waiting.Reason, waiting.Message)
+			if t := cs.LastTerminationState.Terminated; t != nil {
+				err = fmt.Errorf("%w (last exit code %d, %s)", err, t.ExitCode, t.Reason)
+			}
+			return "", err
+		}
+		if waiting.Reason != "" && waiting.Reason != "ContainerCreating" && waiting.Reason != "PodInitializing" {
+			return fmt.Sprintf("pod %s: container %s is in %s", pod.Name, cs.Name, waiting.Reason), nil
+		}
+	}
+
+	for _, c := range pod.Status.Conditions {
+		if c.Type != apiv1.PodScheduled || c.Status != apiv1.ConditionFalse || c.Reason != apiv1.PodReasonUnschedulable {
+			continue
+		}
+		message := c.Message
+		if e := latestEvent(events, "FailedScheduling"); e != nil {
+			message = e.Message
+		}
+		// Pending is expected while cluster-autoscaler adds a node, not when it gave up on the pod.
+		notTriggered := latestEvent(events, "NotTriggerScaleUp")
+		if notTriggered != nil && latestEvent(events, "TriggeredScaleUp") == nil {
+			return "", fmt.Errorf("pod %s is unschedulable (%s) and cluster-autoscaler won't add a node: %s", pod.Name, message, notTriggered.Message)
+		}
+		return fmt.Sprintf("pod %s is unschedulable: %s", pod.Name, message), nil
+	}
+	return "", nil
+}
+
+// currentPodTemplateHash returns the pod-template-hash of the ReplicaSet of the
+// Deployment called name with the Deployment's revision, or "" if there is none yet.
+func (k *K8s) currentPodTemplateHash(ctx context.Context, name string) (string, error) {
+	deployment, err := k.clientset.AppsV1().Deployments(k.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	revision := deployment.Annotations[revisionAnnotation]
+	if revision == "" {
+		return "", nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return "", err
+	}
+	replicaSets, err := k.clientset.AppsV1().ReplicaSets(k.namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return "", err
+	}
+	for _, rs := range replicaSets.Items {
+		owner := metav1.GetControllerOf(&rs)
+		if owner == nil || owner.Kind != "Deployment" || owner.Name != name || owner.UID != deployment.UID {
+			continue
+		}
+		if rs.Annotations[revisionAnnotation] == revision {
+			return rs.Labels[appsv1.DefaultDeploymentUniqueLabelKey], nil
+		}
+	}
+	return "", nil
+}
+
+// podEvents returns the events about the pod called name.
+func podEvents(events []apiv1.Event, name string) []apiv1.Event {
+	var matched []apiv1.Event
+	for _, e := range events {
+		if e.InvolvedObject.Kind == "Pod" && e.InvolvedObject.Name == name {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+// latestEvent returns the latest of events with reason, or nil.
+func latestEvent(events []apiv1.Event, reason string) *apiv1.Event {
+	var latest *apiv1.Event
+	for i := range events {
+		if events[i].Reason != reason {
+			continue
+		}
+		if latest == nil || eventTime(events[i]).After(eventTime(*latest).Time) {
+			latest = &events[i]
+		}
+	}
+	return latest
+}
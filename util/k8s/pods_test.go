@@ -0,0 +1,192 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/chatwork/kibertas/internal/ktesting"
+	"github.com/chatwork/kibertas/util"
+)
+
+func testPod(status apiv1.PodStatus) *apiv1.Pod {
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "sample-abc", Namespace: "test", Labels: map[string]string{"app": "sample", appsv1.DefaultDeploymentUniqueLabelKey: "abc"}},
+		Status:     status,
+	}
+}
+
+// testReplicaSet returns a ReplicaSet of the sample Deployment for the pods with hash.
+func testReplicaSet(hash, revision string) *appsv1.ReplicaSet {
+	controller := true
+	return &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "sample-" + hash,
+			Namespace:       "test",
+			Labels:          map[string]string{"app": "sample", appsv1.DefaultDeploymentUniqueLabelKey: hash},
+			Annotations:     map[string]string{revisionAnnotation: revision},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "sample", Controller: &controller}},
+		},
+	}
+}
+
+func waitingStatus(reason, message string) apiv1.PodStatus {
+	return apiv1.PodStatus{
+		Phase: apiv1.PodPending,
+		ContainerStatuses: []apiv1.ContainerStatus{{
+			Name:  "app",
+			State: apiv1.ContainerState{Waiting: &apiv1.ContainerStateWaiting{Reason: reason, Message: message}},
+		}},
+	}
+}
+
+var unschedulableStatus = apiv1.PodStatus{
+	Phase: apiv1.PodPending,
+	Conditions: []apiv1.PodCondition{{
+		Type:    apiv1.PodScheduled,
+		Status:  apiv1.ConditionFalse,
+		Reason:  apiv1.PodReasonUnschedulable,
+		Message: "0/3 nodes are available",
+	}},
+}
+
+func podEvent(reason, message string, at time.Time) *apiv1.Event {
+	return &apiv1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "sample-abc." + reason, Namespace: "test"},
+		InvolvedObject: apiv1.ObjectReference{Kind: "Pod", Name: "sample-abc"},
+		Reason:         reason,
+		Message:        message,
+		LastTimestamp:  metav1.NewTime(at),
+	}
+}
+
+func selectedDeployment() *appsv1.Deployment {
+	d := testDeployment(1)
+	d.Annotations = map[string]string{revisionAnnotation: "1"}
+	d.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "sample"}}
+	return d
+}
+
+func TestDiagnosePod(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name    string
+		pod     *apiv1.Pod
+		events  []*apiv1.Event
+		message string
+		err     string
+	}{
+		{
+			name: "image pull back-off",
+			pod:  testPod(waitingStatus("ImagePullBackOff", `Back-off pulling image "nginx:nope"`)),
+			err:  `pod sample-abc: container app is in ImagePullBackOff: Back-off pulling image "nginx:nope"`,
+		},
+		{
+			name:    "first image pull error",
+			pod:     testPod(waitingStatus("ErrImagePull", "rate limited")),
+			message: "pod sample-abc: container app is in ErrImagePull",
+		},
+		{
+			name: "crash loop",
+			pod: func() *apiv1.Pod {
+				pod := testPod(waitingStatus("CrashLoopBackOff", "back-off 40s restarting failed container"))
+				pod.Status.ContainerStatuses[0].LastTerminationState.Terminated = &apiv1.ContainerStateTerminated{ExitCode: 1, Reason: "Error"}
+				return pod
+			}(),
+			err: "pod sample-abc: container app is in CrashLoopBackOff: back-off 40s restarting failed container (last exit code 1, Error)",
+		},
+		{
+			name:    "creating containers",
+			pod:     testPod(waitingStatus("ContainerCreating", "")),
+			message: "",
+		},
+		{
+			name:    "unschedulable before cluster-autoscaler acts",
+			pod:     testPod(unschedulableStatus),
+			events:  []*apiv1.Event{podEvent("FailedScheduling", "0/3 nodes are available: 3 node(s) didn't match Pod's node affinity/selector.", now)},
+			message: "pod sample-abc is unschedulable: 0/3 nodes are available: 3 node(s) didn't match Pod's node affinity/selector.",
+		},
+		{
+			name: "waiting for scale-up",
+			pod:  testPod(unschedulableStatus),
+			events: []*apiv1.Event{
+				podEvent("TriggeredScaleUp", "pod triggered scale-up: [{spot 1->2 (max: 10)}]", now.Add(-time.Minute)),
+				podEvent("NotTriggerScaleUp", "pod didn't trigger scale-up: in backoff", now),
+			},
+			message: "pod sample-abc is unschedulable: 0/3 nodes are available",
+		},
+		{
+			name:   "no scale-up possible",
+			pod:    testPod(unschedulableStatus),
+			events: []*apiv1.Event{podEvent("NotTriggerScaleUp", "pod didn't trigger scale-up: 1 node(s) didn't match Pod's node affinity/selector", now)},
+			err:    "pod sample-abc is unschedulable (0/3 nodes are available) and cluster-autoscaler won't add a node: pod didn't trigger scale-up: 1 node(s) didn't match Pod's node affinity/selector",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var events []apiv1.Event
+			for _, e := range tc.events {
+				events = append(events, *e)
+			}
+			message, err := diagnosePod(*tc.pod, events)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.message, message)
+		})
+	}
+}
+
+func TestCreateDeploymentFailsFast(t *testing.T) {
+	clientset := fake.NewClientset(testReplicaSet("abc", "1"), testPod(waitingStatus("ImagePullBackOff", "not found")))
+	start := time.Now()
+	err := newTestK8s(clientset).CreateDeployment(context.Background(), selectedDeployment(), time.Hour)
+	require.EqualError(t, err, "waiting for Pods to be ready: pod sample-abc: container app is in ImagePullBackOff: not found")
+	require.Less(t, time.Since(start), time.Minute)
+}
+
+func TestCreateDeploymentTimeoutDiagnosis(t *testing.T) {
+	objects := []runtime.Object{
+		testReplicaSet("abc", "1"),
+		testPod(unschedulableStatus),
+		podEvent("TriggeredScaleUp", "pod triggered scale-up", time.Now()),
+	}
+	// Pods of other Deployments are not looked at.
+	other := testPod(waitingStatus("CrashLoopBackOff", ""))
+	other.Name = "other"
+	other.Labels = map[string]string{"app": "other"}
+	objects = append(objects, other)
+
+	d := selectedDeployment()
+	d.Spec.Replicas = util.Int32Ptr(2)
+	err := newTestK8s(fake.NewClientset(objects...)).CreateDeployment(context.Background(), d, 100*time.Millisecond)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorContains(t, err, "(pod sample-abc is unschedulable: 0/3 nodes are available)")
+}
+
+func TestCreateDeploymentIgnoresReplacedPods(t *testing.T) {
+	// An evicted pod next to the ready pod replacing it.
+	evicted := testPod(apiv1.PodStatus{Phase: apiv1.PodFailed, Reason: "Evicted", Message: "The node was low on resource: memory."})
+	evicted.Name = "sample-evicted"
+	// A crashing pod left by an earlier revision.
+	stale := testPod(waitingStatus("CrashLoopBackOff", ""))
+	stale.Name = "sample-old"
+	stale.Labels[appsv1.DefaultDeploymentUniqueLabelKey] = "old"
+	ready := testPod(apiv1.PodStatus{Phase: apiv1.PodRunning})
+	ready.Name = "sample-ready"
+
+	clientset := ktesting.NewReadyClientset(testReplicaSet("old", "0"), testReplicaSet("abc", "1"), evicted, stale, ready)
+	require.NoError(t, newTestK8s(clientset).CreateDeployment(context.Background(), selectedDeployment(), time.Minute))
+
+	message, err := newTestK8s(clientset).diagnosePods(context.Background(), "sample")
+	require.NoError(t, err)
+	require.Empty(t, message)
+}
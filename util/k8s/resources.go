@@ -2,6 +2,8 @@ package k8s
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -11,50 +13,86 @@ import (
 	networkingv1 "k8s.io/api/networking/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 )
 
+// FieldManager is the field manager kibertas applies resources with.
+const FieldManager = "kibertas"
+
+// DefaultNamespaceDeletionTimeout is how long DeleteNamespace waits for the namespace to be gone by default.
+const DefaultNamespaceDeletionTimeout = 5 * time.Minute
+
 type K8s struct {
+	// NamespaceDeletionTimeout is how long DeleteNamespace waits for the namespace to be gone.
+	NamespaceDeletionTimeout time.Duration
+
 	namespace string
-	clientset *kubernetes.Clientset
+	clientset kubernetes.Interface
 	logger    func() *logrus.Entry
 }
 
-func NewK8s(namespace string, clientset *kubernetes.Clientset, logger func() *logrus.Entry) *K8s {
+func NewK8s(namespace string, clientset kubernetes.Interface, logger func() *logrus.Entry) *K8s {
 	return &K8s{
-		namespace: namespace,
-		clientset: clientset,
-		logger:    logger,
+		NamespaceDeletionTimeout: DefaultNamespaceDeletionTimeout,
+		namespace:                namespace,
+		clientset:                clientset,
+		logger:                   logger,
 	}
 }
 
-// Createは本当はApplyにしたいんだけど、ApplyがないのでCreateで代用
+// CreateNamespace applies ns, so a namespace left over by a previous run is reused.
 func (k *K8s) CreateNamespace(ctx context.Context, ns *apiv1.Namespace) error {
-	_, err := k.clientset.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
-	k.logger().Infof("Creating Namespace: %s", ns.Name)
-	if err != nil && kerrors.IsAlreadyExists(err) {
-		k.logger().Warnf("Namespace %s already exists", ns.Name)
-		_, err = k.clientset.CoreV1().Namespaces().Update(context.TODO(), ns, metav1.UpdateOptions{})
-		if err != nil {
-			k.logger().Errorf("Error updating namespace %s", ns.Name)
-			return err
-		}
-		return nil
-	} else if err != nil {
-		k.logger().Info("Error creating namespace")
+	k.logger().Infof("Applying Namespace: %s", ns.Name)
+	data, err := applyPatch(ns, apiv1.SchemeGroupVersion.WithKind("Namespace"))
+	if err != nil {
 		return err
 	}
-	k.logger().Info("Namespace created")
+	_, err = k.clientset.CoreV1().Namespaces().Patch(ctx, ns.Name, types.ApplyPatchType, data, applyOptions())
+	if err != nil {
+		k.logger().Errorf("Error applying Namespace %s: %s", ns.Name, err)
+		return err
+	}
+	k.logger().Info("Namespace applied")
 	return nil
 }
 
-func (k *K8s) DeleteNamespace() error {
-	err := k.clientset.CoreV1().Namespaces().Delete(context.TODO(), k.namespace, metav1.DeleteOptions{})
+// NamespaceExists reports whether the namespace exists already.
+func (k *K8s) NamespaceExists(ctx context.Context) (bool, error) {
+	_, err := k.clientset.CoreV1().Namespaces().Get(ctx, k.namespace, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DeleteNamespace deletes the namespace and waits up to NamespaceDeletionTimeout for it to be gone,
+// or until ctx is done if that comes first. The namespace is deleted even if ctx is already done.
+// If it is still terminating by then, the error tells what blocks it.
+func (k *K8s) DeleteNamespace(ctx context.Context) error {
+	namespaces := k.clientset.CoreV1().Namespaces()
+	err := namespaces.Delete(context.WithoutCancel(ctx), k.namespace, metav1.DeleteOptions{})
 	if err != nil {
 		k.logger().Errorf("Error Delete Namespace: %s", k.namespace)
 		return err
 	}
+
+	timeout := k.NamespaceDeletionTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = max(min(timeout, time.Until(deadline).Round(time.Millisecond)), 0)
+	}
+	k.logger().Infof("Waiting up to %s for Namespace %s to be deleted", timeout, k.namespace)
+	if err := WaitForDeletion(ctx, namespaces, k.namespace, timeout, k.logger); err != nil {
+		// What blocks the namespace is looked for even when ctx is done.
+		diagnosisCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		err = fmt.Errorf("namespace %s is still terminating after %s: %w", k.namespace, timeout, k.terminationBlockers(diagnosisCtx, err))
+		k.logger().Error(err)
+		return err
+	}
 	k.logger().Infof("Deleted Namespace: %s", k.namespace)
 	return nil
 }
@@ -62,36 +100,50 @@ func (k *K8s) DeleteNamespace() error {
 func (k *K8s) CreateDeployment(ctx context.Context, deployment *appsv1.Deployment, timeout time.Duration) error {
 	deploymentsClient := k.clientset.AppsV1().Deployments(k.namespace)
 
-	// Create Deployment
-	k.logger().Infof("Creating Deployment: %s", deployment.Name)
-	result, err := deploymentsClient.Create(ctx, deployment, metav1.CreateOptions{})
-	if err != nil && kerrors.IsAlreadyExists(err) {
-		k.logger().Warnf("Already exists, updating deployment: %s", deployment.Name)
-		_, err = deploymentsClient.Update(ctx, deployment, metav1.UpdateOptions{})
-		if err != nil {
-			k.logger().Errorf("Error Updating Deployment: %s", err)
-			return err
-		}
-	} else if err != nil {
-		k.logger().Infof("Error Creating Deployment: %s", deployment.Name)
+	k.logger().Infof("Applying Deployment: %s", deployment.Name)
+	data, err := applyPatch(deployment, appsv1.SchemeGroupVersion.WithKind("Deployment"))
+	if err != nil {
+		return err
+	}
+	result, err := deploymentsClient.Patch(ctx, deployment.Name, types.ApplyPatchType, data, applyOptions())
+	if err != nil {
+		k.logger().Errorf("Error applying Deployment: %s", err)
+		return err
 	}
+	k.logger().Infof("Applied Deployment %s", result.GetObjectMeta().GetName())
 
-	k.logger().Infof("Created Deployment %s", result.GetObjectMeta().GetName())
+	// The pods are checked besides, since they can get stuck without the Deployment changing.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	diagnosis := &podDiagnosis{}
+	go k.checkPods(ctx, cancel, deployment.Name, diagnosis)
 
-	err = wait.PollUntilContextTimeout(ctx, 5*time.Second, timeout, false, func(ctx context.Context) (bool, error) {
-		deployment, err := deploymentsClient.Get(ctx, deployment.Name, metav1.GetOptions{})
+	// ComputeStatus also waits for the rollout of a changed Deployment, not just for as many ready pods.
+	err = WaitFor(ctx, deploymentsClient, deployment.Name, timeout, k.logger, func(deployment *appsv1.Deployment) (bool, error) {
+		data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(deployment)
 		if err != nil {
 			return false, err
 		}
-		if deployment.Status.ReadyReplicas == *deployment.Spec.Replicas {
+		obj := &unstructured.Unstructured{Object: data}
+		obj.SetGroupVersionKind(appsv1.SchemeGroupVersion.WithKind("Deployment"))
+		status, message := ComputeStatus(obj)
+		switch status {
+		case StatusCurrent:
 			return true, nil
-		} else {
-			k.logger().Infof("Waiting for Pods to be ready, current: %d, desired: %d", deployment.Status.ReadyReplicas, *deployment.Spec.Replicas)
-			return false, nil
+		case StatusFailed:
+			return false, errors.New(message)
 		}
+		k.logger().Infof("Waiting for Pods to be ready: %s", message)
+		return false, nil
 	})
 
+	if cause := context.Cause(ctx); err != nil && cause != nil && !errors.Is(cause, context.Canceled) {
+		return fmt.Errorf("waiting for Pods to be ready: %w", cause)
+	}
 	if err != nil {
+		if message := diagnosis.get(); message != "" {
+			return fmt.Errorf("waiting for Pods to be ready: %w (%s)", err, message)
+		}
 		return fmt.Errorf("waiting for Pods to be ready: %w", err)
 	}
 
@@ -117,20 +169,17 @@ func (k *K8s) DeleteDeployment(deploymentName string) error {
 func (k *K8s) CreateService(ctx context.Context, service *apiv1.Service) error {
 	serviceClient := k.clientset.CoreV1().Services(k.namespace)
 
-	k.logger().Infof("Create Service: %s", service.Name)
-	_, err := serviceClient.Create(ctx, service, metav1.CreateOptions{})
-	if err != nil && kerrors.IsAlreadyExists(err) {
-		k.logger().Warnf("Already exists, updating Service: %s", service.Name)
-		_, err = serviceClient.Update(ctx, service, metav1.UpdateOptions{})
-		if err != nil {
-			k.logger().Errorf("Error updating Service: %s", err)
-			return err
-		}
-	} else if err != nil {
-		k.logger().Errorf("Error creating Service: %s", err)
+	k.logger().Infof("Applying Service: %s", service.Name)
+	data, err := applyPatch(service, apiv1.SchemeGroupVersion.WithKind("Service"))
+	if err != nil {
 		return err
 	}
-	k.logger().Info("Created Service.")
+	_, err = serviceClient.Patch(ctx, service.Name, types.ApplyPatchType, data, applyOptions())
+	if err != nil {
+		k.logger().Errorf("Error applying Service: %s", err)
+		return err
+	}
+	k.logger().Info("Applied Service.")
 	return nil
 }
 
@@ -152,27 +201,19 @@ func (k *K8s) DeleteService(serviceName string) error {
 func (k *K8s) CreateIngress(ctx context.Context, ingress *networkingv1.Ingress, timeout time.Duration) error {
 	ingressClient := k.clientset.NetworkingV1().Ingresses(k.namespace)
 
-	k.logger().Infof("Creating Ingress: %s", ingress.Name)
-	_, err := ingressClient.Create(ctx, ingress, metav1.CreateOptions{})
-	if err != nil && kerrors.IsAlreadyExists(err) {
-		k.logger().Warnf("Already exists, updating Ingress: %s", ingress.Name)
-		_, err = ingressClient.Update(ctx, ingress, metav1.UpdateOptions{})
-		if err != nil {
-			k.logger().Errorf("Error updating Ingress: %s", err)
-			return err
-		}
-	} else if err != nil {
-		k.logger().Errorf("Error creating Ingress: %s", err)
+	k.logger().Infof("Applying Ingress: %s", ingress.Name)
+	data, err := applyPatch(ingress, networkingv1.SchemeGroupVersion.WithKind("Ingress"))
+	if err != nil {
+		return err
+	}
+	_, err = ingressClient.Patch(ctx, ingress.Name, types.ApplyPatchType, data, applyOptions())
+	if err != nil {
+		k.logger().Errorf("Error applying Ingress: %s", err)
 		return err
 	}
 
 	if *ingress.Spec.IngressClassName == "alb" {
-		err = wait.PollUntilContextTimeout(ctx, 5*time.Second, timeout, false, func(ctx context.Context) (bool, error) {
-			ingress, err := ingressClient.Get(ctx, ingress.Name, metav1.GetOptions{})
-			if err != nil {
-				return false, err
-			}
-
+		err = WaitFor(ctx, ingressClient, ingress.Name, timeout, k.logger, func(ingress *networkingv1.Ingress) (bool, error) {
 			for _, address := range ingress.Status.LoadBalancer.Ingress {
 				if address.Hostname != "" {
 					k.logger().Infof("Ingress is now available at Hostname: %s", address.Hostname)
@@ -204,3 +245,18 @@ func (k *K8s) DeleteIngress(ingressName string) error {
 	k.logger().Infof("Deleted Ingress: %s", ingressName)
 	return nil
 }
+
+// applyPatch returns obj as a server-side apply patch.
+// Typed objects have no apiVersion and kind, so they are set from gvk.
+func applyPatch(obj runtime.Object, gvk schema.GroupVersionKind) ([]byte, error) {
+	obj = obj.DeepCopyObject()
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	return json.Marshal(obj)
+}
+
+// applyOptions takes over the fields other managers set, so that re-runs converge
+// even when someone edited the resources in between.
+func applyOptions() metav1.PatchOptions {
+	force := true
+	return metav1.PatchOptions{FieldManager: FieldManager, Force: &force}
+}
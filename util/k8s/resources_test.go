@@ -0,0 +1,172 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/chatwork/kibertas/internal/ktesting"
+	"github.com/chatwork/kibertas/util"
+)
+
+func newTestK8s(clientset kubernetes.Interface) *K8s {
+	return NewK8s("test", clientset, func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+}
+
+func testDeployment(replicas int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "sample"},
+		Spec:       appsv1.DeploymentSpec{Replicas: util.Int32Ptr(replicas)},
+	}
+}
+
+func TestCreateDeployment(t *testing.T) {
+	ctx := context.Background()
+	clientset := ktesting.NewReadyClientset()
+	k := newTestK8s(clientset)
+
+	require.NoError(t, k.CreateDeployment(ctx, testDeployment(1), time.Minute))
+	// Applying it again updates it.
+	require.NoError(t, k.CreateDeployment(ctx, testDeployment(3), time.Minute))
+
+	d, err := clientset.AppsV1().Deployments("test").Get(ctx, "sample", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, int32(3), *d.Spec.Replicas)
+	require.Len(t, d.ManagedFields, 1)
+	require.Equal(t, FieldManager, d.ManagedFields[0].Manager)
+}
+
+func TestCreateDeploymentTimeout(t *testing.T) {
+	k := newTestK8s(fake.NewClientset())
+	err := k.CreateDeployment(context.Background(), testDeployment(1), 100*time.Millisecond)
+	require.ErrorContains(t, err, "waiting for Pods to be ready")
+}
+
+func TestCreateDeploymentWaitsForRollout(t *testing.T) {
+	// The old pods of a changed Deployment are all ready, but none is updated yet.
+	clientset := fake.NewClientset()
+	clientset.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj, err := clientset.Tracker().Get(action.GetResource(), action.GetNamespace(), "sample")
+		if err != nil {
+			return true, nil, err
+		}
+		d := obj.DeepCopyObject().(*appsv1.Deployment)
+		d.Status = appsv1.DeploymentStatus{Replicas: 2, UpdatedReplicas: 0, ReadyReplicas: 2, AvailableReplicas: 2}
+		return true, d, nil
+	})
+	err := newTestK8s(clientset).CreateDeployment(context.Background(), testDeployment(2), 100*time.Millisecond)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// A Deployment past its progress deadline fails without waiting for the timeout.
+	clientset = fake.NewClientset()
+	clientset.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj, err := clientset.Tracker().Get(action.GetResource(), action.GetNamespace(), "sample")
+		if err != nil {
+			return true, nil, err
+		}
+		d := obj.DeepCopyObject().(*appsv1.Deployment)
+		d.Status.Conditions = []appsv1.DeploymentCondition{{
+			Type: appsv1.DeploymentProgressing, Status: apiv1.ConditionFalse, Reason: "ProgressDeadlineExceeded", Message: "timed out",
+		}}
+		return true, d, nil
+	})
+	err = newTestK8s(clientset).CreateDeployment(context.Background(), testDeployment(1), time.Hour)
+	require.EqualError(t, err, "waiting for Pods to be ready: progress deadline exceeded: timed out")
+}
+
+func TestCreateIngress(t *testing.T) {
+	alb := "alb"
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "sample"},
+		Spec:       networkingv1.IngressSpec{IngressClassName: &alb},
+	}
+
+	require.NoError(t, newTestK8s(ktesting.NewReadyClientset()).CreateIngress(context.Background(), ingress.DeepCopy(), time.Minute))
+
+	err := newTestK8s(fake.NewClientset()).CreateIngress(context.Background(), ingress.DeepCopy(), 100*time.Millisecond)
+	require.ErrorContains(t, err, "waiting for Ingress to be ready")
+
+	// Only ALBs are waited for.
+	nginx := "nginx"
+	ingress.Spec.IngressClassName = &nginx
+	require.NoError(t, newTestK8s(fake.NewClientset()).CreateIngress(context.Background(), ingress, 100*time.Millisecond))
+}
+
+func TestNamespace(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewClientset()
+	k := newTestK8s(clientset)
+	ns := &apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+
+	require.NoError(t, k.CreateNamespace(ctx, ns.DeepCopy()))
+	// A namespace left over by a previous run is reused.
+	require.NoError(t, k.CreateNamespace(ctx, ns.DeepCopy()))
+	require.NoError(t, k.DeleteNamespace(ctx))
+	require.True(t, ktesting.Deleted(clientset, "namespaces", "test"))
+	require.Error(t, k.DeleteNamespace(ctx))
+}
+
+func stuckNamespaceClientset() *fake.Clientset {
+	ns := &apiv1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Status: apiv1.NamespaceStatus{
+			Phase: apiv1.NamespaceTerminating,
+			Conditions: []apiv1.NamespaceCondition{
+				{Type: apiv1.NamespaceDeletionDiscoveryFailure, Status: apiv1.ConditionFalse, Message: "All resources successfully discovered"},
+				{Type: apiv1.NamespaceFinalizersRemaining, Status: apiv1.ConditionTrue, Message: "Some content in the namespace has finalizers remaining: ingress.k8s.aws/resources in 1 resource instances"},
+			},
+		},
+	}
+	ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{
+		Name:       "sample",
+		Namespace:  "test",
+		Finalizers: []string{"ingress.k8s.aws/resources"},
+	}}
+	clientset := fake.NewClientset(ns, ingress)
+	// The namespace controller never gets to remove the namespace.
+	clientset.PrependReactor("delete", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+	return clientset
+}
+
+func TestDeleteNamespaceStuck(t *testing.T) {
+	k := newTestK8s(stuckNamespaceClientset())
+	k.NamespaceDeletionTimeout = 100 * time.Millisecond
+
+	err := k.DeleteNamespace(context.Background())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorContains(t, err, "namespace test is still terminating after 100ms: NamespaceFinalizersRemaining: Some content in the namespace has finalizers remaining")
+	require.ErrorContains(t, err, "remaining objects: ingresses.networking.k8s.io/sample (finalizers: ingress.k8s.aws/resources)")
+	require.NotContains(t, err.Error(), "discovered")
+}
+
+func TestDeleteNamespaceDeadline(t *testing.T) {
+	clientset := stuckNamespaceClientset()
+	k := newTestK8s(clientset)
+
+	// The wait ends with ctx, well before NamespaceDeletionTimeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := k.DeleteNamespace(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Minute)
+	require.ErrorContains(t, err, "remaining objects: ingresses.networking.k8s.io/sample")
+
+	// The namespace is deleted even once the deadline has passed.
+	clientset.ClearActions()
+	require.Error(t, k.DeleteNamespace(ctx))
+	require.True(t, ktesting.Deleted(clientset, "namespaces", "test"))
+}
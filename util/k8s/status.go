@@ -0,0 +1,203 @@
+package k8s
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Status tells whether an object reached the state its spec asks for.
+// The rules follow kstatus (sigs.k8s.io/cli-utils/pkg/kstatus) for the built-in
+// kinds, and the Ready, Reconciling and Stalled conditions for the others.
+type Status string
+
+const (
+	// StatusCurrent means the object is ready.
+	StatusCurrent Status = "Current"
+	// StatusInProgress means the object is on its way to being ready.
+	StatusInProgress Status = "InProgress"
+	// StatusFailed means the object will not become ready without a change.
+	StatusFailed Status = "Failed"
+)
+
+// ComputeStatus returns the status of obj and a message saying why, for logs and errors.
+func ComputeStatus(obj *unstructured.Unstructured) (Status, string) {
+	if obj.GetDeletionTimestamp() != nil {
+		return StatusInProgress, "being deleted"
+	}
+	generation := int64Field(obj, 0, "metadata", "generation")
+	if observed := int64Field(obj, -1, "status", "observedGeneration"); observed >= 0 && observed < generation {
+		return StatusInProgress, fmt.Sprintf("generation %d not observed yet, at %d", generation, observed)
+	}
+
+	switch obj.GroupVersionKind().GroupKind().String() {
+	case "Deployment.apps":
+		return deploymentStatus(obj)
+	case "StatefulSet.apps", "ReplicaSet.apps":
+		return replicasStatus(obj)
+	case "DaemonSet.apps":
+		return daemonSetStatus(obj)
+	case "Pod":
+		return podStatus(obj)
+	case "Job.batch":
+		return jobStatus(obj)
+	case "PersistentVolumeClaim":
+		return phaseStatus(obj, "Bound")
+	case "Namespace":
+		return phaseStatus(obj, "Active")
+	case "Service":
+		return serviceStatus(obj)
+	case "CustomResourceDefinition.apiextensions.k8s.io":
+		if c, ok := condition(obj, "Established"); ok && c.status == "True" {
+			return StatusCurrent, "established"
+		}
+		return StatusInProgress, "not established yet"
+	}
+	return conditionsStatus(obj)
+}
+
+type objectCondition struct {
+	status, reason, message string
+}
+
+// condition returns the condition of type conditionType of obj, if it has one.
+func condition(obj *unstructured.Unstructured, conditionType string) (objectCondition, bool) {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		m, ok := c.(map[string]interface{})
+		if !ok || m["type"] != conditionType {
+			continue
+		}
+		var oc objectCondition
+		oc.status, _ = m["status"].(string)
+		oc.reason, _ = m["reason"].(string)
+		oc.message, _ = m["message"].(string)
+		return oc, true
+	}
+	return objectCondition{}, false
+}
+
+func conditionsStatus(obj *unstructured.Unstructured) (Status, string) {
+	if c, ok := condition(obj, "Stalled"); ok && c.status == "True" {
+		return StatusFailed, fmt.Sprintf("stalled: %s %s", c.reason, c.message)
+	}
+	if c, ok := condition(obj, "Reconciling"); ok && c.status == "True" {
+		return StatusInProgress, fmt.Sprintf("reconciling: %s %s", c.reason, c.message)
+	}
+	if c, ok := condition(obj, "Ready"); ok && c.status != "True" {
+		return StatusInProgress, fmt.Sprintf("not ready: %s %s", c.reason, c.message)
+	}
+	return StatusCurrent, "ready"
+}
+
+// int64Field returns the integer at fields of obj, or def if it is not set.
+// Objects decoded from YAML have float64 numbers, those from the API server int64.
+func int64Field(obj *unstructured.Unstructured, def int64, fields ...string) int64 {
+	v, _, _ := unstructured.NestedFieldNoCopy(obj.Object, fields...)
+	switch n := v.(type) {
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	return def
+}
+
+func deploymentStatus(obj *unstructured.Unstructured) (Status, string) {
+	if c, ok := condition(obj, "Progressing"); ok && c.reason == "ProgressDeadlineExceeded" {
+		return StatusFailed, fmt.Sprintf("progress deadline exceeded: %s", c.message)
+	}
+	desired := int64Field(obj, 1, "spec", "replicas")
+	replicas := int64Field(obj, 0, "status", "replicas")
+	updated := int64Field(obj, 0, "status", "updatedReplicas")
+	ready := int64Field(obj, 0, "status", "readyReplicas")
+	available := int64Field(obj, 0, "status", "availableReplicas")
+	switch {
+	case updated < desired:
+		return StatusInProgress, fmt.Sprintf("%d of %d replicas updated", updated, desired)
+	case replicas > updated:
+		return StatusInProgress, fmt.Sprintf("%d old replicas pending termination", replicas-updated)
+	case ready < desired:
+		return StatusInProgress, fmt.Sprintf("%d of %d replicas ready", ready, desired)
+	case available < desired:
+		return StatusInProgress, fmt.Sprintf("%d of %d replicas available", available, desired)
+	}
+	return StatusCurrent, fmt.Sprintf("%d replicas ready", ready)
+}
+
+func replicasStatus(obj *unstructured.Unstructured) (Status, string) {
+	desired := int64Field(obj, 1, "spec", "replicas")
+	ready := int64Field(obj, 0, "status", "readyReplicas")
+	if ready < desired {
+		return StatusInProgress, fmt.Sprintf("%d of %d replicas ready", ready, desired)
+	}
+	if obj.GetKind() == "StatefulSet" {
+		current, _, _ := unstructured.NestedString(obj.Object, "status", "currentRevision")
+		update, _, _ := unstructured.NestedString(obj.Object, "status", "updateRevision")
+		if update != "" && current != update {
+			return StatusInProgress, fmt.Sprintf("rolling out revision %s", update)
+		}
+	}
+	return StatusCurrent, fmt.Sprintf("%d replicas ready", ready)
+}
+
+func daemonSetStatus(obj *unstructured.Unstructured) (Status, string) {
+	desired := int64Field(obj, 0, "status", "desiredNumberScheduled")
+	updated := int64Field(obj, 0, "status", "updatedNumberScheduled")
+	ready := int64Field(obj, 0, "status", "numberReady")
+	available := int64Field(obj, 0, "status", "numberAvailable")
+	switch {
+	case updated < desired:
+		return StatusInProgress, fmt.Sprintf("%d of %d pods updated", updated, desired)
+	case ready < desired:
+		return StatusInProgress, fmt.Sprintf("%d of %d pods ready", ready, desired)
+	case available < desired:
+		return StatusInProgress, fmt.Sprintf("%d of %d pods available", available, desired)
+	}
+	return StatusCurrent, fmt.Sprintf("%d pods ready", ready)
+}
+
+func podStatus(obj *unstructured.Unstructured) (Status, string) {
+	phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+	switch phase {
+	case "Succeeded":
+		return StatusCurrent, "succeeded"
+	case "Failed":
+		return StatusFailed, "failed"
+	case "Running":
+		if c, ok := condition(obj, "Ready"); ok && c.status == "True" {
+			return StatusCurrent, "running"
+		}
+	}
+	return StatusInProgress, fmt.Sprintf("phase %q, not ready", phase)
+}
+
+func jobStatus(obj *unstructured.Unstructured) (Status, string) {
+	if c, ok := condition(obj, "Failed"); ok && c.status == "True" {
+		return StatusFailed, fmt.Sprintf("failed: %s %s", c.reason, c.message)
+	}
+	if c, ok := condition(obj, "Complete"); ok && c.status == "True" {
+		return StatusCurrent, "complete"
+	}
+	return StatusInProgress, "not complete yet"
+}
+
+func phaseStatus(obj *unstructured.Unstructured, want string) (Status, string) {
+	phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+	if phase == want {
+		return StatusCurrent, phase
+	}
+	return StatusInProgress, fmt.Sprintf("phase %q, want %q", phase, want)
+}
+
+func serviceStatus(obj *unstructured.Unstructured) (Status, string) {
+	serviceType, _, _ := unstructured.NestedString(obj.Object, "spec", "type")
+	if serviceType != "LoadBalancer" {
+		return StatusCurrent, "ready"
+	}
+	ingress, _, _ := unstructured.NestedSlice(obj.Object, "status", "loadBalancer", "ingress")
+	if len(ingress) == 0 {
+		return StatusInProgress, "no load balancer address yet"
+	}
+	return StatusCurrent, "load balancer ready"
+}
@@ -0,0 +1,150 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// PollInterval is how often WaitFor gets the object while it cannot watch it.
+var PollInterval = 5 * time.Second
+
+var errWatchClosed = errors.New("watch closed")
+
+// WatchGetter is the part of a typed client WaitFor needs,
+// e.g. clientset.AppsV1().Deployments(namespace).
+type WatchGetter[T runtime.Object] interface {
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (T, error)
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+}
+
+// WaitFor waits up to timeout for the object called name to satisfy cond.
+// It watches the object so that it returns as soon as cond holds. When the watch
+// cannot be started or breaks, it gets the object every PollInterval until a new
+// watch succeeds. An object that does not exist yet is waited for.
+func WaitFor[T runtime.Object](ctx context.Context, client WatchGetter[T], name string, timeout time.Duration, logger func() *logrus.Entry, cond func(T) (bool, error)) error {
+	return waitUntil(ctx, client, name, timeout, logger, func(obj T, exists bool) (bool, error) {
+		if !exists {
+			return false, nil
+		}
+		return cond(obj)
+	})
+}
+
+// WaitForDeletion waits up to timeout for the object called name to be gone,
+// watching it like WaitFor.
+func WaitForDeletion[T runtime.Object](ctx context.Context, client WatchGetter[T], name string, timeout time.Duration, logger func() *logrus.Entry) error {
+	return waitUntil(ctx, client, name, timeout, logger, func(_ T, exists bool) (bool, error) {
+		return !exists, nil
+	})
+}
+
+// waitUntil implements WaitFor and WaitForDeletion. cond is called with exists false
+// when the object is not found or deleted.
+func waitUntil[T runtime.Object](ctx context.Context, client WatchGetter[T], name string, timeout time.Duration, logger func() *logrus.Entry, cond func(obj T, exists bool) (bool, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var lastErr error
+	for {
+		resourceVersion := ""
+		obj, err := client.Get(ctx, name, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			if done, err := cond(obj, true); done || err != nil {
+				return err
+			}
+			if m, err := meta.Accessor(obj); err == nil {
+				resourceVersion = m.GetResourceVersion()
+			}
+		case kerrors.IsNotFound(err):
+			var zero T
+			if done, err := cond(zero, false); done || err != nil {
+				return err
+			}
+		case ctx.Err() != nil:
+			return timeoutError(ctx, lastErr)
+		default:
+			logger().WithError(err).Warnf("Error getting %s, retrying", name)
+			lastErr = err
+		}
+
+		started := time.Now()
+		done, err := watchFor(ctx, client, name, resourceVersion, cond)
+		if done {
+			return err
+		}
+		if ctx.Err() != nil {
+			return timeoutError(ctx, lastErr)
+		}
+		if errors.Is(err, errWatchClosed) && time.Since(started) > PollInterval {
+			// The API server ends watches from time to time, just start a new one.
+			continue
+		}
+		logger().WithError(err).Warnf("Error watching %s, polling every %s", name, PollInterval)
+		lastErr = err
+
+		select {
+		case <-ctx.Done():
+			return timeoutError(ctx, lastErr)
+		case <-time.After(PollInterval):
+		}
+	}
+}
+
+// watchFor watches the object called name from resourceVersion until cond holds,
+// cond fails, the watch breaks or ctx is done.
+func watchFor[T runtime.Object](ctx context.Context, client WatchGetter[T], name, resourceVersion string, cond func(T, bool) (bool, error)) (bool, error) {
+	w, err := client.Watch(ctx, metav1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+		ResourceVersion: resourceVersion,
+	})
+	if err != nil {
+		return false, err
+	}
+	defer w.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return false, errWatchClosed
+			}
+			switch event.Type {
+			case watch.Error:
+				return false, kerrors.FromObject(event.Object)
+			case watch.Added, watch.Modified, watch.Deleted:
+				obj, ok := event.Object.(T)
+				if !ok {
+					continue
+				}
+				// Fake and some proxied watches ignore the field selector.
+				if m, err := meta.Accessor(obj); err != nil || m.GetName() != name {
+					continue
+				}
+				if done, err := cond(obj, event.Type != watch.Deleted); done || err != nil {
+					return true, err
+				}
+			}
+		}
+	}
+}
+
+// timeoutError returns the error of ctx, with the last error that made WaitFor retry if any.
+func timeoutError(ctx context.Context, lastErr error) error {
+	if lastErr != nil {
+		return fmt.Errorf("%w (last error: %s)", ctx.Err(), lastErr)
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,131 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func setPollInterval(t *testing.T, d time.Duration) {
+	old := PollInterval
+	PollInterval = d
+	t.Cleanup(func() { PollInterval = old })
+}
+
+func testLogger() *logrus.Entry {
+	return logrus.NewEntry(logrus.New())
+}
+
+func hasData(secret *apiv1.Secret) (bool, error) {
+	return len(secret.Data) > 0, nil
+}
+
+// watching tells whether the clientset got a watch request.
+func watching(clientset *fake.Clientset) func() bool {
+	return func() bool {
+		for _, action := range clientset.Actions() {
+			if action.GetVerb() == "watch" {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	// Polling would not notice the Secret in time.
+	setPollInterval(t, time.Hour)
+	ctx := context.Background()
+	clientset := fake.NewClientset(&apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "sample", Namespace: "test"}})
+	secrets := clientset.CoreV1().Secrets("test")
+
+	done := make(chan error)
+	go func() {
+		done <- WaitFor(ctx, secrets, "sample", time.Minute, testLogger, hasData)
+	}()
+	require.Eventually(t, watching(clientset), time.Second, 10*time.Millisecond)
+
+	// Other objects are ignored.
+	_, err := secrets.Create(ctx, &apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "other"}, Data: map[string][]byte{"a": nil}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = secrets.Update(ctx, &apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "sample"}, Data: map[string][]byte{"a": nil}}, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitFor did not notice the update")
+	}
+}
+
+func TestWaitForPollsAfterWatchError(t *testing.T) {
+	setPollInterval(t, 10*time.Millisecond)
+	ctx := context.Background()
+	clientset := fake.NewClientset()
+	clientset.PrependWatchReactor("secrets", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		return true, nil, errors.New("watch unavailable")
+	})
+	secrets := clientset.CoreV1().Secrets("test")
+
+	done := make(chan error)
+	go func() {
+		done <- WaitFor(ctx, secrets, "sample", time.Minute, testLogger, hasData)
+	}()
+	require.Eventually(t, watching(clientset), time.Second, 10*time.Millisecond)
+
+	_, err := secrets.Create(ctx, &apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "sample"}, Data: map[string][]byte{"a": nil}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitFor did not poll")
+	}
+}
+
+func TestWaitForTimeout(t *testing.T) {
+	setPollInterval(t, 10*time.Millisecond)
+	clientset := fake.NewClientset()
+	clientset.PrependWatchReactor("secrets", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		return true, nil, errors.New("watch unavailable")
+	})
+
+	err := WaitFor(context.Background(), clientset.CoreV1().Secrets("test"), "sample", 100*time.Millisecond, testLogger, hasData)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorContains(t, err, "watch unavailable")
+}
+
+func TestWaitForDeletion(t *testing.T) {
+	setPollInterval(t, time.Hour)
+	ctx := context.Background()
+	clientset := fake.NewClientset(&apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "sample", Namespace: "test"}})
+	secrets := clientset.CoreV1().Secrets("test")
+
+	done := make(chan error)
+	go func() {
+		done <- WaitForDeletion(ctx, secrets, "sample", time.Minute, testLogger)
+	}()
+	require.Eventually(t, watching(clientset), time.Second, 10*time.Millisecond)
+	require.NoError(t, secrets.Delete(ctx, "sample", metav1.DeleteOptions{}))
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitForDeletion did not notice the deletion")
+	}
+
+	// Objects already gone are not waited for.
+	require.NoError(t, WaitForDeletion(ctx, secrets, "sample", time.Minute, testLogger))
+}
@@ -0,0 +1,156 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+// Severities of alerts, as in PagerDuty.
+const (
+	SeverityCritical = "critical"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// Severity maps checks to the severity of the alerts of their failures.
+type Severity struct {
+	// Checks has the severity of particular checks.
+	Checks map[string]string
+	// Default is used for the other checks. SeverityError if empty.
+	Default string
+}
+
+func (s Severity) of(check string) string {
+	if v, ok := s.Checks[check]; ok {
+		return v
+	}
+	if s.Default != "" {
+		return s.Default
+	}
+	return SeverityError
+}
+
+// dedupKey identifies the alert of a check across runs, so that a failure
+// already alerted on isn't alerted again, and is resolved once the check passes.
+func dedupKey(run *report.Run, check *report.CheckResult) string {
+	return fmt.Sprintf("kibertas:%s:%s", run.ClusterName, check.Name)
+}
+
+// alertDetails is what alerts carry to triage the failure without the report at hand.
+type alertDetails struct {
+	Cluster   string   `json:"cluster"`
+	Check     string   `json:"check"`
+	Namespace string   `json:"namespace,omitempty"`
+	RunID     string   `json:"run_id"`
+	Step      string   `json:"step,omitempty"`
+	Error     string   `json:"error"`
+	Reason    string   `json:"reason"`
+	Report    string   `json:"report,omitempty"`
+	Collected []string `json:"diagnostics,omitempty"`
+}
+
+func newAlertDetails(run *report.Run, check *report.CheckResult, reportURL string) alertDetails {
+	d := alertDetails{
+		Cluster:   run.ClusterName,
+		Check:     check.Name,
+		Namespace: check.Namespace,
+		RunID:     run.ID,
+		Error:     check.Error,
+		Reason:    report.FailureReason(check.Error),
+	}
+	if step := check.FailedStep(); step != nil {
+		d.Step = step.Name
+	}
+	if reportURL != "" {
+		d.Report = fmt.Sprintf("%s#check-%s", reportURL, check.Name)
+	}
+	for _, a := range check.Artifacts {
+		name := fmt.Sprintf("%s %s", a.Kind, a.Name)
+		if a.URL != "" {
+			name += ": " + a.URL
+		}
+		d.Collected = append(d.Collected, name)
+	}
+	return d
+}
+
+// soakResults returns a result per check of the soak test run is the last run of,
+// failed with its most frequent failure if it failed in any run, passed otherwise.
+// It returns nil for runs other than soak tests.
+func soakResults(run *report.Run) []*report.CheckResult {
+	if run.Soak == nil {
+		return nil
+	}
+	var results []*report.CheckResult
+	byName := map[string]*report.CheckResult{}
+	add := func(name, namespace string) *report.CheckResult {
+		if r, ok := byName[name]; ok {
+			return r
+		}
+		r := &report.CheckResult{Name: name, Namespace: namespace, Status: report.StatusPassed, FinishedAt: run.FinishedAt}
+		byName[name] = r
+		results = append(results, r)
+		return r
+	}
+	for _, c := range run.Checks {
+		add(c.Name, c.Namespace)
+	}
+	// Failures are sorted most frequent first.
+	for _, f := range run.Soak.Failures {
+		r := add(f.Check, "")
+		if r.Status == report.StatusFailed {
+			continue
+		}
+		r.Status = report.StatusFailed
+		r.Error = f.Reason
+		r.Steps = []*report.Step{{Name: f.Step, Status: report.StatusFailed, Error: f.Reason}}
+	}
+	return results
+}
+
+func (d alertDetails) summary() string {
+	s := fmt.Sprintf("kibertas %s check failed in %s", d.Check, d.Cluster)
+	if d.Step != "" {
+		s += " at step " + d.Step
+	}
+	return s + ": " + d.Reason
+}
+
+// postJSON posts v to url and fails unless the response is a 2xx.
+func postJSON(client *http.Client, url string, header http.Header, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, respBody)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+type recordedRequest struct {
+	Path          string
+	Authorization string
+	Body          map[string]interface{}
+}
+
+func newRecorder(t *testing.T) (*httptest.Server, *[]recordedRequest) {
+	var requests []recordedRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := recordedRequest{Path: r.URL.RequestURI(), Authorization: r.Header.Get("Authorization")}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req.Body))
+		requests = append(requests, req)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return ts, &requests
+}
+
+func TestPagerDuty(t *testing.T) {
+	ts, requests := newRecorder(t)
+	defer ts.Close()
+
+	p := NewPagerDuty("routing-key", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	p.URL = ts.URL
+	p.Severity = Severity{Checks: map[string]string{"fluent": SeverityWarning}}
+	p.ReportURL = "https://reports.example.com/run.html"
+
+	run := newSlackTestRun()
+	for _, c := range run.Checks {
+		p.Result(run, c)
+	}
+	skipped := run.StartCheck("cert-manager", "")
+	skipped.Skip("not installed")
+	p.Result(run, skipped)
+
+	require.Len(t, *requests, 2)
+
+	resolve := (*requests)[0].Body
+	require.Equal(t, "resolve", resolve["event_action"])
+	require.Equal(t, "kibertas:test-cluster:ingress", resolve["dedup_key"])
+	require.Nil(t, resolve["payload"])
+
+	trigger := (*requests)[1].Body
+	require.Equal(t, "trigger", trigger["event_action"])
+	require.Equal(t, "routing-key", trigger["routing_key"])
+	require.Equal(t, "kibertas:test-cluster:fluent", trigger["dedup_key"])
+	payload := trigger["payload"].(map[string]interface{})
+	require.Equal(t, "kibertas fluent check failed in test-cluster at step check s3 object: no logs in s3", payload["summary"])
+	require.Equal(t, "warning", payload["severity"])
+	details := payload["custom_details"].(map[string]interface{})
+	require.Equal(t, "check s3 object", details["step"])
+	require.Equal(t, []interface{}{"events events in fluent-test"}, details["diagnostics"])
+	require.Equal(t, "https://reports.example.com/run.html#check-fluent", details["report"])
+}
+
+func TestOpsgenie(t *testing.T) {
+	ts, requests := newRecorder(t)
+	defer ts.Close()
+
+	o := NewOpsgenie("api-key", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	o.APIURL = ts.URL
+	o.Severity = Severity{Default: SeverityCritical}
+
+	run := newSlackTestRun()
+	for _, c := range run.Checks {
+		o.Result(run, c)
+	}
+
+	require.Len(t, *requests, 2)
+
+	closed := (*requests)[0]
+	require.Equal(t, "/v2/alerts/kibertas:test-cluster:ingress/close?identifierType=alias", closed.Path)
+	require.Equal(t, "GenieKey api-key", closed.Authorization)
+
+	created := (*requests)[1]
+	require.Equal(t, "/v2/alerts", created.Path)
+	require.Equal(t, "kibertas:test-cluster:fluent", created.Body["alias"])
+	require.Equal(t, "P1", created.Body["priority"])
+	require.Equal(t, "no logs in s3\n\nDiagnostics:\nevents events in fluent-test", created.Body["description"])
+	require.Equal(t, "check s3 object", created.Body["details"].(map[string]interface{})["step"])
+}
+
+func TestAlertsOfSoakTests(t *testing.T) {
+	ts, requests := newRecorder(t)
+	defer ts.Close()
+	logger := func() *logrus.Entry { return logrus.NewEntry(logrus.New()) }
+	p := NewPagerDuty("routing-key", logger)
+	p.URL = ts.URL
+	o := NewOpsgenie("api-key", logger)
+	o.APIURL = ts.URL
+
+	// Results are not notified in soak mode, so runs other than soak tests have nothing to alert on.
+	p.Summary(newSlackTestRun())
+	o.Summary(newSlackTestRun())
+	require.Empty(t, *requests)
+
+	// The last run passed, but fluent failed in earlier ones.
+	run := report.NewRun("test-cluster")
+	run.StartCheck("ingress", "").Finish(nil)
+	run.StartCheck("fluent", "fluent-test").Finish(nil)
+	run.Finish()
+	run.Soak = &report.Summary{Runs: 10, Passed: 7, Failed: 3, Failures: []report.FailureGroup{
+		{Check: "fluent", Step: "check s3 object", Reason: "no logs in s3", Count: 2},
+		{Check: "fluent", Step: "create deployment", Reason: "timeout: waiting for Pods to be ready", Count: 1},
+	}}
+
+	p.Summary(run)
+	require.Len(t, *requests, 2)
+	require.Equal(t, "resolve", (*requests)[0].Body["event_action"])
+	require.Equal(t, "kibertas:test-cluster:ingress", (*requests)[0].Body["dedup_key"])
+	trigger := (*requests)[1].Body
+	require.Equal(t, "trigger", trigger["event_action"])
+	require.Equal(t, "kibertas:test-cluster:fluent", trigger["dedup_key"])
+	require.Equal(t, "kibertas fluent check failed in test-cluster at step check s3 object: no logs in s3", trigger["payload"].(map[string]interface{})["summary"])
+
+	*requests = nil
+	o.Summary(run)
+	require.Len(t, *requests, 2)
+	require.Equal(t, "/v2/alerts/kibertas:test-cluster:ingress/close?identifierType=alias", (*requests)[0].Path)
+	require.Equal(t, "/v2/alerts", (*requests)[1].Path)
+	require.Equal(t, "kibertas:test-cluster:fluent", (*requests)[1].Body["alias"])
+}
+
+func TestSeverity(t *testing.T) {
+	s := Severity{Checks: map[string]string{"ingress": SeverityCritical}}
+	require.Equal(t, SeverityCritical, s.of("ingress"))
+	require.Equal(t, SeverityError, s.of("fluent"))
+	s.Default = SeverityWarning
+	require.Equal(t, SeverityWarning, s.of("fluent"))
+	require.Equal(t, "kibertas:c:x", dedupKey(report.NewRun("c"), &report.CheckResult{Name: "x"}))
+}
+
+func TestAlertsResolveWhateverTheFilter(t *testing.T) {
+	ts, requests := newRecorder(t)
+	defer ts.Close()
+	p := NewPagerDuty("routing-key", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	p.URL = ts.URL
+
+	now := time.Date(2024, 1, 1, 15, 0, 0, 0, time.UTC)
+	sinks := FanOut{{Notifier: p, Filter: Filter{
+		Policy:     PolicyOnFailure,
+		QuietHours: &QuietHours{Start: 22, End: 7, Location: time.UTC},
+		now:        func() time.Time { return now },
+	}}}
+
+	// Fails during the day.
+	run := report.NewRun("test-cluster")
+	check := run.StartCheck("fluent", "")
+	check.Finish(errors.New("no logs in s3"))
+	sinks.Result(run, check)
+	require.Len(t, *requests, 1)
+	require.Equal(t, "trigger", (*requests)[0].Body["event_action"])
+
+	// Passes during quiet hours.
+	now = now.Add(8 * time.Hour)
+	run = report.NewRun("test-cluster")
+	run.Previous = map[string]report.Status{"fluent": report.StatusFailed}
+	check = run.StartCheck("fluent", "")
+	check.Finish(nil)
+	sinks.Result(run, check)
+	require.Len(t, *requests, 2)
+	require.Equal(t, "resolve", (*requests)[1].Body["event_action"])
+	require.Equal(t, "kibertas:test-cluster:fluent", (*requests)[1].Body["dedup_key"])
+
+	// Checks known to have passed before have nothing to resolve.
+	run.Previous["fluent"] = report.StatusPassed
+	sinks.Result(run, check)
+	require.Len(t, *requests, 2)
+}
+
+func TestAlertTemplates(t *testing.T) {
+	ts, requests := newRecorder(t)
+	defer ts.Close()
+	logger := func() *logrus.Entry { return logrus.NewEntry(logrus.New()) }
+	templates := &Templates{Failure: template.Must(template.New("failure").Parse(
+		"{{.Check.Name}} が {{.Run.ClusterName}} で失敗しました\n\n{{.Check.Error}}\n"))}
+	p := NewPagerDuty("routing-key", logger)
+	p.URL = ts.URL
+	p.Templates = templates
+	o := NewOpsgenie("api-key", logger)
+	o.APIURL = ts.URL
+	o.Templates = templates
+
+	run := newSlackTestRun()
+	p.Result(run, run.Checks[1])
+	o.Result(run, run.Checks[1])
+
+	require.Len(t, *requests, 2)
+	payload := (*requests)[0].Body["payload"].(map[string]interface{})
+	require.Equal(t, "fluent が test-cluster で失敗しました\n\nno logs in s3", payload["summary"])
+	require.Equal(t, "fluent が test-cluster で失敗しました", (*requests)[1].Body["message"])
+	require.Equal(t, "no logs in s3", (*requests)[1].Body["description"])
+}
+
+func TestTruncate(t *testing.T) {
+	require.Equal(t, "short", truncate("short", 10))
+	require.Equal(t, "abcdefg...", truncate("abcdefghijklmnop", 10))
+	// Lengths are in characters, and multi-byte ones are not cut in half.
+	require.Equal(t, "監視チェ...", truncate("監視チェックが失敗しました", 7))
+	require.Equal(t, "監視", truncate("監視チェック", 2))
+	require.Equal(t, "", truncate("監視チェック", 0))
+}
@@ -2,21 +2,59 @@ package notify
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
 
 	"github.com/sirupsen/logrus"
+
+	"github.com/chatwork/kibertas/util/report"
 )
 
+// DefaultChatworkMaxMessageLength is how many characters are sent in one message.
+const DefaultChatworkMaxMessageLength = 10000
+
 type Chatwork struct {
 	ApiToken string
 	RoomId   string
-	Site     string
-	Logger   func() *logrus.Entry
-	Messages strings.Builder
+	// Site is the host of the API, or its base URL to use another scheme than https.
+	Site       string
+	HTTPClient *http.Client
+	// MaxRetries is how many times a rate limited, 5xx or failed request is retried.
+	MaxRetries int
+	Backoff    time.Duration
+	// MaxMessageLength is the length in characters above which messages are split.
+	MaxMessageLength int
+	// Mentions has the account IDs to mention when a check fails, by check name.
+	// Those under "*" are mentioned for any failure.
+	Mentions map[string][]string
+	// Templates render the messages. DefaultTemplates if nil.
+	Templates *Templates
+	// UploadDiagnostics uploads the diagnostics of failed checks as a file.
+	UploadDiagnostics bool
+	// Tasks creates tasks for failed checks when set.
+	Tasks *ChatworkTasks
+	// Live posts a single message when a run starts and edits it as the checks
+	// progress, instead of sending a message for every check.
+	Live bool
+	// MinUpdateInterval is the minimum time between the edits made for steps
+	// in live mode, to stay within the rate limit.
+	MinUpdateInterval time.Duration
+	Logger            func() *logrus.Entry
+	Messages          strings.Builder
+
+	// sleep waits between retries. time.Sleep if nil.
+	sleep func(time.Duration)
+	// live is the message edited in live mode.
+	live *liveMessage
 }
 
 func NewChatwork(apiToken string, roomId string, logger func() *logrus.Entry) *Chatwork {
@@ -26,53 +64,324 @@ func NewChatwork(apiToken string, roomId string, logger func() *logrus.Entry) *C
 		site = os.Getenv("CHATWORK_SITE")
 	}
 	return &Chatwork{
-		ApiToken: apiToken,
-		RoomId:   roomId,
-		Logger:   logger,
-		Site:     site,
+		ApiToken:          apiToken,
+		RoomId:            roomId,
+		Logger:            logger,
+		Site:              site,
+		HTTPClient:        &http.Client{Timeout: 30 * time.Second},
+		MaxRetries:        3,
+		Backoff:           time.Second,
+		MaxMessageLength:  DefaultChatworkMaxMessageLength,
+		MinUpdateInterval: 5 * time.Second,
 	}
 }
 
+// AddMessage and Send do nothing on a nil *Chatwork, so that checkers
+// can be run without notifications.
 func (c *Chatwork) AddMessage(message string) {
+	if c == nil {
+		return
+	}
 	if _, err := c.Messages.WriteString(message); err != nil {
 		c.Logger().Error(err)
 	}
 }
 
+// Start, Step, Result and Summary implement Notifier with Templates.
+// The messages of a check are buffered and sent together when it finishes,
+// while those about the run as a whole are sent right away.
+func (c *Chatwork) Start(run *report.Run, check *report.CheckResult) {
+	if c == nil {
+		return
+	}
+	if c.Live {
+		c.updateLive(run, true)
+		return
+	}
+	c.AddMessage(c.render(c.templates().Start, TemplateData{Run: run, Check: check}))
+}
+
+func (c *Chatwork) Step(run *report.Run, check *report.CheckResult, message string) {
+	if c == nil {
+		return
+	}
+	if c.Live {
+		c.liveStep(run, check, message)
+		return
+	}
+	c.AddMessage(c.render(c.templates().Step, TemplateData{Run: run, Check: check, Message: message}))
+	if check == nil {
+		c.send()
+	}
+}
+
+// Result sends the messages of the check with its outcome,
+// mentioning the members on call for it if it failed.
+func (c *Chatwork) Result(run *report.Run, check *report.CheckResult) {
+	if c == nil {
+		return
+	}
+	data := TemplateData{Run: run, Check: check, Messages: c.buffered()}
+	tmpl := c.templates().Success
+	if check.Status == report.StatusFailed {
+		tmpl = c.templates().Failure
+		data.Mentions = c.mentions(check.Name)
+	}
+	if c.Live {
+		c.updateLive(run, true)
+	}
+	// In live mode, failures are still sent on their own to notify who is mentioned.
+	if !c.Live || check.Status == report.StatusFailed {
+		c.AddMessage(c.render(tmpl, data))
+		c.send()
+	}
+
+	if check.Status == report.StatusFailed && c.UploadDiagnostics {
+		c.uploadDiagnostics(run, check)
+	}
+	if check.Status == report.StatusFailed && c.Tasks != nil {
+		if err := c.createTask(run, check); err != nil {
+			c.Logger().Errorf("Error creating Chatwork task for %s: %s", check.Name, err)
+		}
+	}
+}
+
+// Summary sends what the summary template renders, by default only digests
+// and the summary of soak tests, since the result of every check has already been sent.
+func (c *Chatwork) Summary(run *report.Run) {
+	if c == nil || run == nil {
+		return
+	}
+	if c.live != nil && c.live.runID == run.ID {
+		c.updateLive(run, true)
+	}
+	data := TemplateData{Run: run}
+	if run.Soak != nil && run.Soak.Failed > 0 {
+		for _, f := range run.Soak.Failures {
+			data.Mentions = append(data.Mentions, c.mentions(f.Check)...)
+		}
+	}
+	if summary := c.render(c.templates().Summary, data); strings.TrimSpace(summary) != "" {
+		c.AddMessage(summary)
+		c.send()
+	}
+}
+
+func (c *Chatwork) templates() *Templates {
+	if c.Templates == nil {
+		c.Templates = DefaultTemplates()
+	}
+	return c.Templates
+}
+
+// render executes tmpl, falling back to the data as JSON if it fails,
+// so that a broken template doesn't lose the message.
+func (c *Chatwork) render(tmpl *template.Template, data TemplateData) string {
+	text, err := render(tmpl, data)
+	if err == nil {
+		return text
+	}
+	c.Logger().Errorf("Error rendering the %s template: %s", tmpl.Name(), err)
+	fallback, _ := json.Marshal(struct {
+		Check   *report.CheckResult `json:",omitempty"`
+		Message string              `json:",omitempty"`
+	}{data.Check, data.Message})
+	return string(fallback) + "\n"
+}
+
+// buffered returns the buffered messages by line, and resets the buffer.
+func (c *Chatwork) buffered() []string {
+	body := strings.TrimSuffix(c.Messages.String(), "\n")
+	c.Messages.Reset()
+	if body == "" {
+		return nil
+	}
+	return strings.Split(body, "\n")
+}
+
+var chatworkMarker = map[report.Status]string{
+	report.StatusPassed:  "✅",
+	report.StatusFailed:  "❌",
+	report.StatusSkipped: "⏭",
+	report.StatusRunning: "⏳",
+}
+
+// mentions returns the account IDs to mention for a failure of check.
+func (c *Chatwork) mentions(check string) []string {
+	return append(append([]string{}, c.Mentions["*"]...), c.Mentions[check]...)
+}
+
+// send sends the buffered messages, only logging errors as notifications are best effort.
+func (c *Chatwork) send() {
+	if err := c.Send(); err != nil {
+		c.Logger().Errorf("Error sending to Chatwork: %s", err)
+	}
+}
+
+func clusterName(run *report.Run) string {
+	if run == nil {
+		return ""
+	}
+	return run.ClusterName
+}
+
 // Send メッセージを送信する
 // https://developer.chatwork.com/ja/endpoint_rooms.html#POST-rooms-room_id-messages
-// エラーが起きても問題ないので、エラーはログに出力するだけ
-func (c *Chatwork) Send() {
+//
+// Messages longer than MaxMessageLength are split into several posts.
+// Rate limited requests are retried after Retry-After, and those failing with
+// a 5xx status or a network error after Backoff, then twice as long each time,
+// up to MaxRetries times.
+// Nothing is sent without a token and a room.
+func (c *Chatwork) Send() error {
+	if c == nil {
+		return nil
+	}
 	defer c.Messages.Reset()
-	// APIのURLを作成
-	apiUrl := fmt.Sprintf("https://api.chatwork.com/v2/rooms/%s/messages", c.RoomId)
 
-	// メッセージをエンコード
-	data := url.Values{}
-	data.Set("body", c.Messages.String())
+	if c.ApiToken == "" || c.RoomId == "" || c.Messages.Len() == 0 {
+		return nil
+	}
 
-	// リクエストを作成
-	req, err := http.NewRequest("POST", apiUrl, bytes.NewBufferString(data.Encode()))
-	if err != nil {
-		c.Logger().Error(err)
+	for _, body := range splitMessage(c.Messages.String(), c.MaxMessageLength) {
+		if err := c.post(body); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+func (c *Chatwork) post(body string) error {
+	data := url.Values{}
+	data.Set("body", body)
+	_, err := c.do(http.MethodPost, "messages", "application/x-www-form-urlencoded", []byte(data.Encode()))
+	return err
+}
 
-	// ヘッダーを設定
-	req.Header.Add("X-ChatWorkToken", c.ApiToken)
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+// do sends a request to the endpoint of the room, retrying as described in Send,
+// and returns the body of the response.
+func (c *Chatwork) do(method, endpoint, contentType string, body []byte) ([]byte, error) {
+	site := c.Site
+	if !strings.Contains(site, "://") {
+		site = "https://" + site
+	}
+	apiUrl := fmt.Sprintf("%s/v2/rooms/%s/%s", strings.TrimSuffix(site, "/"), url.PathEscape(c.RoomId), endpoint)
 
-	// リクエストを送信
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		c.Logger().Error(err)
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			c.Logger().Error(err)
+	sleep := c.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, apiUrl, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("X-ChatWorkToken", c.ApiToken)
+		if contentType != "" {
+			req.Header.Add("Content-Type", contentType)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			if attempt >= c.MaxRetries {
+				if attempt > 0 {
+					return nil, fmt.Errorf("after %d attempts: %w", attempt+1, err)
+				}
+				return nil, err
+			}
+			c.Logger().Warnf("Error sending to Chatwork, retrying in %s: %s", backoff, err)
+			sleep(backoff)
+			backoff *= 2
+			continue
 		}
-	}()
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		_ = resp.Body.Close()
+		c.Logger().Infof("Chatwork %s %s Status: %s", method, endpoint, resp.Status)
 
-	// ステータスコードを表示
-	c.Logger().Infoln("Chatwork Send Message Status:", resp.Status)
+		var wait time.Duration
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return respBody, nil
+		case resp.StatusCode == http.StatusTooManyRequests:
+			wait = retryAfter(resp.Header.Get("Retry-After"), backoff)
+		case resp.StatusCode >= 500:
+			wait = backoff
+		default:
+			return nil, &ChatworkError{Status: resp.Status, StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(respBody)), Attempts: 1}
+		}
+		if attempt >= c.MaxRetries {
+			return nil, &ChatworkError{Status: resp.Status, StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(respBody)), Attempts: attempt + 1}
+		}
+		c.Logger().Warnf("Chatwork responded %s, retrying in %s", resp.Status, wait)
+		sleep(wait)
+		backoff *= 2
+	}
+}
+
+// ChatworkError is returned when the API answers with an error status.
+type ChatworkError struct {
+	Status     string
+	StatusCode int
+	Body       string
+	// Attempts is how many times the request was sent, more than one if it was retried.
+	Attempts int
+}
+
+func (e *ChatworkError) Error() string {
+	if e.Attempts > 1 {
+		return fmt.Sprintf("%s after %d attempts: %s", e.Status, e.Attempts, e.Body)
+	}
+	return fmt.Sprintf("%s: %s", e.Status, e.Body)
+}
+
+// retryAfter parses the Retry-After header, in seconds or as an HTTP date,
+// falling back to fallback when it is missing or invalid.
+func retryAfter(header string, fallback time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return fallback
+}
+
+// splitMessage splits message at line breaks into parts of at most limit characters.
+// Lines longer than limit are split too. limit <= 0 means no limit.
+func splitMessage(message string, limit int) []string {
+	if limit <= 0 || utf8.RuneCountInString(message) <= limit {
+		return []string{message}
+	}
+
+	var parts []string
+	var part strings.Builder
+	length := 0
+	flush := func() {
+		if part.Len() > 0 {
+			parts = append(parts, part.String())
+			part.Reset()
+			length = 0
+		}
+	}
+	for _, line := range strings.SplitAfter(message, "\n") {
+		runes := []rune(line)
+		for len(runes) > limit {
+			flush()
+			parts = append(parts, string(runes[:limit]))
+			runes = runes[limit:]
+		}
+		if length+len(runes) > limit {
+			flush()
+		}
+		part.WriteString(string(runes))
+		length += len(runes)
+	}
+	flush()
+	return parts
 }
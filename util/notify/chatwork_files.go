@@ -0,0 +1,147 @@
+package notify
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+// ChatworkFileLimit is the maximum size of files uploaded to Chatwork.
+const ChatworkFileLimit = 5 << 20
+
+// UploadFile uploads a file to the room, with message as its description.
+// Nothing is uploaded without a token and a room.
+// https://developer.chatwork.com/reference/post-rooms-room_id-files
+func (c *Chatwork) UploadFile(name string, content []byte, message string) error {
+	if c == nil || c.ApiToken == "" || c.RoomId == "" {
+		return nil
+	}
+	if len(content) > ChatworkFileLimit {
+		return fmt.Errorf("%s is %d bytes, more than the limit of %d", name, len(content), ChatworkFileLimit)
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", name)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(content); err != nil {
+		return err
+	}
+	if message != "" {
+		if err := w.WriteField("message", message); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	_, err = c.do(http.MethodPost, "files", w.FormDataContentType(), body.Bytes())
+	return err
+}
+
+// uploadDiagnostics uploads the diagnostics of a failed check as a tar.gz bundle,
+// or only their summary if the bundle is too large.
+func (c *Chatwork) uploadDiagnostics(run *report.Run, check *report.CheckResult) {
+	summary := diagnosticsSummary(run, check)
+	name := fmt.Sprintf("kibertas-%s-%s-%s", fileName(clusterName(run)), fileName(check.Name), check.StartedAt.Format("20060102-150405"))
+	message := fmt.Sprintf("Diagnostics of %s in %s", check.Name, clusterName(run))
+
+	bundle, err := diagnosticsBundle(summary, check.Artifacts)
+	if err == nil && len(bundle) <= ChatworkFileLimit {
+		err = c.UploadFile(name+".tar.gz", bundle, message)
+	} else {
+		if err == nil {
+			c.Logger().Warnf("Diagnostics of %s are %d bytes, uploading their summary only", check.Name, len(bundle))
+		}
+		err = c.UploadFile(name+".txt", []byte(summary), message)
+	}
+	if err != nil {
+		c.Logger().Errorf("Error uploading diagnostics to Chatwork: %s", err)
+	}
+}
+
+// diagnosticsSummary describes the failure and lists the steps and artifacts of the check.
+func diagnosticsSummary(run *report.Run, check *report.CheckResult) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Check: %s\nCluster: %s\n", check.Name, clusterName(run))
+	if run != nil {
+		fmt.Fprintf(&b, "Run: %s\n", run.ID)
+	}
+	if check.Namespace != "" {
+		fmt.Fprintf(&b, "Namespace: %s\n", check.Namespace)
+	}
+	fmt.Fprintf(&b, "Status: %s\nError: %s\n\nSteps:\n", check.Status, check.Error)
+	for _, s := range check.Steps {
+		fmt.Fprintf(&b, "  %s\t%s\t%s", s.Status, s.Duration().Round(time.Millisecond), s.Name)
+		if s.Error != "" {
+			fmt.Fprintf(&b, ": %s", s.Error)
+		}
+		b.WriteString("\n")
+	}
+	if len(check.Artifacts) > 0 {
+		b.WriteString("\nArtifacts:\n")
+		for _, a := range check.Artifacts {
+			fmt.Fprintf(&b, "  %s\t%s", a.Kind, a.Name)
+			if a.URL != "" {
+				fmt.Fprintf(&b, "\t%s", a.URL)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// diagnosticsBundle returns a tar.gz with the summary and a file per artifact, by kind.
+func diagnosticsBundle(summary string, artifacts []report.Artifact) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	files := []struct{ name, content string }{{"summary.txt", summary}}
+	seen := map[string]int{}
+	for _, a := range artifacts {
+		if a.Content == "" {
+			continue
+		}
+		name := fmt.Sprintf("%s/%s", a.Kind, fileName(a.Name))
+		if seen[name]++; seen[name] > 1 {
+			name = fmt.Sprintf("%s-%d", name, seen[name])
+		}
+		files = append(files, struct{ name, content string }{name + ".txt", a.Content})
+	}
+
+	now := time.Now()
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.content)), ModTime: now}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write([]byte(f.content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// fileName makes name safe to use as a file name, e.g. "events in ns" or "pod/container".
+func fileName(name string) string {
+	return strings.Trim(unsafeFileChars.ReplaceAllString(name, "_"), "_")
+}
@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+func TestUploadDiagnostics(t *testing.T) {
+	var paths []string
+	var fileName, message string
+	files := map[string]string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path != "/v2/rooms/room/files" {
+			return
+		}
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		message = r.FormValue("message")
+		f, header, err := r.FormFile("file")
+		require.NoError(t, err)
+		fileName = header.Filename
+
+		gz, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		tr := tar.NewReader(gz)
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			var b bytes.Buffer
+			_, err = io.Copy(&b, tr)
+			require.NoError(t, err)
+			files[h.Name] = b.String()
+		}
+	}))
+	defer ts.Close()
+
+	c := NewChatwork("token", "room", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	c.Site = ts.URL
+	c.UploadDiagnostics = true
+
+	run := report.NewRun("test-cluster")
+	passed := run.StartCheck("ingress", "")
+	passed.Finish(nil)
+	c.Result(run, passed)
+
+	check := run.StartCheck("fluent", "fluent-test")
+	_ = check.Step("check s3 object", func() error { return errors.New("no logs in s3") })
+	check.AddArtifact(report.Artifact{Kind: report.KindEvents, Name: "events in fluent-test", Content: "Warning BackOff"})
+	check.AddArtifact(report.Artifact{Kind: report.KindPodLogs, Name: "fluent-bit-abc/fluent-bit", Content: "connection refused"})
+	check.AddArtifact(report.Artifact{Kind: report.KindS3Object, Name: "logs", URL: "https://example.com/logs"})
+	check.Finish(errors.New("no logs in s3"))
+	c.Result(run, check)
+
+	require.Equal(t, []string{"/v2/rooms/room/messages", "/v2/rooms/room/messages", "/v2/rooms/room/files"}, paths)
+	require.Regexp(t, `^kibertas-test-cluster-fluent-\d{8}-\d{6}\.tar\.gz$`, fileName)
+	require.Equal(t, "Diagnostics of fluent in test-cluster", message)
+	require.Len(t, files, 3)
+	require.Equal(t, "Warning BackOff", files["events/events_in_fluent-test.txt"])
+	require.Equal(t, "connection refused", files["pod-logs/fluent-bit-abc_fluent-bit.txt"])
+	require.Contains(t, files["summary.txt"], "Check: fluent\nCluster: test-cluster\n")
+	require.Contains(t, files["summary.txt"], "Error: no logs in s3\n")
+	require.Contains(t, files["summary.txt"], "s3-object\tlogs\thttps://example.com/logs\n")
+}
+
+func TestUploadFileWithoutToken(t *testing.T) {
+	c := &Chatwork{RoomId: "room"}
+	require.NoError(t, c.UploadFile("a.txt", []byte("a"), ""))
+
+	c = NewChatwork("token", "room", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	require.EqualError(t, c.UploadFile("a.txt", make([]byte, ChatworkFileLimit+1), ""), "a.txt is 5242881 bytes, more than the limit of 5242880")
+}
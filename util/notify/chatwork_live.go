@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+// liveMessage is the message of a run in live mode.
+type liveMessage struct {
+	runID     string
+	messageID string
+	// latest has the last step message of each check.
+	latest map[string]string
+	// notes are the messages about the run as a whole.
+	notes   []string
+	updated time.Time
+}
+
+func (c *Chatwork) liveStep(run *report.Run, check *report.CheckResult, message string) {
+	live := c.liveMessage(run)
+	if check == nil {
+		live.notes = append(live.notes, message)
+		c.updateLive(run, true)
+		return
+	}
+	live.latest[check.Name] = message
+	c.updateLive(run, false)
+}
+
+func (c *Chatwork) liveMessage(run *report.Run) *liveMessage {
+	if c.live == nil || c.live.runID != run.ID {
+		c.live = &liveMessage{runID: run.ID, latest: map[string]string{}}
+	}
+	return c.live
+}
+
+// updateLive posts the live message of run, or edits it if it was already posted.
+// Unless force is set, it is left as is if it was edited less than MinUpdateInterval ago.
+func (c *Chatwork) updateLive(run *report.Run, force bool) {
+	live := c.liveMessage(run)
+	if !force && time.Since(live.updated) < c.MinUpdateInterval {
+		return
+	}
+	if c.ApiToken == "" || c.RoomId == "" {
+		return
+	}
+
+	body := c.render(c.templates().Live, TemplateData{Run: run, Messages: live.notes, Latest: live.latest})
+	if c.MaxMessageLength > 0 {
+		// The live message is edited in place, so it is cut rather than split.
+		body = truncate(body, c.MaxMessageLength)
+	}
+	data := url.Values{}
+	data.Set("body", body)
+
+	live.updated = time.Now()
+	if live.messageID != "" {
+		if _, err := c.do(http.MethodPut, "messages/"+live.messageID, "application/x-www-form-urlencoded", []byte(data.Encode())); err != nil {
+			c.Logger().Errorf("Error updating Chatwork message %s: %s", live.messageID, err)
+		}
+		return
+	}
+
+	resp, err := c.do(http.MethodPost, "messages", "application/x-www-form-urlencoded", []byte(data.Encode()))
+	if err != nil {
+		c.Logger().Errorf("Error sending to Chatwork: %s", err)
+		return
+	}
+	var posted struct {
+		MessageID json.RawMessage `json:"message_id"`
+	}
+	if err := json.Unmarshal(resp, &posted); err != nil {
+		c.Logger().Errorf("Error decoding Chatwork response: %s", err)
+		return
+	}
+	// The ID is documented as a string, but accept a number too.
+	var id string
+	if err := json.Unmarshal(posted.MessageID, &id); err != nil {
+		id = fmt.Sprint(string(posted.MessageID))
+	}
+	live.messageID = id
+}
@@ -0,0 +1,128 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+func TestChatworkLive(t *testing.T) {
+	type request struct{ method, path, body string }
+	var requests []request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		requests = append(requests, request{r.Method, r.URL.Path, r.PostForm.Get("body")})
+		_, _ = w.Write([]byte(`{"message_id":"1234"}`))
+	}))
+	defer ts.Close()
+
+	c := NewChatwork("token", "room", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	c.Site = ts.URL
+	c.Live = true
+	c.MinUpdateInterval = time.Hour
+
+	run := report.NewRun("test-cluster")
+	ingress := run.StartCheck("ingress", "ingress-test")
+	c.Start(run, ingress)
+	c.Step(run, ingress, "Record is available: 192.0.2.1")
+	ingress.Finish(nil)
+	c.Result(run, ingress)
+
+	fluent := run.StartCheck("fluent", "fluent-test")
+	c.Start(run, fluent)
+	fluent.Finish(errors.New("no logs in s3"))
+	c.Result(run, fluent)
+	run.Finish()
+	c.Summary(run)
+
+	require.Equal(t, "POST", requests[0].method)
+	require.Equal(t, "/v2/rooms/room/messages", requests[0].path)
+	require.Contains(t, requests[0].body, "⏳ ingress")
+	for _, r := range requests[1:] {
+		if r.method == "POST" {
+			// Only the failure is posted on its own.
+			require.Contains(t, r.body, "fluent check failed in test-cluster")
+			continue
+		}
+		require.Equal(t, "PUT", r.method)
+		require.Equal(t, "/v2/rooms/room/messages/1234", r.path)
+	}
+	// The step was throttled after the start, and results are always updated.
+	require.Len(t, requests, 6)
+
+	last := requests[len(requests)-1]
+	require.Equal(t, "PUT", last.method)
+	require.True(t, strings.HasPrefix(last.body, "[info][title]❌ kibertas failed in test-cluster"), last.body)
+	require.Contains(t, last.body, "✅ ingress\n")
+	require.Contains(t, last.body, "❌ fluent: no logs in s3\n")
+}
+
+func TestChatworkLiveMaxMessageLength(t *testing.T) {
+	var body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		body = r.PostForm.Get("body")
+		_, _ = w.Write([]byte(`{"message_id":"1234"}`))
+	}))
+	defer ts.Close()
+
+	c := NewChatwork("token", "room", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	c.Site = ts.URL
+	c.Live = true
+	c.MaxMessageLength = 50
+
+	run := report.NewRun("test-cluster")
+	check := run.StartCheck("ingress", "ingress-test")
+	c.Step(run, check, strings.Repeat("レコードがまだありません", 10))
+
+	// The limit is in characters, and multi-byte ones are not cut in half.
+	require.True(t, utf8.ValidString(body))
+	require.Equal(t, 50, utf8.RuneCountInString(body))
+	require.True(t, strings.HasSuffix(body, "..."))
+}
+
+func TestSlackLive(t *testing.T) {
+	var methods []string
+	var messages []slackMessage
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, strings.TrimPrefix(r.URL.Path, "/api/"))
+		var msg slackMessage
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+		messages = append(messages, msg)
+		_, _ = w.Write([]byte(`{"ok":true,"ts":"1700000000.000100"}`))
+	}))
+	defer ts.Close()
+
+	s := NewSlack("", "xoxb-token", "#kibertas", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	s.APIURL = ts.URL + "/api"
+	s.Live = true
+	s.MinUpdateInterval = 0
+
+	run := report.NewRun("test-cluster")
+	check := run.StartCheck("fluent", "fluent-test")
+	s.Start(run, check)
+	s.Step(run, check, "Waiting for logs in s3")
+	check.Finish(errors.New("no logs in s3"))
+	s.Result(run, check)
+	run.Finish()
+	s.Summary(run)
+
+	require.Equal(t, []string{"chat.postMessage", "chat.update", "chat.update", "chat.update", "chat.postMessage"}, methods)
+	require.Equal(t, "kibertas running in test-cluster", messages[0].Text)
+	require.Contains(t, messages[1].Blocks[2].Text.Text, "Waiting for logs in s3")
+	for _, msg := range messages[1:4] {
+		require.Equal(t, "1700000000.000100", msg.TS)
+	}
+	require.Equal(t, "kibertas failed in test-cluster", messages[3].Text)
+	require.Equal(t, "1700000000.000100", messages[4].ThreadTS)
+}
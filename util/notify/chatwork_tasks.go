@@ -0,0 +1,109 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+// ChatworkTasks configures the tasks created for failed checks.
+type ChatworkTasks struct {
+	// Assignees has the account IDs to assign the tasks to, by check name.
+	// Those under "*" are assigned the tasks of any check. No task is
+	// created for checks without assignees.
+	Assignees map[string][]string
+	// Deadline is the time given to complete a task. No deadline if zero.
+	Deadline time.Duration
+	// State remembers the tasks created, so that a check failing again
+	// doesn't get a new task while the previous one is open.
+	State *report.State
+}
+
+func (t *ChatworkTasks) assignees(check string) []string {
+	seen := map[string]bool{}
+	var ids []string
+	for _, id := range append(append([]string{}, t.Assignees["*"]...), t.Assignees[check]...) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// createTask creates a task for the failed check, unless one is still open for it.
+func (c *Chatwork) createTask(run *report.Run, check *report.CheckResult) error {
+	assignees := c.Tasks.assignees(check.Name)
+	if len(assignees) == 0 || c.ApiToken == "" || c.RoomId == "" {
+		return nil
+	}
+
+	state := c.Tasks.State
+	if state == nil {
+		state = &report.State{}
+	}
+	var open []int64
+	for _, id := range state.ChatworkTasks[check.Name] {
+		done, err := c.taskDone(id)
+		if err != nil {
+			return fmt.Errorf("getting task %d: %w", id, err)
+		}
+		if !done {
+			open = append(open, id)
+		}
+	}
+	if len(open) > 0 {
+		c.Logger().Infof("Not creating a Chatwork task for %s, task %d is still open", check.Name, open[0])
+		state.SetChatworkTasks(check.Name, open)
+		return nil
+	}
+
+	details := newAlertDetails(run, check, "")
+	data := url.Values{}
+	data.Set("body", fmt.Sprintf("%s\nRun: %s", details.summary(), details.RunID))
+	data.Set("to_ids", strings.Join(assignees, ","))
+	if c.Tasks.Deadline > 0 {
+		data.Set("limit", strconv.FormatInt(time.Now().Add(c.Tasks.Deadline).Unix(), 10))
+		data.Set("limit_type", "time")
+	} else {
+		data.Set("limit_type", "none")
+	}
+	body, err := c.do(http.MethodPost, "tasks", "application/x-www-form-urlencoded", []byte(data.Encode()))
+	if err != nil {
+		return err
+	}
+	var created struct {
+		TaskIDs []int64 `json:"task_ids"`
+	}
+	if err := json.Unmarshal(body, &created); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	state.SetChatworkTasks(check.Name, created.TaskIDs)
+	return nil
+}
+
+// taskDone tells whether the task was completed, or deleted.
+func (c *Chatwork) taskDone(id int64) (bool, error) {
+	body, err := c.do(http.MethodGet, fmt.Sprintf("tasks/%d", id), "", nil)
+	var apiErr *ChatworkError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var task struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(body, &task); err != nil {
+		return false, fmt.Errorf("decoding response: %w", err)
+	}
+	return task.Status == "done", nil
+}
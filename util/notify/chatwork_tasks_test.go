@@ -0,0 +1,86 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+func TestChatworkTasks(t *testing.T) {
+	var created []string
+	taskStatus := map[string]string{}
+	nextID := 100
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v2/rooms/room/tasks":
+			require.NoError(t, r.ParseForm())
+			created = append(created, r.PostForm.Get("body"))
+			require.Equal(t, "1,2", r.PostForm.Get("to_ids"))
+			require.Equal(t, "time", r.PostForm.Get("limit_type"))
+			require.NotEmpty(t, r.PostForm.Get("limit"))
+			nextID++
+			taskStatus[fmt.Sprint(nextID)] = "open"
+			_, _ = fmt.Fprintf(w, `{"task_ids":[%d]}`, nextID)
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v2/rooms/room/tasks/"):
+			status, ok := taskStatus[strings.TrimPrefix(r.URL.Path, "/v2/rooms/room/tasks/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"status":%q}`, status)
+		}
+	}))
+	defer ts.Close()
+
+	state := &report.State{}
+	c := NewChatwork("token", "room", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	c.Site = ts.URL
+	c.Tasks = &ChatworkTasks{
+		Assignees: map[string][]string{"*": {"1"}, "fluent": {"2", "1"}},
+		Deadline:  24 * time.Hour,
+		State:     state,
+	}
+
+	fail := func() {
+		run := report.NewRun("test-cluster")
+		check := run.StartCheck("fluent", "")
+		_ = check.Step("check s3 object", func() error { return errors.New("no logs in s3") })
+		check.Finish(errors.New("no logs in s3"))
+		c.Result(run, check)
+	}
+
+	fail()
+	require.Len(t, created, 1)
+	require.Regexp(t, `^kibertas fluent check failed in test-cluster at step check s3 object: no logs in s3\nRun: \d{8}-\d{6}-\w{5}$`, created[0])
+	require.Equal(t, map[string][]int64{"fluent": {101}}, state.ChatworkTasks)
+
+	// The task is still open.
+	fail()
+	require.Len(t, created, 1)
+
+	taskStatus["101"] = "done"
+	fail()
+	require.Len(t, created, 2)
+	require.Equal(t, map[string][]int64{"fluent": {102}}, state.ChatworkTasks)
+
+	// Deleted tasks are not found.
+	delete(taskStatus, "102")
+	fail()
+	require.Len(t, created, 3)
+	require.Equal(t, map[string][]int64{"fluent": {103}}, state.ChatworkTasks)
+
+	// No task without assignees.
+	c.Tasks.Assignees = map[string][]string{"ingress": {"3"}}
+	taskStatus["103"] = "done"
+	fail()
+	require.Len(t, created, 3)
+}
@@ -1,13 +1,18 @@
 package notify
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chatwork/kibertas/util/report"
 )
 
 func TestAddMessage(t *testing.T) {
@@ -22,7 +27,6 @@ func TestAddMessage(t *testing.T) {
 func TestSend(t *testing.T) {
 	c := NewChatwork("token", "room", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
 	c.AddMessage("test")
-	c.Site = "localhost:8080"
 
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
@@ -57,7 +61,166 @@ func TestSend(t *testing.T) {
 
 	defer ts.Close()
 
-	c.Site = ts.URL[7:]
+	c.Site = ts.URL
+
+	if err := c.Send(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Messages.Len() != 0 {
+		t.Errorf("Expected messages to be reset, got '%s'", c.Messages.String())
+	}
+}
+
+func TestSendRetry(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		responses []int
+		wantErr   string
+		wantWaits []time.Duration
+	}{
+		{name: "rate limited", responses: []int{429, 200}, wantWaits: []time.Duration{5 * time.Second}},
+		{name: "server errors", responses: []int{500, 503, 200}, wantWaits: []time.Duration{time.Second, 2 * time.Second}},
+		{name: "gives up", responses: []int{502, 502, 502}, wantErr: "502 Bad Gateway after 3 attempts: oops", wantWaits: []time.Duration{time.Second, 2 * time.Second}},
+		{name: "client error", responses: []int{401}, wantErr: "401 Unauthorized: oops"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.responses[requests] == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", "5")
+				}
+				w.WriteHeader(tc.responses[requests])
+				_, _ = w.Write([]byte("oops"))
+				requests++
+			}))
+			defer ts.Close()
+
+			c := NewChatwork("token", "room", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+			c.Site = ts.URL
+			c.MaxRetries = 2
+			var waits []time.Duration
+			c.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+			c.AddMessage("test")
+			err := c.Send()
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.wantErr)
+			}
+			require.Equal(t, len(tc.responses), requests)
+			require.Equal(t, tc.wantWaits, waits)
+		})
+	}
+}
+
+func TestSendRetryNetworkError(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			// Drop the connection without answering.
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			_ = conn.Close()
+			return
+		}
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	c := NewChatwork("token", "room", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	c.Site = ts.URL
+	var waits []time.Duration
+	c.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	c.AddMessage("test")
+	require.NoError(t, c.Send())
+	require.Equal(t, 2, requests)
+	require.Equal(t, []time.Duration{time.Second}, waits)
+
+	// The error is returned once the retries are used up.
+	ts.Close()
+	c.MaxRetries = 1
+	waits = nil
+	c.AddMessage("test")
+	require.ErrorContains(t, c.Send(), "after 2 attempts: ")
+	require.Equal(t, []time.Duration{time.Second}, waits)
+}
+
+func TestSendSplitsLongMessages(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		bodies = append(bodies, r.PostForm.Get("body"))
+	}))
+	defer ts.Close()
+
+	c := NewChatwork("token", "room", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	c.Site = ts.URL
+	c.MaxMessageLength = 10
+	c.AddMessage("開始しました\nfirst\nsecond line\n")
+	require.NoError(t, c.Send())
+	require.Equal(t, []string{"開始しました\n", "first\n", "second lin", "e\n"}, bodies)
+}
+
+func TestSendWithoutTokenOrRoom(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer ts.Close()
+
+	for _, c := range []*Chatwork{
+		NewChatwork("", "room", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) }),
+		NewChatwork("token", "", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) }),
+	} {
+		c.Site = ts.URL
+		c.AddMessage("test")
+		require.NoError(t, c.Send())
+		require.Zero(t, c.Messages.Len())
+	}
+	require.Zero(t, requests)
+
+	var c *Chatwork
+	require.NoError(t, c.Send())
+}
+
+func TestRetryAfter(t *testing.T) {
+	require.Equal(t, 3*time.Second, retryAfter("3", time.Second))
+	require.Equal(t, time.Second, retryAfter("", time.Second))
+	require.Equal(t, time.Duration(0), retryAfter(time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), time.Second))
+	require.InDelta(t, float64(time.Minute), float64(retryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), time.Second)), float64(2*time.Second))
+}
+
+func TestChatworkMarkup(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		bodies = append(bodies, r.PostForm.Get("body"))
+	}))
+	defer ts.Close()
+
+	c := NewChatwork("token", "room", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	c.Site = ts.URL
+	c.Mentions = map[string][]string{
+		"*":       {"100"},
+		"fluent":  {"200", "100"},
+		"ingress": {"300"},
+	}
+	run := report.NewRun("test-cluster")
+
+	ingress := run.StartCheck("ingress", "")
+	c.Step(run, ingress, "Record is available")
+	ingress.Finish(nil)
+	c.Result(run, ingress)
+
+	fluent := run.StartCheck("fluent", "")
+	fluent.Finish(errors.New("no logs in s3"))
+	c.Result(run, fluent)
 
-	c.Send()
+	require.Equal(t, []string{
+		"[info][title]✅ ingress check passed in test-cluster[/title]Record is available[/info]",
+		"[To:100][To:200]\n[info][title]❌ fluent check failed in test-cluster[/title]Error: no logs in s3[/info]",
+	}, bodies)
 }
@@ -0,0 +1,285 @@
+package notify
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+// Notifier receives the progress and results of a run.
+// check is nil for messages about the run as a whole, e.g. when it is interrupted.
+type Notifier interface {
+	// Start is called when a check starts.
+	Start(run *report.Run, check *report.CheckResult)
+	// Step reports the progress of a check.
+	Step(run *report.Run, check *report.CheckResult, message string)
+	// Result is called when a check has finished.
+	Result(run *report.Run, check *report.CheckResult)
+	// Summary is called once at the end of the run, or of the soak test.
+	Summary(run *report.Run)
+}
+
+// Discard is a Notifier that drops everything.
+var Discard Notifier = discard{}
+
+type discard struct{}
+
+func (discard) Start(*report.Run, *report.CheckResult)        {}
+func (discard) Step(*report.Run, *report.CheckResult, string) {}
+func (discard) Result(*report.Run, *report.CheckResult)       {}
+func (discard) Summary(*report.Run)                           {}
+
+// Policy decides which notifications a sink of a FanOut gets.
+type Policy string
+
+const (
+	// PolicyAlways notifies everything.
+	PolicyAlways Policy = "always"
+	// PolicyOnFailure only notifies failed checks and runs, as Filter.FailuresOnly.
+	PolicyOnFailure Policy = "on-failure"
+	// PolicyOnChange only notifies checks that failed after passing in the
+	// previous run, or the other way around. See report.Run.Changed.
+	PolicyOnChange Policy = "on-change"
+	// PolicyDigest only notifies the digests of the runs. See report.State.
+	PolicyDigest Policy = "digest"
+)
+
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case PolicyAlways, PolicyOnFailure, PolicyOnChange, PolicyDigest:
+		return p, nil
+	case "":
+		return PolicyAlways, nil
+	}
+	return "", fmt.Errorf("unknown notification policy %q, valid ones are: always, on-failure, on-change, digest", s)
+}
+
+// QuietHours is a daily period, from Start to End o'clock in Location,
+// e.g. 22 to 7, in Asia/Tokyo if Location is nil like the times in messages.
+// It is empty if Start equals End.
+type QuietHours struct {
+	Start, End int
+	Location   *time.Location
+}
+
+func (q *QuietHours) Contains(t time.Time) bool {
+	if q == nil || q.Start == q.End {
+		return false
+	}
+	location := q.Location
+	if location == nil {
+		location = defaultLocation()
+	}
+	h := t.In(location).Hour()
+	if q.Start < q.End {
+		return q.Start <= h && h < q.End
+	}
+	return h >= q.Start || h < q.End
+}
+
+// Filter selects what a sink of a FanOut is notified of.
+type Filter struct {
+	// Checks limits the notifications to these checks. All checks if empty.
+	Checks []string
+	// FailuresOnly drops everything but failed checks and runs.
+	// Starts and steps are dropped too, as they come before the outcome is known.
+	FailuresOnly bool
+	// Policy is PolicyAlways if empty. Like FailuresOnly, PolicyOnFailure and
+	// PolicyOnChange drop starts and steps of checks.
+	Policy Policy
+	// QuietHours drops everything but the failures of Critical checks while it lasts.
+	// Digests are sent anyway.
+	QuietHours *QuietHours
+	Critical   []string
+
+	// now returns the current time. time.Now if nil.
+	now func() time.Time
+}
+
+func (f Filter) allows(check *report.CheckResult) bool {
+	if check == nil || len(f.Checks) == 0 {
+		return true
+	}
+	return contains(f.Checks, check.Name)
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Filter) policy() Policy {
+	if f.FailuresOnly && (f.Policy == "" || f.Policy == PolicyAlways) {
+		return PolicyOnFailure
+	}
+	if f.Policy == "" {
+		return PolicyAlways
+	}
+	return f.Policy
+}
+
+func (f Filter) quiet() bool {
+	now := time.Now
+	if f.now != nil {
+		now = f.now
+	}
+	return f.QuietHours.Contains(now())
+}
+
+// progress tells whether the start or a step of check is to be notified.
+func (f Filter) progress(check *report.CheckResult) bool {
+	if !f.allows(check) || f.quiet() {
+		return false
+	}
+	switch f.policy() {
+	case PolicyAlways:
+		return true
+	case PolicyDigest:
+		return false
+	}
+	// Messages about the run as a whole, e.g. when it is interrupted, are failures.
+	return check == nil
+}
+
+func (f Filter) result(run *report.Run, check *report.CheckResult) bool {
+	if !f.allows(check) {
+		return false
+	}
+	if f.quiet() && !(check.Status == report.StatusFailed && contains(f.Critical, check.Name)) {
+		return false
+	}
+	switch f.policy() {
+	case PolicyOnFailure:
+		return check.Status == report.StatusFailed
+	case PolicyOnChange:
+		return run.Changed(check)
+	case PolicyDigest:
+		return false
+	}
+	return true
+}
+
+func (f Filter) summary(run *report.Run) bool {
+	if run != nil && run.Digest != nil {
+		return f.policy() == PolicyDigest
+	}
+	if f.quiet() && !f.criticalFailure(run) {
+		return false
+	}
+	switch f.policy() {
+	case PolicyOnFailure:
+		return failed(run)
+	case PolicyOnChange:
+		return changed(run)
+	case PolicyDigest:
+		return false
+	}
+	return true
+}
+
+// resolves tells whether the passed result of check is to be notified to a sink resolving
+// alerts, whatever the policy and quiet hours: it resolves the alert of an earlier failure,
+// unless check is known to have passed in the previous run.
+func (f Filter) resolves(run *report.Run, check *report.CheckResult) bool {
+	if !f.allows(check) || check.Status != report.StatusPassed {
+		return false
+	}
+	previous, ok := run.Previous[check.Name]
+	return !ok || previous != report.StatusPassed
+}
+
+func (f Filter) criticalFailure(run *report.Run) bool {
+	if run == nil {
+		return false
+	}
+	for _, c := range run.Checks {
+		if c.Status == report.StatusFailed && contains(f.Critical, c.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolver is implemented by the sinks that resolve their alerts when the check passes again,
+// such as PagerDuty and Opsgenie, so that their filters never hold back a resolve.
+type resolver interface {
+	resolvesAlerts()
+}
+
+// Sink is a destination of a FanOut.
+type Sink struct {
+	Notifier Notifier
+	Filter   Filter
+}
+
+// FanOut dispatches to all its sinks that the notification passes the filter of.
+type FanOut []Sink
+
+func (f FanOut) Start(run *report.Run, check *report.CheckResult) {
+	for _, s := range f {
+		if s.Filter.progress(check) {
+			s.Notifier.Start(run, check)
+		}
+	}
+}
+
+func (f FanOut) Step(run *report.Run, check *report.CheckResult, message string) {
+	for _, s := range f {
+		if s.Filter.progress(check) {
+			s.Notifier.Step(run, check, message)
+		}
+	}
+}
+
+func (f FanOut) Result(run *report.Run, check *report.CheckResult) {
+	for _, s := range f {
+		_, resolver := s.Notifier.(resolver)
+		if s.Filter.result(run, check) || (resolver && s.Filter.resolves(run, check)) {
+			s.Notifier.Result(run, check)
+		}
+	}
+}
+
+func (f FanOut) Summary(run *report.Run) {
+	for _, s := range f {
+		if s.Filter.summary(run) {
+			s.Notifier.Summary(run)
+		}
+	}
+}
+
+// NeedsState tells whether a sink has a policy that compares runs, PolicyOnChange
+// or PolicyDigest, which can't work without the state of the previous runs.
+func (f FanOut) NeedsState() bool {
+	for _, s := range f {
+		if p := s.Filter.policy(); p == PolicyOnChange || p == PolicyDigest {
+			return true
+		}
+	}
+	return false
+}
+
+func failed(run *report.Run) bool {
+	if run == nil {
+		return false
+	}
+	return run.Status() == report.StatusFailed || (run.Soak != nil && run.Soak.Failed > 0)
+}
+
+// changed tells whether a check of run changed outcome since the previous run.
+func changed(run *report.Run) bool {
+	if run == nil {
+		return false
+	}
+	for _, c := range run.Checks {
+		if run.Changed(c) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package notify
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+type recorder struct {
+	events []string
+}
+
+func (r *recorder) Start(run *report.Run, check *report.CheckResult) {
+	r.events = append(r.events, "start "+check.Name)
+}
+
+func (r *recorder) Step(run *report.Run, check *report.CheckResult, message string) {
+	r.events = append(r.events, "step "+message)
+}
+
+func (r *recorder) Result(run *report.Run, check *report.CheckResult) {
+	r.events = append(r.events, "result "+check.Name+" "+string(check.Status))
+}
+
+func (r *recorder) Summary(run *report.Run) {
+	r.events = append(r.events, "summary "+string(run.Status()))
+}
+
+func TestFanOut(t *testing.T) {
+	all, fluent, failures := &recorder{}, &recorder{}, &recorder{}
+	n := FanOut{
+		{Notifier: all},
+		{Notifier: fluent, Filter: Filter{Checks: []string{"fluent"}}},
+		{Notifier: failures, Filter: Filter{FailuresOnly: true}},
+	}
+
+	run := report.NewRun("test")
+	for _, c := range []struct {
+		name string
+		err  error
+	}{{"ingress", nil}, {"fluent", errors.New("no logs")}} {
+		check := run.StartCheck(c.name, "")
+		n.Start(run, check)
+		n.Step(run, check, c.name+" progress")
+		check.Finish(c.err)
+		n.Result(run, check)
+	}
+	n.Step(run, nil, "interrupted")
+	n.Summary(run)
+
+	require.Equal(t, []string{
+		"start ingress", "step ingress progress", "result ingress passed",
+		"start fluent", "step fluent progress", "result fluent failed",
+		"step interrupted", "summary failed",
+	}, all.events)
+	require.Equal(t, []string{
+		"start fluent", "step fluent progress", "result fluent failed",
+		"step interrupted", "summary failed",
+	}, fluent.events)
+	require.Equal(t, []string{"result fluent failed", "step interrupted", "summary failed"}, failures.events)
+}
+
+func TestChatworkNotifier(t *testing.T) {
+	c := NewChatwork("token", "room", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	run := report.NewRun("test-cluster")
+	check := run.StartCheck("ingress", "ingress-test")
+
+	var n Notifier = c
+	n.Start(run, check)
+	n.Step(run, check, "Record is available: 192.0.2.1")
+
+	lines := strings.Split(strings.TrimSpace(c.Messages.String()), "\n")
+	require.Len(t, lines, 4)
+	require.True(t, strings.HasPrefix(lines[0], "Start in test-cluster at "), lines[0])
+	require.Equal(t, []string{
+		"ingress check start",
+		"ingress check application Namespace: ingress-test",
+		"Record is available: 192.0.2.1",
+	}, lines[1:])
+
+	// Summary only sends soak test summaries.
+	n.Summary(run)
+	require.Len(t, strings.Split(strings.TrimSpace(c.Messages.String()), "\n"), 4)
+}
+
+func TestPolicies(t *testing.T) {
+	onChange, digest, quiet := &recorder{}, &recorder{}, &recorder{}
+	night := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+	n := FanOut{
+		{Notifier: onChange, Filter: Filter{Policy: PolicyOnChange}},
+		{Notifier: digest, Filter: Filter{Policy: PolicyDigest}},
+		{Notifier: quiet, Filter: Filter{
+			QuietHours: &QuietHours{Start: 22, End: 7, Location: time.UTC},
+			Critical:   []string{"ingress"},
+			now:        func() time.Time { return night },
+		}},
+	}
+
+	run := report.NewRun("test")
+	run.Previous = map[string]report.Status{"ingress": report.StatusPassed, "fluent": report.StatusFailed}
+	for _, c := range []struct {
+		name string
+		err  error
+	}{{"ingress", errors.New("no address")}, {"fluent", nil}, {"datadog-agent", nil}, {"cert-manager", errors.New("not ready")}} {
+		check := run.StartCheck(c.name, "")
+		n.Start(run, check)
+		n.Step(run, check, c.name+" progress")
+		check.Finish(c.err)
+		n.Result(run, check)
+	}
+	n.Summary(run)
+
+	digestRun := report.NewRun("test")
+	digestRun.Digest = &report.Digest{}
+	n.Summary(digestRun)
+
+	require.Equal(t, []string{
+		"result ingress failed", "result fluent passed", "result cert-manager failed", "summary failed",
+	}, onChange.events)
+	require.Equal(t, []string{"summary skipped"}, digest.events)
+	require.Equal(t, []string{"result ingress failed", "summary failed"}, quiet.events)
+}
+
+func TestQuietHours(t *testing.T) {
+	at := func(hour int) time.Time { return time.Date(2024, 1, 1, hour, 30, 0, 0, time.UTC) }
+
+	overnight := &QuietHours{Start: 22, End: 7, Location: time.UTC}
+	require.True(t, overnight.Contains(at(23)))
+	require.True(t, overnight.Contains(at(6)))
+	require.False(t, overnight.Contains(at(7)))
+	require.False(t, overnight.Contains(at(12)))
+
+	lunch := &QuietHours{Start: 12, End: 13, Location: time.UTC}
+	require.True(t, lunch.Contains(at(12)))
+	require.False(t, lunch.Contains(at(13)))
+
+	// Hours are those of Location, whatever the time zone of the time.
+	tokyo := time.FixedZone("JST", 9*60*60)
+	night := &QuietHours{Start: 22, End: 7, Location: tokyo}
+	utc := func(hour, min int) time.Time { return time.Date(2024, 1, 1, hour, min, 0, 0, time.UTC) }
+	require.False(t, night.Contains(utc(12, 59))) // 21:59 in Tokyo
+	require.True(t, night.Contains(utc(13, 0)))   // 22:00
+	require.True(t, night.Contains(utc(21, 59)))  // 6:59
+	require.False(t, night.Contains(utc(22, 0)))  // 7:00
+
+	var none *QuietHours
+	require.False(t, none.Contains(at(23)))
+	require.False(t, (&QuietHours{Start: 3, End: 3}).Contains(at(3)))
+}
+
+func TestNeedsState(t *testing.T) {
+	require.False(t, FanOut{{Filter: Filter{}}, {Filter: Filter{Policy: PolicyOnFailure}}}.NeedsState())
+	require.True(t, FanOut{{Filter: Filter{}}, {Filter: Filter{Policy: PolicyOnChange}}}.NeedsState())
+	require.True(t, FanOut{{Filter: Filter{Policy: PolicyDigest}}}.NeedsState())
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("")
+	require.NoError(t, err)
+	require.Equal(t, PolicyAlways, p)
+
+	p, err = ParsePolicy("on-change")
+	require.NoError(t, err)
+	require.Equal(t, PolicyOnChange, p)
+
+	_, err = ParsePolicy("sometimes")
+	require.EqualError(t, err, `unknown notification policy "sometimes", valid ones are: always, on-failure, on-change, digest`)
+}
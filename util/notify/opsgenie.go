@@ -0,0 +1,142 @@
+package notify
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+// DefaultOpsgenieAPIURL is the base URL of the Opsgenie API.
+// Accounts in the EU use https://api.eu.opsgenie.com instead.
+const DefaultOpsgenieAPIURL = "https://api.opsgenie.com"
+
+// opsgeniePriority maps severities to Opsgenie priorities.
+var opsgeniePriority = map[string]string{
+	SeverityCritical: "P1",
+	SeverityError:    "P2",
+	SeverityWarning:  "P3",
+	SeverityInfo:     "P5",
+}
+
+// Opsgenie creates an alert when a check fails and closes it when the check
+// passes again, with one alert per cluster and check.
+type Opsgenie struct {
+	APIKey string
+	// APIURL defaults to DefaultOpsgenieAPIURL.
+	APIURL   string
+	Severity Severity
+	// ReportURL is where the HTML report of the run is published, if anywhere.
+	ReportURL string
+	// Templates, if set, render the alerts with Failure: its first line is their
+	// message and the rest their description. The built-in ones are used if it
+	// is missing or renders nothing.
+	Templates  *Templates
+	HTTPClient *http.Client
+	Logger     func() *logrus.Entry
+}
+
+func NewOpsgenie(apiKey string, logger func() *logrus.Entry) *Opsgenie {
+	return &Opsgenie{
+		APIKey:     apiKey,
+		APIURL:     DefaultOpsgenieAPIURL,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Logger:     logger,
+	}
+}
+
+type opsgenieAlert struct {
+	Message     string            `json:"message"`
+	Alias       string            `json:"alias"`
+	Description string            `json:"description"`
+	Tags        []string          `json:"tags"`
+	Details     map[string]string `json:"details"`
+	Entity      string            `json:"entity"`
+	Source      string            `json:"source"`
+	Priority    string            `json:"priority,omitempty"`
+}
+
+func (o *Opsgenie) resolvesAlerts() {}
+
+func (o *Opsgenie) Start(run *report.Run, check *report.CheckResult)                {}
+func (o *Opsgenie) Step(run *report.Run, check *report.CheckResult, message string) {}
+
+// Summary creates or closes the alerts of the checks of a soak test,
+// of which no result is notified.
+func (o *Opsgenie) Summary(run *report.Run) {
+	for _, check := range soakResults(run) {
+		o.Result(run, check)
+	}
+}
+
+// Result creates an alert for failed checks and closes it for passed ones.
+// Skipped checks leave it as it is.
+func (o *Opsgenie) Result(run *report.Run, check *report.CheckResult) {
+	var err error
+	switch check.Status {
+	case report.StatusFailed:
+		err = o.create(run, check)
+	case report.StatusPassed:
+		err = o.close(run, check)
+	default:
+		return
+	}
+	if err != nil {
+		o.Logger().Errorf("Error sending %s alert to Opsgenie: %s", check.Name, err)
+	}
+}
+
+func (o *Opsgenie) create(run *report.Run, check *report.CheckResult) error {
+	d := newAlertDetails(run, check, o.ReportURL)
+	details := map[string]string{
+		"cluster": d.Cluster,
+		"check":   d.Check,
+		"run_id":  d.RunID,
+		"reason":  d.Reason,
+	}
+	for k, v := range map[string]string{"namespace": d.Namespace, "step": d.Step, "report": d.Report} {
+		if v != "" {
+			details[k] = v
+		}
+	}
+
+	message := d.summary()
+	description := d.Error
+	if len(d.Collected) > 0 {
+		description += "\n\nDiagnostics:\n" + strings.Join(d.Collected, "\n")
+	}
+	if o.Templates != nil {
+		if text, ok := renderOverride(o.Templates.Failure, TemplateData{Run: run, Check: check}, o.Logger); ok {
+			message, description, _ = strings.Cut(strings.TrimSpace(text), "\n")
+			description = strings.TrimSpace(description)
+		}
+	}
+
+	return postJSON(o.HTTPClient, strings.TrimSuffix(o.APIURL, "/")+"/v2/alerts", o.header(), opsgenieAlert{
+		Message:     truncate(message, 130),
+		Alias:       dedupKey(run, check),
+		Description: truncate(description, 15000),
+		Tags:        []string{"kibertas", run.ClusterName, check.Name},
+		Details:     details,
+		Entity:      run.ClusterName,
+		Source:      "kibertas",
+		Priority:    opsgeniePriority[o.Severity.of(check.Name)],
+	})
+}
+
+func (o *Opsgenie) close(run *report.Run, check *report.CheckResult) error {
+	u := fmt.Sprintf("%s/v2/alerts/%s/close?identifierType=alias", strings.TrimSuffix(o.APIURL, "/"), url.PathEscape(dedupKey(run, check)))
+	return postJSON(o.HTTPClient, u, o.header(), map[string]string{
+		"source": "kibertas",
+		"note":   fmt.Sprintf("%s passed in run %s", check.Name, run.ID),
+	})
+}
+
+func (o *Opsgenie) header() http.Header {
+	return http.Header{"Authorization": {"GenieKey " + o.APIKey}}
+}
@@ -0,0 +1,130 @@
+package notify
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+// DefaultPagerDutyURL is the endpoint of the PagerDuty Events API v2.
+const DefaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDuty triggers an incident when a check fails and resolves it when the
+// check passes again, with one incident per cluster and check.
+type PagerDuty struct {
+	// RoutingKey is the integration key of the PagerDuty service.
+	RoutingKey string
+	// URL defaults to DefaultPagerDutyURL.
+	URL      string
+	Severity Severity
+	// ReportURL is where the HTML report of the run is published, if anywhere.
+	ReportURL string
+	// Templates, if set, render the summary of incidents with Failure.
+	// The built-in one is used if it is missing or renders nothing.
+	Templates  *Templates
+	HTTPClient *http.Client
+	Logger     func() *logrus.Entry
+}
+
+func NewPagerDuty(routingKey string, logger func() *logrus.Entry) *PagerDuty {
+	return &PagerDuty{
+		RoutingKey: routingKey,
+		URL:        DefaultPagerDutyURL,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Logger:     logger,
+	}
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+	Links       []pagerDutyLink   `json:"links,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string       `json:"summary"`
+	Source        string       `json:"source"`
+	Severity      string       `json:"severity"`
+	Timestamp     time.Time    `json:"timestamp"`
+	Component     string       `json:"component"`
+	Group         string       `json:"group,omitempty"`
+	Class         string       `json:"class,omitempty"`
+	CustomDetails alertDetails `json:"custom_details"`
+}
+
+type pagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+func (p *PagerDuty) resolvesAlerts() {}
+
+func (p *PagerDuty) Start(run *report.Run, check *report.CheckResult)                {}
+func (p *PagerDuty) Step(run *report.Run, check *report.CheckResult, message string) {}
+
+// Summary triggers or resolves the incidents of the checks of a soak test,
+// of which no result is notified.
+func (p *PagerDuty) Summary(run *report.Run) {
+	for _, check := range soakResults(run) {
+		p.Result(run, check)
+	}
+}
+
+// Result triggers an incident for failed checks and resolves it for passed ones.
+// Skipped checks leave it as it is.
+func (p *PagerDuty) Result(run *report.Run, check *report.CheckResult) {
+	event := pagerDutyEvent{
+		RoutingKey: p.RoutingKey,
+		DedupKey:   dedupKey(run, check),
+	}
+	switch check.Status {
+	case report.StatusFailed:
+		details := newAlertDetails(run, check, p.ReportURL)
+		summary := details.summary()
+		if p.Templates != nil {
+			if text, ok := renderOverride(p.Templates.Failure, TemplateData{Run: run, Check: check}, p.Logger); ok {
+				summary = strings.TrimSpace(text)
+			}
+		}
+		event.EventAction = "trigger"
+		event.Payload = &pagerDutyPayload{
+			Summary:       truncate(summary, 1024),
+			Source:        run.ClusterName,
+			Severity:      p.Severity.of(check.Name),
+			Timestamp:     check.FinishedAt,
+			Component:     check.Name,
+			Group:         run.ClusterName,
+			Class:         details.Step,
+			CustomDetails: details,
+		}
+		if details.Report != "" {
+			event.Links = []pagerDutyLink{{Href: details.Report, Text: "kibertas report"}}
+		}
+	case report.StatusPassed:
+		event.EventAction = "resolve"
+	default:
+		return
+	}
+
+	if err := postJSON(p.HTTPClient, p.URL, nil, event); err != nil {
+		p.Logger().Errorf("Error sending %s event to PagerDuty: %s", event.EventAction, err)
+	}
+}
+
+// truncate shortens s to n characters, ending with "..." when it cut something.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	if n <= 3 {
+		return string(runes[:max(n, 0)])
+	}
+	return string(runes[:n-3]) + "..."
+}
@@ -0,0 +1,379 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+// DefaultSlackAPIURL is the base URL of the Slack Web API.
+const DefaultSlackAPIURL = "https://slack.com/api"
+
+// slackTextLimit is the maximum length of the text of a section block, in characters.
+const slackTextLimit = 3000
+
+// Slack posts a summary of the run with a section per check, and the details
+// of each failure in a thread under it.
+//
+// It posts through WebhookURL if set, otherwise with chat.postMessage using
+// Token and Channel. Incoming webhooks can't start threads, so the failure
+// details are posted as separate messages instead.
+type Slack struct {
+	WebhookURL string
+	Token      string
+	Channel    string
+	// APIURL defaults to DefaultSlackAPIURL.
+	APIURL string
+	// ReportURL is where the HTML report of the run is published, if anywhere.
+	// Failure details link to the section of the failed check.
+	ReportURL string
+	// Location and TimeFormat are those of the times in messages.
+	// Asia/Tokyo and "2006-01-02 15:04:05 MST" if unset.
+	Location   *time.Location
+	TimeFormat string
+	// Templates, if set, render the summary with Summary, or Live in live mode,
+	// and the failure details with Failure. The built-in format is used for
+	// those that are missing or render nothing, and the others are unused.
+	Templates  *Templates
+	HTTPClient *http.Client
+	// Live posts the summary when the run starts and updates it as the checks
+	// progress. It requires Token and Channel, as webhook messages can't be updated.
+	Live bool
+	// MinUpdateInterval is the minimum time between the updates made for steps.
+	MinUpdateInterval time.Duration
+	Logger            func() *logrus.Entry
+
+	// live is the message updated in live mode.
+	live *slackLive
+}
+
+type slackLive struct {
+	runID string
+	ts    string
+	// latest has the last step message of each check.
+	latest  map[string]string
+	updated time.Time
+}
+
+func NewSlack(webhookURL, token, channel string, logger func() *logrus.Entry) *Slack {
+	return &Slack{
+		WebhookURL: webhookURL,
+		Token:      token,
+		Channel:    channel,
+		APIURL:     DefaultSlackAPIURL,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		// chat.update is rate limited to about one request per second.
+		MinUpdateInterval: 5 * time.Second,
+		Logger:            logger,
+	}
+}
+
+type slackMessage struct {
+	Channel  string       `json:"channel,omitempty"`
+	Text     string       `json:"text"`
+	ThreadTS string       `json:"thread_ts,omitempty"`
+	TS       string       `json:"ts,omitempty"`
+	Blocks   []slackBlock `json:"blocks,omitempty"`
+}
+
+type slackBlock struct {
+	Type     string      `json:"type"`
+	Text     *slackText  `json:"text,omitempty"`
+	Elements []slackText `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func mrkdwn(text string) *slackText {
+	return &slackText{Type: "mrkdwn", Text: truncate(text, slackTextLimit)}
+}
+
+// Start, Step and Result do nothing unless Live is set: Slack only gets the
+// summary, to keep the channel readable.
+func (s *Slack) Start(run *report.Run, check *report.CheckResult) {
+	if s.liveEnabled() {
+		s.updateLive(run, true)
+	}
+}
+
+func (s *Slack) Step(run *report.Run, check *report.CheckResult, message string) {
+	if !s.liveEnabled() || check == nil {
+		return
+	}
+	s.liveMessage(run).latest[check.Name] = message
+	s.updateLive(run, false)
+}
+
+func (s *Slack) Result(run *report.Run, check *report.CheckResult) {
+	if s.liveEnabled() {
+		s.updateLive(run, true)
+	}
+}
+
+func (s *Slack) Summary(run *report.Run) {
+	var ts string
+	var err error
+	if s.live != nil && s.live.runID == run.ID && s.live.ts != "" {
+		ts = s.live.ts
+		err = s.update(ts, s.summaryMessage(run))
+	} else {
+		ts, err = s.post(s.summaryMessage(run))
+	}
+	if err != nil {
+		s.Logger().Errorf("Error posting to Slack: %s", err)
+		return
+	}
+	for _, c := range run.Checks {
+		if c.Status != report.StatusFailed {
+			continue
+		}
+		msg := s.failureMessage(run, c)
+		msg.ThreadTS = ts
+		if _, err := s.post(msg); err != nil {
+			s.Logger().Errorf("Error posting to Slack: %s", err)
+		}
+	}
+}
+
+var statusEmoji = map[report.Status]string{
+	report.StatusPassed:  ":white_check_mark:",
+	report.StatusFailed:  ":x:",
+	report.StatusSkipped: ":fast_forward:",
+	report.StatusRunning: ":hourglass:",
+}
+
+func (s *Slack) format(t time.Time) string {
+	location := s.Location
+	if location == nil {
+		location = defaultLocation()
+	}
+	format := s.TimeFormat
+	if format == "" {
+		format = "2006-01-02 15:04:05 MST"
+	}
+	return t.In(location).Format(format)
+}
+
+func (s *Slack) summaryMessage(run *report.Run) slackMessage {
+	if text, ok := s.renderSummary(run); ok {
+		return slackMessage{Text: text, Blocks: []slackBlock{{Type: "section", Text: mrkdwn(text)}}}
+	}
+	if run.Digest != nil {
+		return s.digestMessage(run)
+	}
+	title := fmt.Sprintf("kibertas %s in %s", run.Status(), run.ClusterName)
+	msg := slackMessage{
+		Text: title,
+		Blocks: []slackBlock{
+			{Type: "header", Text: &slackText{Type: "plain_text", Text: title}},
+			{Type: "context", Elements: []slackText{*mrkdwn(fmt.Sprintf("Run %s, started %s, took %s", run.ID, s.format(run.StartedAt), run.Duration().Round(time.Second)))}},
+		},
+	}
+	for _, c := range run.Checks {
+		text := fmt.Sprintf("%s *%s* %s in %s", statusEmoji[c.Status], c.Name, c.Status, c.Duration().Round(time.Second))
+		if c.Message != "" {
+			text += "\n" + c.Message
+		} else if latest := s.live.latestOf(run, c); latest != "" {
+			text += "\n" + latest
+		}
+		msg.Blocks = append(msg.Blocks, slackBlock{Type: "section", Text: mrkdwn(text)})
+	}
+	if run.Soak != nil {
+		msg.Blocks = append(msg.Blocks, slackBlock{Type: "section", Text: mrkdwn("*Soak test*\n```" + run.Soak.String() + "```")})
+	}
+	return msg
+}
+
+func (s *Slack) digestMessage(run *report.Run) slackMessage {
+	d := run.Digest
+	title := fmt.Sprintf("kibertas digest of %s", run.ClusterName)
+	return slackMessage{
+		Text: title,
+		Blocks: []slackBlock{
+			{Type: "header", Text: &slackText{Type: "plain_text", Text: title}},
+			{Type: "context", Elements: []slackText{*mrkdwn(fmt.Sprintf("From %s to %s", s.format(d.Since), s.format(d.Until)))}},
+			{Type: "section", Text: mrkdwn("```" + d.String() + "```")},
+		},
+	}
+}
+
+// renderSummary renders the summary of run with Templates, if set.
+func (s *Slack) renderSummary(run *report.Run) (string, bool) {
+	if s.Templates == nil {
+		return "", false
+	}
+	if s.liveEnabled() && s.Templates.Live != nil {
+		var latest map[string]string
+		if s.live != nil && s.live.runID == run.ID {
+			latest = s.live.latest
+		}
+		return renderOverride(s.Templates.Live, TemplateData{Run: run, Latest: latest}, s.Logger)
+	}
+	return renderOverride(s.Templates.Summary, TemplateData{Run: run}, s.Logger)
+}
+
+func (s *Slack) failureMessage(run *report.Run, c *report.CheckResult) slackMessage {
+	if s.Templates != nil {
+		if text, ok := renderOverride(s.Templates.Failure, TemplateData{Run: run, Check: c}, s.Logger); ok {
+			return slackMessage{Text: text, Blocks: []slackBlock{{Type: "section", Text: mrkdwn(text)}}}
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, ":x: *%s* failed", c.Name)
+	if step := c.FailedStep(); step != nil {
+		fmt.Fprintf(&b, " at step *%s*", step.Name)
+	}
+	fmt.Fprintf(&b, "\n```%s```", c.Error)
+
+	var links []string
+	if s.ReportURL != "" {
+		links = append(links, fmt.Sprintf("<%s#check-%s|report>", s.ReportURL, c.Name))
+	}
+	var names []string
+	for _, a := range c.Artifacts {
+		if a.URL != "" {
+			links = append(links, fmt.Sprintf("<%s|%s>", a.URL, a.Name))
+		} else {
+			names = append(names, fmt.Sprintf("%s %s", a.Kind, a.Name))
+		}
+	}
+	if len(links) > 0 {
+		fmt.Fprintf(&b, "\nDiagnostics: %s", strings.Join(links, ", "))
+	}
+	if len(names) > 0 {
+		fmt.Fprintf(&b, "\nCollected: %s", strings.Join(names, ", "))
+	}
+
+	return slackMessage{
+		Text:   fmt.Sprintf("%s failed: %s", c.Name, c.Error),
+		Blocks: []slackBlock{{Type: "section", Text: mrkdwn(b.String())}},
+	}
+}
+
+func (s *Slack) liveEnabled() bool {
+	return s.Live && s.WebhookURL == "" && s.Token != "" && s.Channel != ""
+}
+
+func (s *Slack) liveMessage(run *report.Run) *slackLive {
+	if s.live == nil || s.live.runID != run.ID {
+		s.live = &slackLive{runID: run.ID, latest: map[string]string{}}
+	}
+	return s.live
+}
+
+// latestOf returns the last step message of the check while it is running.
+func (l *slackLive) latestOf(run *report.Run, c *report.CheckResult) string {
+	if l == nil || l.runID != run.ID || c.Status != report.StatusRunning {
+		return ""
+	}
+	return l.latest[c.Name]
+}
+
+// updateLive posts the summary of the run so far, or updates it if it was
+// already posted. Unless force is set, it is left as is if it was updated
+// less than MinUpdateInterval ago.
+func (s *Slack) updateLive(run *report.Run, force bool) {
+	live := s.liveMessage(run)
+	if !force && time.Since(live.updated) < s.MinUpdateInterval {
+		return
+	}
+	live.updated = time.Now()
+	msg := s.summaryMessage(run)
+	if live.ts != "" {
+		if err := s.update(live.ts, msg); err != nil {
+			s.Logger().Errorf("Error updating Slack message %s: %s", live.ts, err)
+		}
+		return
+	}
+	ts, err := s.post(msg)
+	if err != nil {
+		s.Logger().Errorf("Error posting to Slack: %s", err)
+		return
+	}
+	live.ts = ts
+}
+
+// update replaces the message at ts with chat.update.
+func (s *Slack) update(ts string, msg slackMessage) error {
+	msg.TS = ts
+	_, err := s.call("chat.update", msg)
+	return err
+}
+
+// post sends msg and returns its timestamp, which is empty for webhooks.
+func (s *Slack) post(msg slackMessage) (string, error) {
+	return s.call("chat.postMessage", msg)
+}
+
+// call sends msg with the Web API method, or through the webhook if set.
+func (s *Slack) call(method string, msg slackMessage) (string, error) {
+	url := s.WebhookURL
+	if url == "" {
+		if s.Token == "" || s.Channel == "" {
+			return "", errors.New("either a webhook URL, or a token and a channel are required")
+		}
+		url = strings.TrimSuffix(s.APIURL, "/") + "/" + method
+		msg.Channel = s.Channel
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if s.WebhookURL == "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	client := s.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", resp.Status, respBody)
+	}
+	if s.WebhookURL != "" {
+		return "", nil
+	}
+
+	// The Web API answers 200 with ok=false on errors.
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+		TS    string `json:"ts"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return "", fmt.Errorf("decoding response: %w", err)
+	}
+	if !result.OK {
+		return "", fmt.Errorf("%s: %s", method, result.Error)
+	}
+	return result.TS, nil
+}
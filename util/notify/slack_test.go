@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+func newSlackTestRun() *report.Run {
+	run := report.NewRun("test-cluster")
+	run.StartCheck("ingress", "").Finish(nil)
+	fluent := run.StartCheck("fluent", "fluent-test")
+	_ = fluent.Step("check s3 object", func() error { return errors.New("no logs in s3") })
+	fluent.AddArtifact(report.Artifact{Kind: report.KindEvents, Name: "events in fluent-test"})
+	fluent.Finish(errors.New("no logs in s3"))
+	run.Finish()
+	return run
+}
+
+func TestSlackPostMessage(t *testing.T) {
+	var messages []slackMessage
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/chat.postMessage", r.URL.Path)
+		require.Equal(t, "Bearer xoxb-token", r.Header.Get("Authorization"))
+
+		var msg slackMessage
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+		messages = append(messages, msg)
+		_, _ = w.Write([]byte(`{"ok":true,"ts":"1700000000.000100"}`))
+	}))
+	defer ts.Close()
+
+	s := NewSlack("", "xoxb-token", "#kibertas", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	s.APIURL = ts.URL + "/api"
+	s.ReportURL = "https://reports.example.com/run.html"
+	s.Summary(newSlackTestRun())
+
+	require.Len(t, messages, 2)
+
+	summary := messages[0]
+	require.Equal(t, "#kibertas", summary.Channel)
+	require.Equal(t, "kibertas failed in test-cluster", summary.Text)
+	require.Equal(t, "header", summary.Blocks[0].Type)
+	require.Len(t, summary.Blocks, 4)
+	require.True(t, strings.HasPrefix(summary.Blocks[2].Text.Text, ":white_check_mark: *ingress* passed in "))
+	require.True(t, strings.HasPrefix(summary.Blocks[3].Text.Text, ":x: *fluent* failed in "))
+
+	thread := messages[1]
+	require.Equal(t, "1700000000.000100", thread.ThreadTS)
+	text := thread.Blocks[0].Text.Text
+	require.Contains(t, text, ":x: *fluent* failed at step *check s3 object*")
+	require.Contains(t, text, "<https://reports.example.com/run.html#check-fluent|report>")
+	require.Contains(t, text, "Collected: events events in fluent-test")
+}
+
+func TestSlackWebhook(t *testing.T) {
+	var messages []slackMessage
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Empty(t, r.Header.Get("Authorization"))
+		var msg slackMessage
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+		messages = append(messages, msg)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	s := NewSlack(ts.URL, "", "", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	s.Summary(newSlackTestRun())
+
+	require.Len(t, messages, 2)
+	require.Empty(t, messages[0].Channel)
+	require.Empty(t, messages[1].ThreadTS)
+}
+
+func TestSlackError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ok":false,"error":"channel_not_found"}`))
+	}))
+	defer ts.Close()
+
+	s := NewSlack("", "xoxb-token", "#missing", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	s.APIURL = ts.URL
+	_, err := s.post(s.summaryMessage(newSlackTestRun()))
+	require.EqualError(t, err, "chat.postMessage: channel_not_found")
+}
+
+func TestSlackTextLimit(t *testing.T) {
+	text := mrkdwn(strings.Repeat("失敗", slackTextLimit)).Text
+	require.True(t, utf8.ValidString(text))
+	require.Equal(t, slackTextLimit, utf8.RuneCountInString(text))
+	require.True(t, strings.HasSuffix(text, "..."))
+}
+
+func TestSlackTemplates(t *testing.T) {
+	var messages []slackMessage
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg slackMessage
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+		messages = append(messages, msg)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	s := NewSlack(ts.URL, "", "", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	s.Templates = &Templates{
+		Summary: template.Must(template.New("summary").Parse(`{{.Run.ClusterName}} の結果: {{.Run.Status}}`)),
+		Failure: template.Must(template.New("failure").Parse(`{{.Check.Name}} が失敗しました: {{.Check.Error}}`)),
+	}
+	s.Summary(newSlackTestRun())
+
+	require.Len(t, messages, 2)
+	require.Equal(t, "test-cluster の結果: failed", messages[0].Text)
+	require.Len(t, messages[0].Blocks, 1)
+	require.Equal(t, "test-cluster の結果: failed", messages[0].Blocks[0].Text.Text)
+	require.Equal(t, "fluent が失敗しました: no logs in s3", messages[1].Blocks[0].Text.Text)
+
+	// The built-in format is used if a template renders nothing or fails.
+	messages = nil
+	s.Templates.Summary = template.Must(template.New("summary").Parse(`{{with .Run.Digest}}{{.}}{{end}}`))
+	s.Templates.Failure = template.Must(template.New("failure").Parse(`{{.Nope}}`))
+	s.Summary(newSlackTestRun())
+
+	require.Len(t, messages, 2)
+	require.Equal(t, "kibertas failed in test-cluster", messages[0].Text)
+	require.Contains(t, messages[1].Blocks[0].Text.Text, ":x: *fluent* failed at step *check s3 object*")
+}
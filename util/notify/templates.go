@@ -0,0 +1,235 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+// DefaultTimeFormat is how times are formatted in messages.
+const DefaultTimeFormat = "2006-01-02 15:04:05"
+
+// The default templates render Chatwork markup. The other sinks format their
+// messages themselves, unless given templates with LoadTemplateOverrides.
+const (
+	DefaultStartTemplate = `Start in {{.Run.ClusterName}} at {{time .Check.StartedAt}}
+{{.Check.Name}} check start
+{{with .Check.Namespace}}{{$.Check.Name}} check application Namespace: {{.}}
+{{end}}`
+	DefaultStepTemplate    = "{{.Message}}\n"
+	DefaultSuccessTemplate = `[info][title]{{marker .Check.Status}} {{.Check.Name}} check {{.Check.Status}} in {{.Run.ClusterName}}[/title]` +
+		`{{if .Check.Message}}{{lines .Messages (print "Reason: " .Check.Message)}}{{else}}{{lines .Messages}}{{end}}[/info]`
+	DefaultFailureTemplate = `{{to .Mentions}}[info][title]{{marker .Check.Status}} {{.Check.Name}} check failed in {{.Run.ClusterName}}[/title]` +
+		`{{lines .Messages (print "Error: " .Check.Error)}}[/info]`
+	DefaultLiveTemplate = `[info][title]{{if .Run.FinishedAt.IsZero}}⏳ kibertas running in {{.Run.ClusterName}} since {{time .Run.StartedAt}}` +
+		`{{else}}{{marker .Run.Status}} kibertas {{.Run.Status}} in {{.Run.ClusterName}} in {{duration .Run.Duration}}{{end}}[/title]` +
+		`{{range .Run.Checks}}{{marker .Status}} {{.Name}}{{if .Error}}: {{.Error}}{{else if eq .Status "running"}}{{with index $.Latest .Name}}: {{.}}{{end}}{{end}}
+{{end}}{{lines .Messages}}[/info]`
+	DefaultSummaryTemplate = `{{with .Run.Digest}}[info][title]📊 kibertas digest of {{$.Run.ClusterName}} since {{time .Since}}[/title][code]{{.}}[/code][/info]` +
+		`{{else}}{{with .Run.Soak}}{{to $.Mentions}}[info][title]{{if .Failed}}❌ Soak test in {{$.Run.ClusterName}} failed{{else}}✅ Soak test in {{$.Run.ClusterName}} passed{{end}}[/title][code]{{.}}[/code][/info]{{end}}{{end}}`
+)
+
+// Templates render the text of notifications with text/template.
+type Templates struct {
+	// Start renders the start of a check, and Step every message about its progress.
+	Start, Step *template.Template
+	// Success renders the result of checks that passed or were skipped,
+	// and Failure that of failed ones.
+	Success, Failure *template.Template
+	// Summary renders the summary of a run. Nothing is sent if it renders nothing.
+	Summary *template.Template
+	// Live renders the message edited in place in live mode.
+	Live *template.Template
+	// Location and TimeFormat are those of the time function. Asia/Tokyo and
+	// DefaultTimeFormat if unset.
+	Location   *time.Location
+	TimeFormat string
+}
+
+// TemplateData is what templates are executed with.
+type TemplateData struct {
+	Run *report.Run
+	// Check is nil for messages about the run as a whole.
+	Check *report.CheckResult
+	// Message is the message of a step.
+	Message string
+	// Messages are the start and step messages of the check, for its result.
+	Messages []string
+	// Mentions are the account IDs of the members to mention on failure.
+	Mentions []string
+	// Latest has the last step message of each check, by name, in live mode.
+	Latest map[string]string
+}
+
+// DefaultTemplates returns the templates used unless configured otherwise.
+func DefaultTemplates() *Templates {
+	t, err := LoadTemplates("")
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// LoadTemplates reads start.tmpl, step.tmpl, success.tmpl, failure.tmpl,
+// summary.tmpl and live.tmpl from dir, using the default template for missing files.
+//
+// Besides the text/template builtins, templates can call:
+//
+//	time      formats a time.Time in Location with TimeFormat
+//	duration  rounds a time.Duration to the second
+//	marker    returns an emoji for a report.Status
+//	lines     joins strings and lists of strings with newlines, skipping empty ones
+//	to        returns the Chatwork mentions of account IDs, on their own line
+//	json      renders its argument as JSON
+func LoadTemplates(dir string) (*Templates, error) {
+	return loadTemplates(dir, true)
+}
+
+// LoadTemplateOverrides reads the templates in dir as LoadTemplates, but leaves
+// those of the missing files nil, for sinks to use their own format instead.
+// It returns nil if dir is empty.
+func LoadTemplateOverrides(dir string) (*Templates, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	return loadTemplates(dir, false)
+}
+
+func loadTemplates(dir string, defaults bool) (*Templates, error) {
+	t := &Templates{}
+	for _, tmpl := range []struct {
+		name string
+		text string
+		dest **template.Template
+	}{
+		{"start", DefaultStartTemplate, &t.Start},
+		{"step", DefaultStepTemplate, &t.Step},
+		{"success", DefaultSuccessTemplate, &t.Success},
+		{"failure", DefaultFailureTemplate, &t.Failure},
+		{"summary", DefaultSummaryTemplate, &t.Summary},
+		{"live", DefaultLiveTemplate, &t.Live},
+	} {
+		text := tmpl.text
+		if dir != "" {
+			data, err := os.ReadFile(filepath.Join(dir, tmpl.name+".tmpl"))
+			switch {
+			case err == nil:
+				text = string(data)
+			case !errors.Is(err, os.ErrNotExist):
+				return nil, err
+			case !defaults:
+				continue
+			}
+		}
+		parsed, err := template.New(tmpl.name).Funcs(t.funcs()).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		*tmpl.dest = parsed
+	}
+	return t, nil
+}
+
+// defaultLocation is the time zone of the times in messages, unless configured otherwise.
+func defaultLocation() *time.Location {
+	location, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		return time.Local
+	}
+	return location
+}
+
+// Format formats t as the time function of the templates.
+func (t *Templates) Format(tm time.Time) string {
+	location := t.Location
+	if location == nil {
+		location = defaultLocation()
+	}
+	format := t.TimeFormat
+	if format == "" {
+		format = DefaultTimeFormat
+	}
+	return tm.In(location).Format(format)
+}
+
+func (t *Templates) funcs() template.FuncMap {
+	return template.FuncMap{
+		"time": t.Format,
+		"duration": func(d time.Duration) time.Duration {
+			return d.Round(time.Second)
+		},
+		"marker": func(s report.Status) string {
+			return chatworkMarker[s]
+		},
+		"lines": func(values ...interface{}) string {
+			var lines []string
+			for _, v := range values {
+				switch v := v.(type) {
+				case string:
+					lines = append(lines, v)
+				case []string:
+					lines = append(lines, v...)
+				default:
+					lines = append(lines, fmt.Sprint(v))
+				}
+			}
+			var nonEmpty []string
+			for _, l := range lines {
+				if l != "" {
+					nonEmpty = append(nonEmpty, l)
+				}
+			}
+			return strings.Join(nonEmpty, "\n")
+		},
+		"to": func(ids []string) string {
+			var b strings.Builder
+			seen := map[string]bool{}
+			for _, id := range ids {
+				if !seen[id] {
+					seen[id] = true
+					fmt.Fprintf(&b, "[To:%s]", id)
+				}
+			}
+			if b.Len() > 0 {
+				b.WriteString("\n")
+			}
+			return b.String()
+		},
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
+}
+
+// renderOverride renders tmpl if set, for the sinks that only use templates
+// given with LoadTemplateOverrides. It reports false if tmpl is nil, fails or
+// renders nothing, for them to fall back to their own format.
+func renderOverride(tmpl *template.Template, data TemplateData, logger func() *logrus.Entry) (string, bool) {
+	if tmpl == nil {
+		return "", false
+	}
+	text, err := render(tmpl, data)
+	if err != nil {
+		logger().Errorf("Error rendering the %s template: %s", tmpl.Name(), err)
+		return "", false
+	}
+	return text, strings.TrimSpace(text) != ""
+}
+
+func render(tmpl *template.Template, data TemplateData) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,108 @@
+package notify
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+func TestDefaultTemplates(t *testing.T) {
+	c := NewChatwork("token", "room", func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	c.ApiToken = ""
+	c.Mentions = map[string][]string{"fluent": {"100"}}
+	c.Templates = DefaultTemplates()
+	c.Templates.Location = time.UTC
+
+	run := report.NewRun("test-cluster")
+	check := run.StartCheck("fluent", "fluent-test")
+	check.StartedAt = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	c.Start(run, check)
+	c.Step(run, check, "Logs are in s3")
+	require.Equal(t, "Start in test-cluster at 2024-01-02 03:04:05\nfluent check start\nfluent check application Namespace: fluent-test\nLogs are in s3\n", c.Messages.String())
+
+	data := TemplateData{Run: run, Check: check, Messages: c.buffered(), Mentions: c.mentions("fluent")}
+	check.Finish(errors.New("no logs in s3"))
+	text, err := render(c.Templates.Failure, data)
+	require.NoError(t, err)
+	require.Equal(t, "[To:100]\n[info][title]❌ fluent check failed in test-cluster[/title]"+
+		"Start in test-cluster at 2024-01-02 03:04:05\nfluent check start\nfluent check application Namespace: fluent-test\nLogs are in s3\nError: no logs in s3[/info]", text)
+
+	check.Skip("not installed")
+	data.Messages = nil
+	text, err = render(c.Templates.Success, data)
+	require.NoError(t, err)
+	require.Equal(t, "[info][title]⏭ fluent check skipped in test-cluster[/title]Reason: not installed[/info]", text)
+
+	text, err = render(c.Templates.Summary, TemplateData{Run: run})
+	require.NoError(t, err)
+	require.Empty(t, text)
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "start.tmpl"), []byte("{{.Check.Name}} を開始しました ({{time .Check.StartedAt}})\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "summary.tmpl"), []byte(`{{.Run.ClusterName}}: {{.Run.Status}} {{json .Run.ClusterName}}`), 0o644))
+
+	tmpl, err := LoadTemplates(dir)
+	require.NoError(t, err)
+	tmpl.Location = time.FixedZone("CET", 3600)
+	tmpl.TimeFormat = "02.01.2006 15:04"
+
+	run := report.NewRun("prod")
+	check := run.StartCheck("ingress", "")
+	check.StartedAt = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	text, err := render(tmpl.Start, TemplateData{Run: run, Check: check})
+	require.NoError(t, err)
+	require.Equal(t, "ingress を開始しました (02.01.2024 04:04)\n", text)
+
+	text, err = render(tmpl.Summary, TemplateData{Run: run})
+	require.NoError(t, err)
+	require.Equal(t, `prod: running "prod"`, text)
+
+	// The others are the default ones.
+	text, err = render(tmpl.Step, TemplateData{Run: run, Message: "hello"})
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", text)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "step.tmpl"), []byte("{{.Message"), 0o644))
+	_, err = LoadTemplates(dir)
+	require.Error(t, err)
+}
+
+func TestLoadTemplateOverrides(t *testing.T) {
+	tmpl, err := LoadTemplateOverrides("")
+	require.NoError(t, err)
+	require.Nil(t, tmpl)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "failure.tmpl"), []byte("{{.Check.Name}} failed"), 0o644))
+	tmpl, err = LoadTemplateOverrides(dir)
+	require.NoError(t, err)
+	require.NotNil(t, tmpl.Failure)
+	require.Nil(t, tmpl.Summary)
+	require.Nil(t, tmpl.Live)
+}
+
+func TestChatworkTemplateError(t *testing.T) {
+	tmpl := DefaultTemplates()
+	tmpl.Step = template.Must(template.New("step").Parse("{{.Nope}}"))
+	c := &Chatwork{Templates: tmpl, Logger: func() *logrus.Entry { return logrus.NewEntry(logrus.New()) }}
+	run := report.NewRun("test")
+	c.Step(run, run.StartCheck("ingress", ""), "Record is available")
+	require.Contains(t, c.Messages.String(), `"Message":"Record is available"`)
+}
+
+func TestTemplatesDefaultLocation(t *testing.T) {
+	tm := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.Equal(t, "2024-01-02 12:04:05", DefaultTemplates().Format(tm))
+}
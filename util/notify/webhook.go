@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/chatwork/kibertas/util/report"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of the body of webhook requests,
+// as "sha256=<hex>", when a secret is set.
+const SignatureHeader = "X-Kibertas-Signature-256"
+
+// DefaultWebhookTemplate posts the run as in the JSON report.
+const DefaultWebhookTemplate = `{{json .}}`
+
+// Webhook posts the result of the run to an HTTP endpoint once it has finished.
+// The body is rendered from the report.Run with Template, which makes it possible
+// to talk to Teams, Google Chat, Mattermost or custom receivers alike.
+type Webhook struct {
+	URL     string
+	Headers map[string]string
+	// Template renders the body. See ParseWebhookTemplate.
+	Template *template.Template
+	// Secret signs the requests in SignatureHeader when set.
+	Secret string
+	// Retries is how many times a request failing with a 5xx status or a
+	// network error is retried, waiting Backoff, then twice as long each time.
+	Retries    int
+	Backoff    time.Duration
+	HTTPClient *http.Client
+	Logger     func() *logrus.Entry
+}
+
+func NewWebhook(url string, tmpl *template.Template, logger func() *logrus.Entry) *Webhook {
+	return &Webhook{
+		URL:        url,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Template:   tmpl,
+		Retries:    3,
+		Backoff:    time.Second,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Logger:     logger,
+	}
+}
+
+// ParseWebhookTemplate parses the body template of a Webhook.
+// Besides the text/template builtins, json renders its argument as JSON,
+// e.g. {"text": {{json .ClusterName}}}, so that values are always quoted.
+func ParseWebhookTemplate(text string) (*template.Template, error) {
+	return template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+}
+
+// Start, Step and Result do nothing: the endpoint gets the whole run at once.
+func (w *Webhook) Start(run *report.Run, check *report.CheckResult)                {}
+func (w *Webhook) Step(run *report.Run, check *report.CheckResult, message string) {}
+func (w *Webhook) Result(run *report.Run, check *report.CheckResult)               {}
+
+func (w *Webhook) Summary(run *report.Run) {
+	if err := w.Send(run); err != nil {
+		w.Logger().Errorf("Error posting to webhook: %s", err)
+	}
+}
+
+// Send renders the body for run and posts it.
+func (w *Webhook) Send(run *report.Run) error {
+	tmpl := w.Template
+	if tmpl == nil {
+		tmpl = template.Must(ParseWebhookTemplate(DefaultWebhookTemplate))
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, run); err != nil {
+		return fmt.Errorf("rendering body: %w", err)
+	}
+
+	backoff := w.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(body.Bytes())
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.Retries {
+			return err
+		}
+		w.Logger().Warnf("Error posting to webhook, retrying in %s: %s", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends body once and returns whether it is worth retrying on error.
+func (w *Webhook) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(w.Secret, body))
+	}
+
+	client := w.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return resp.StatusCode >= 500, fmt.Errorf("%s: %s", resp.Status, respBody)
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body with secret,
+// for receivers to check SignatureHeader against.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
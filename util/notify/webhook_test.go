@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhook(t *testing.T) {
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, "sha256="+Sign("secret", body), r.Header.Get(SignatureHeader))
+		require.Equal(t, "Bearer abc", r.Header.Get("Authorization"))
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+	}))
+	defer ts.Close()
+
+	tmpl, err := ParseWebhookTemplate(`{"text": {{json (printf "%s: %s" .ClusterName .Status)}}, "checks": [{{range $i, $c := .Checks}}{{if $i}}, {{end}}{{json $c.Name}}{{end}}]}`)
+	require.NoError(t, err)
+
+	w := NewWebhook(ts.URL, tmpl, func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	w.Secret = "secret"
+	w.Headers["Authorization"] = "Bearer abc"
+	require.NoError(t, w.Send(newSlackTestRun()))
+
+	var got map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &got))
+	require.Equal(t, map[string]interface{}{
+		"text":   "test-cluster: failed",
+		"checks": []interface{}{"ingress", "fluent"},
+	}, got)
+}
+
+func TestWebhookDefaultTemplate(t *testing.T) {
+	var got map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Empty(t, r.Header.Get(SignatureHeader))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+	}))
+	defer ts.Close()
+
+	w := NewWebhook(ts.URL, nil, func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+	require.NoError(t, w.Send(newSlackTestRun()))
+	require.Equal(t, "test-cluster", got["clusterName"])
+	require.Len(t, got["checks"], 2)
+}
+
+func TestWebhookRetry(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		statuses []int
+		wantErr  string
+		requests int
+	}{
+		{name: "recovers", statuses: []int{502, 503, 200}, requests: 3},
+		{name: "gives up", statuses: []int{500, 500, 500, 500}, wantErr: "500 Internal Server Error: oops", requests: 3},
+		{name: "client error", statuses: []int{400}, wantErr: "400 Bad Request: oops", requests: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.statuses[requests])
+				_, _ = w.Write([]byte("oops"))
+				requests++
+			}))
+			defer ts.Close()
+
+			w := NewWebhook(ts.URL, nil, func() *logrus.Entry { return logrus.NewEntry(logrus.New()) })
+			w.Retries = 2
+			w.Backoff = 0
+			err := w.Send(newSlackTestRun())
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.wantErr)
+			}
+			require.Equal(t, tc.requests, requests)
+		})
+	}
+}
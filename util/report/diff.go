@@ -0,0 +1,101 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chatwork/kibertas/util/inventory"
+)
+
+// RunDiff is what changed between two runs: the status of the checks
+// and the inventory of the cluster.
+type RunDiff struct {
+	Before *Run
+	After  *Run
+	Checks []CheckChange
+	// Inventory is nil if either run has no inventory snapshot.
+	Inventory []inventory.Change
+}
+
+// CheckChange is a check whose status differs between two runs.
+// Before or After is empty when the check only ran once.
+type CheckChange struct {
+	Name   string
+	Before Status
+	After  Status
+	// Error is the error of the check in the later run.
+	Error string
+}
+
+func Diff(before, after *Run) RunDiff {
+	d := RunDiff{Before: before, After: after}
+
+	prev := map[string]*CheckResult{}
+	for _, c := range before.Checks {
+		prev[c.Name] = c
+	}
+	seen := map[string]bool{}
+	for _, c := range after.Checks {
+		seen[c.Name] = true
+		p, ok := prev[c.Name]
+		if ok && p.Status == c.Status {
+			continue
+		}
+		change := CheckChange{Name: c.Name, After: c.Status, Error: c.Error}
+		if ok {
+			change.Before = p.Status
+		}
+		d.Checks = append(d.Checks, change)
+	}
+	for _, c := range before.Checks {
+		if !seen[c.Name] {
+			d.Checks = append(d.Checks, CheckChange{Name: c.Name, Before: c.Status})
+		}
+	}
+
+	if before.Inventory != nil && after.Inventory != nil {
+		d.Inventory = inventory.Diff(before.Inventory, after.Inventory)
+	}
+	return d
+}
+
+func (d RunDiff) String() string {
+	var b strings.Builder
+	for _, r := range []struct {
+		label string
+		run   *Run
+	}{{"Before", d.Before}, {"After", d.After}} {
+		fmt.Fprintf(&b, "%s: %s %s (%s)\n", r.label, r.run.ID, r.run.StartedAt.Format("2006-01-02 15:04:05"), r.run.Status())
+	}
+
+	b.WriteString("\nChecks:\n")
+	if len(d.Checks) == 0 {
+		b.WriteString("  no changes\n")
+	}
+	for _, c := range d.Checks {
+		fmt.Fprintf(&b, "  %s: %s -> %s", c.Name, orNone(c.Before), orNone(c.After))
+		if c.After == StatusFailed && c.Error != "" {
+			fmt.Fprintf(&b, " (%s)", c.Error)
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\nInventory:\n")
+	switch {
+	case d.Before.Inventory == nil || d.After.Inventory == nil:
+		b.WriteString("  not recorded in both runs\n")
+	case len(d.Inventory) == 0:
+		b.WriteString("  no changes\n")
+	}
+	for _, c := range d.Inventory {
+		fmt.Fprintf(&b, "  %s\n", c)
+	}
+	return b.String()
+}
+
+func orNone(s Status) Status {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
@@ -0,0 +1,58 @@
+package report
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/chatwork/kibertas/util/inventory"
+)
+
+func TestHistory(t *testing.T) {
+	h := History{Dir: t.TempDir()}
+	run := newTestRun(t)
+
+	path, err := h.Save(run)
+	require.NoError(t, err)
+
+	for _, ref := range []string{run.ID, path} {
+		loaded, err := h.Load(ref)
+		require.NoError(t, err)
+		require.Equal(t, run.ID, loaded.ID)
+		require.Len(t, loaded.Checks, 2)
+	}
+
+	_, err = h.Load("missing")
+	require.Error(t, err)
+}
+
+func TestDiff(t *testing.T) {
+	before := NewRun("test")
+	before.StartCheck("ingress", "").Finish(nil)
+	before.StartCheck("fluent", "").Finish(nil)
+	before.StartCheck("datadog-agent", "").Finish(nil)
+	before.Inventory = &inventory.Snapshot{Nodes: []inventory.Node{{Name: "a", KubeletVersion: "v1.29.0"}}}
+
+	after := NewRun("test")
+	after.StartCheck("ingress", "").Finish(nil)
+	after.StartCheck("fluent", "").Finish(errors.New("no logs in s3"))
+	after.StartCheck("cert-manager", "").Finish(nil)
+	after.Inventory = &inventory.Snapshot{Nodes: []inventory.Node{{Name: "b", KubeletVersion: "v1.30.1"}}}
+
+	d := Diff(before, after)
+	require.Equal(t, []CheckChange{
+		{Name: "fluent", Before: StatusPassed, After: StatusFailed, Error: "no logs in s3"},
+		{Name: "cert-manager", After: StatusPassed},
+		{Name: "datadog-agent", Before: StatusPassed},
+	}, d.Checks)
+	require.Len(t, d.Inventory, 1)
+
+	s := d.String()
+	require.Contains(t, s, "fluent: passed -> failed (no logs in s3)")
+	require.Contains(t, s, "cert-manager: none -> passed")
+	require.Contains(t, s, "~ Nodes kubelet: v1.29.0 x1 -> v1.30.1 x1")
+
+	after.Inventory = nil
+	require.Contains(t, Diff(before, after).String(), "not recorded in both runs")
+}
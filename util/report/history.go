@@ -0,0 +1,44 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// History stores runs as <Dir>/<run ID>.json, so that they can be compared later.
+type History struct {
+	Dir string
+}
+
+// Save writes run to the history and returns the path of the file.
+func (h History) Save(run *Run) (string, error) {
+	if err := os.MkdirAll(h.Dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(h.Dir, run.ID+".json")
+	if err := (Output{Format: "json", Path: path}).Write(run); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Load reads a run given either by its ID or by the path of a JSON report.
+func (h History) Load(ref string) (*Run, error) {
+	path := ref
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) && h.Dir != "" {
+		path = filepath.Join(h.Dir, ref+".json")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading run %s: %w", ref, err)
+	}
+	run := &Run{}
+	if err := json.Unmarshal(data, run); err != nil {
+		return nil, fmt.Errorf("loading run %s: %w", ref, err)
+	}
+	return run, nil
+}
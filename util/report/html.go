@@ -0,0 +1,65 @@
+package report
+
+import (
+	_ "embed"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+//go:embed html.tmpl
+var htmlTemplate string
+
+// excerptLines is how many lines of an artifact are shown before the
+// rest is folded away.
+const excerptLines = 20
+
+var htmlFuncs = template.FuncMap{
+	"duration": func(d time.Duration) string {
+		return d.String()
+	},
+	"timestamp": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format("2006-01-02 15:04:05 MST")
+	},
+	"excerpt": func(s string) string {
+		lines := strings.Split(s, "\n")
+		if len(lines) <= excerptLines {
+			return s
+		}
+		return strings.Join(lines[:excerptLines], "\n")
+	},
+	"truncated": func(s string) bool {
+		return strings.Count(s, "\n") >= excerptLines
+	},
+	// offset and width position a step bar on the timeline of its check, in percent.
+	"offset": func(c *CheckResult, s *Step) float64 {
+		total := c.Duration()
+		if total <= 0 {
+			return 0
+		}
+		return 100 * float64(s.StartedAt.Sub(c.StartedAt)) / float64(total)
+	},
+	"width": func(c *CheckResult, s *Step) float64 {
+		total := c.Duration()
+		if total <= 0 {
+			return 0
+		}
+		w := 100 * float64(s.Duration()) / float64(total)
+		if w < 0.5 {
+			w = 0.5
+		}
+		return w
+	},
+}
+
+func renderHTML(w io.Writer, run *Run) error {
+	t, err := template.New("report").Funcs(htmlFuncs).Parse(htmlTemplate)
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, run)
+}
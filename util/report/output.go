@@ -0,0 +1,57 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Output is a destination given by --report <format>=<path>.
+type Output struct {
+	Format string
+	Path   string
+}
+
+type renderFunc func(w io.Writer, run *Run) error
+
+var formats = map[string]renderFunc{
+	"json": renderJSON,
+	"html": renderHTML,
+}
+
+func ParseOutput(s string) (Output, error) {
+	format, path, ok := strings.Cut(s, "=")
+	if !ok || path == "" {
+		return Output{}, fmt.Errorf("invalid report %q: expected <format>=<path>", s)
+	}
+	if _, ok := formats[format]; !ok {
+		return Output{}, fmt.Errorf("unknown report format %q", format)
+	}
+	return Output{Format: format, Path: path}, nil
+}
+
+// Write renders run in the output format and writes it to the output path.
+func (o Output) Write(run *Run) error {
+	render, ok := formats[o.Format]
+	if !ok {
+		return fmt.Errorf("unknown report format %q", o.Format)
+	}
+
+	f, err := os.Create(o.Path)
+	if err != nil {
+		return err
+	}
+	if err := render(f, run); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("rendering %s report: %w", o.Format, err)
+	}
+	return f.Close()
+}
+
+func renderJSON(w io.Writer, run *Run) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(run)
+}
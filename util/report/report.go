@@ -0,0 +1,262 @@
+package report
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chatwork/kibertas/util"
+	"github.com/chatwork/kibertas/util/inventory"
+)
+
+type Status string
+
+const (
+	StatusRunning Status = "running"
+	StatusPassed  Status = "passed"
+	StatusFailed  Status = "failed"
+	StatusSkipped Status = "skipped"
+)
+
+// ArtifactKind tells the renderers how to present an artifact.
+type ArtifactKind string
+
+const (
+	KindEvents        ArtifactKind = "events"
+	KindPodLogs       ArtifactKind = "pod-logs"
+	KindObjects       ArtifactKind = "objects"
+	KindS3Object      ArtifactKind = "s3-object"
+	KindDNSAnswer     ArtifactKind = "dns-answer"
+	KindHTTPResponse  ArtifactKind = "http-response"
+	KindDatadogSeries ArtifactKind = "datadog-series"
+	KindPluginOutput  ArtifactKind = "plugin-output"
+)
+
+// Run is the structured result of one kibertas invocation.
+// Every report format and notification is rendered from it.
+type Run struct {
+	ID          string         `json:"id"`
+	ClusterName string         `json:"clusterName"`
+	StartedAt   time.Time      `json:"startedAt"`
+	FinishedAt  time.Time      `json:"finishedAt,omitempty"`
+	Checks      []*CheckResult `json:"checks"`
+	// Inventory is the snapshot of the cluster add-ons taken with the run.
+	Inventory *inventory.Snapshot `json:"inventory,omitempty"`
+	// Soak summarizes all the runs of a soak test, of which this is the last.
+	Soak *Summary `json:"soak,omitempty"`
+	// Previous has the status of the checks in the previous runs, when known.
+	// See State.
+	Previous map[string]Status `json:"previous,omitempty"`
+	// Digest is set on the runs that only carry a digest to notify.
+	Digest *Digest `json:"digest,omitempty"`
+}
+
+// CheckResult is the result of a single checker such as ingress or fluent.
+type CheckResult struct {
+	Name       string    `json:"name"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Status     Status    `json:"status"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	// Message tells why the check was skipped.
+	Message   string     `json:"message,omitempty"`
+	Steps     []*Step    `json:"steps"`
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+}
+
+// Step is a phase of a check, e.g. "create deployment" or "check dns record".
+type Step struct {
+	Name       string    `json:"name"`
+	Status     Status    `json:"status"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Artifact is evidence collected during a check: diagnostics, probe answers and so on.
+type Artifact struct {
+	Kind    ArtifactKind `json:"kind"`
+	Name    string       `json:"name"`
+	Content string       `json:"content,omitempty"`
+	URL     string       `json:"url,omitempty"`
+}
+
+func NewRun(clusterName string) *Run {
+	t := time.Now()
+	return &Run{
+		ID:          fmt.Sprintf("%d%02d%02d-%02d%02d%02d-%s", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), util.GenerateRandomString(5)),
+		ClusterName: clusterName,
+		StartedAt:   t,
+		Checks:      []*CheckResult{},
+	}
+}
+
+// StartCheck appends a running check to the run and returns it.
+func (r *Run) StartCheck(name, namespace string) *CheckResult {
+	c := &CheckResult{
+		Name:      name,
+		Namespace: namespace,
+		Status:    StatusRunning,
+		StartedAt: time.Now(),
+		Steps:     []*Step{},
+	}
+	r.Checks = append(r.Checks, c)
+	return c
+}
+
+func (r *Run) Finish() {
+	r.FinishedAt = time.Now()
+}
+
+// Status is failed if any check failed, skipped if every check was skipped
+// and passed otherwise.
+func (r *Run) Status() Status {
+	if len(r.Checks) == 0 {
+		return StatusSkipped
+	}
+	status := StatusSkipped
+	for _, c := range r.Checks {
+		switch c.Status {
+		case StatusFailed:
+			return StatusFailed
+		case StatusRunning:
+			status = StatusRunning
+		case StatusPassed:
+			if status == StatusSkipped {
+				status = StatusPassed
+			}
+		}
+	}
+	return status
+}
+
+// Changed tells whether check passed after failing in the previous run, or
+// the other way around. New checks are compared as if they had passed before,
+// and skipped ones never change.
+func (r *Run) Changed(check *CheckResult) bool {
+	if check.Status != StatusPassed && check.Status != StatusFailed {
+		return false
+	}
+	previous, ok := r.Previous[check.Name]
+	if !ok {
+		previous = StatusPassed
+	}
+	return check.Status != previous
+}
+
+func (r *Run) Duration() time.Duration {
+	return duration(r.StartedAt, r.FinishedAt)
+}
+
+// The CheckResult methods accept a nil receiver so that checkers can be
+// driven directly, e.g. from tests, without a report attached.
+
+// Step runs fn as a named step and records its outcome.
+func (c *CheckResult) Step(name string, fn func() error) error {
+	s := c.StartStep(name)
+	err := fn()
+	s.Finish(err)
+	return err
+}
+
+func (c *CheckResult) StartStep(name string) *Step {
+	s := &Step{
+		Name:      name,
+		Status:    StatusRunning,
+		StartedAt: time.Now(),
+	}
+	if c != nil {
+		c.Steps = append(c.Steps, s)
+	}
+	return s
+}
+
+// AddStep records a step that was run elsewhere, e.g. by a plugin.
+func (c *CheckResult) AddStep(s *Step) {
+	if c == nil {
+		return
+	}
+	c.Steps = append(c.Steps, s)
+}
+
+// SkipStep records a step that was intentionally not run.
+func (c *CheckResult) SkipStep(name, reason string) {
+	if c == nil {
+		return
+	}
+	now := time.Now()
+	c.Steps = append(c.Steps, &Step{
+		Name:       name,
+		Status:     StatusSkipped,
+		StartedAt:  now,
+		FinishedAt: now,
+		Message:    reason,
+	})
+}
+
+func (c *CheckResult) AddArtifact(a Artifact) {
+	if c == nil {
+		return
+	}
+	c.Artifacts = append(c.Artifacts, a)
+}
+
+// Finish marks the check as passed, or failed when err is not nil.
+func (c *CheckResult) Finish(err error) {
+	if c == nil {
+		return
+	}
+	c.FinishedAt = time.Now()
+	if err != nil {
+		c.Status = StatusFailed
+		c.Error = err.Error()
+		return
+	}
+	c.Status = StatusPassed
+}
+
+// Skip marks the check as not run, e.g. because its prerequisites are missing.
+func (c *CheckResult) Skip(reason string) {
+	if c == nil {
+		return
+	}
+	c.FinishedAt = time.Now()
+	c.Status = StatusSkipped
+	c.Message = reason
+}
+
+func (c *CheckResult) Duration() time.Duration {
+	return duration(c.StartedAt, c.FinishedAt)
+}
+
+// FailedStep returns the first failed step of the check, or nil.
+func (c *CheckResult) FailedStep() *Step {
+	for _, s := range c.Steps {
+		if s.Status == StatusFailed {
+			return s
+		}
+	}
+	return nil
+}
+
+func (s *Step) Finish(err error) {
+	s.FinishedAt = time.Now()
+	if err != nil {
+		s.Status = StatusFailed
+		s.Error = err.Error()
+		return
+	}
+	s.Status = StatusPassed
+}
+
+func (s *Step) Duration() time.Duration {
+	return duration(s.StartedAt, s.FinishedAt)
+}
+
+func duration(start, end time.Time) time.Duration {
+	if end.IsZero() {
+		return 0
+	}
+	return end.Sub(start).Round(time.Millisecond)
+}
@@ -0,0 +1,115 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/chatwork/kibertas/util/inventory"
+)
+
+func newTestRun(t *testing.T) *Run {
+	run := NewRun("test-cluster")
+
+	ingress := run.StartCheck("ingress", "ingress-test")
+	require.NoError(t, ingress.Step("create namespace", func() error { return nil }))
+	ingress.SkipStep("check dns record", "disabled by --no-dns-check")
+	ingress.AddArtifact(Artifact{Kind: KindHTTPResponse, Name: "http://example.local/", URL: "http://example.local/", Content: "HTTP/1.1 200 OK"})
+	ingress.Finish(nil)
+
+	fluent := run.StartCheck("fluent", "fluent-test")
+	err := fluent.Step("check s3 object", func() error { return errors.New("timed out") })
+	fluent.AddArtifact(Artifact{Kind: KindEvents, Name: "events in fluent-test", Content: "<script>alert(1)</script>"})
+	fluent.Finish(err)
+
+	run.Finish()
+	return run
+}
+
+func TestRunStatus(t *testing.T) {
+	run := NewRun("test")
+	require.Equal(t, StatusSkipped, run.Status())
+
+	c := run.StartCheck("a", "")
+	require.Equal(t, StatusRunning, run.Status())
+	c.Finish(nil)
+	require.Equal(t, StatusPassed, run.Status())
+
+	run.StartCheck("b", "").Finish(errors.New("boom"))
+	require.Equal(t, StatusFailed, run.Status())
+}
+
+func TestNilCheckResult(t *testing.T) {
+	var c *CheckResult
+	called := false
+	require.NoError(t, c.Step("step", func() error {
+		called = true
+		return nil
+	}))
+	require.True(t, called)
+	c.SkipStep("skipped", "reason")
+	c.AddArtifact(Artifact{Kind: KindEvents})
+	c.Finish(nil)
+}
+
+func TestStepRecordsFailure(t *testing.T) {
+	c := NewRun("test").StartCheck("check", "")
+	err := c.Step("failing", func() error { return errors.New("boom") })
+	require.EqualError(t, err, "boom")
+	require.Len(t, c.Steps, 1)
+	require.Equal(t, StatusFailed, c.Steps[0].Status)
+	require.Equal(t, "boom", c.Steps[0].Error)
+	require.False(t, c.Steps[0].FinishedAt.IsZero())
+}
+
+func TestParseOutput(t *testing.T) {
+	o, err := ParseOutput("html=out/report.html")
+	require.NoError(t, err)
+	require.Equal(t, Output{Format: "html", Path: "out/report.html"}, o)
+
+	_, err = ParseOutput("html")
+	require.Error(t, err)
+
+	_, err = ParseOutput("pdf=report.pdf")
+	require.EqualError(t, err, `unknown report format "pdf"`)
+}
+
+func TestWriteJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	run := newTestRun(t)
+	require.NoError(t, Output{Format: "json", Path: path}.Write(run))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var got Run
+	require.NoError(t, json.Unmarshal(b, &got))
+	require.Equal(t, run.ID, got.ID)
+	require.Len(t, got.Checks, 2)
+	require.Equal(t, StatusFailed, got.Checks[1].Status)
+}
+
+func TestWriteHTML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.html")
+	run := newTestRun(t)
+	run.Inventory = &inventory.Snapshot{Workloads: []inventory.Workload{{Kind: "Deployment", Namespace: "kube-system", Name: "coredns", Images: map[string]string{"coredns": "coredns:v1.11.1"}}}}
+	require.NoError(t, Output{Format: "html", Path: path}.Write(run))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	html := string(b)
+
+	require.Contains(t, html, "test-cluster")
+	require.Contains(t, html, `<a href="#check-ingress">ingress</a>`)
+	require.Contains(t, html, "disabled by --no-dns-check")
+	require.Contains(t, html, `<a href="http://example.local/">http://example.local/</a>`)
+	require.Contains(t, html, "timed out")
+	require.Contains(t, html, "coredns: coredns:v1.11.1")
+	require.NotContains(t, html, "<script>")
+	require.True(t, strings.HasPrefix(html, "<!DOCTYPE html>"))
+}
@@ -0,0 +1,243 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// State is what notifications remember between runs: the last status of each
+// check, the runs to include in the next digest and the tasks created for failures.
+type State struct {
+	Checks map[string]Status `json:"checks,omitempty"`
+	Digest *Digest           `json:"digest,omitempty"`
+	// ChatworkTasks has the IDs of the tasks created for the failures of each check.
+	ChatworkTasks map[string][]int64 `json:"chatworkTasks,omitempty"`
+}
+
+func (s *State) SetChatworkTasks(check string, ids []int64) {
+	if s.ChatworkTasks == nil {
+		s.ChatworkTasks = map[string][]int64{}
+	}
+	s.ChatworkTasks[check] = ids
+}
+
+// Record updates the state with run. It returns the digest to send when
+// interval has elapsed since the first run of the digest, and starts a new one.
+// Skipped checks keep their previous status.
+func (s *State) Record(run *Run, interval time.Duration) *Digest {
+	if s.Checks == nil {
+		s.Checks = map[string]Status{}
+	}
+	for _, c := range run.Checks {
+		if c.Status == StatusPassed || c.Status == StatusFailed {
+			s.Checks[c.Name] = c.Status
+		}
+	}
+
+	if s.Digest == nil {
+		s.Digest = &Digest{Since: run.StartedAt}
+	}
+	s.Digest.Add(run)
+	if run.FinishedAt.Sub(s.Digest.Since) < interval {
+		return nil
+	}
+	digest := s.Digest
+	digest.Until = run.FinishedAt
+	s.Digest = nil
+	return digest
+}
+
+// Digest aggregates the outcome of the runs over a period, e.g. a day.
+type Digest struct {
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until,omitempty"`
+	Runs   int       `json:"runs"`
+	Passed int       `json:"passed"`
+	// Checks has the outcomes of every check, by name.
+	Checks map[string]*CheckCounts `json:"checks"`
+}
+
+type CheckCounts struct {
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+}
+
+// PassRate is the fraction of the runs in which the check did not fail,
+// not counting those it was skipped in.
+func (c CheckCounts) PassRate() float64 {
+	if c.Passed+c.Failed == 0 {
+		return 0
+	}
+	return float64(c.Passed) / float64(c.Passed+c.Failed)
+}
+
+func (d *Digest) Add(run *Run) {
+	if d.Checks == nil {
+		d.Checks = map[string]*CheckCounts{}
+	}
+	d.Runs++
+	if run.Status() != StatusFailed {
+		d.Passed++
+	}
+	for _, c := range run.Checks {
+		counts, ok := d.Checks[c.Name]
+		if !ok {
+			counts = &CheckCounts{}
+			d.Checks[c.Name] = counts
+		}
+		switch c.Status {
+		case StatusPassed:
+			counts.Passed++
+		case StatusFailed:
+			counts.Failed++
+		case StatusSkipped:
+			counts.Skipped++
+		}
+	}
+}
+
+func (d Digest) PassRate() float64 {
+	if d.Runs == 0 {
+		return 0
+	}
+	return float64(d.Passed) / float64(d.Runs)
+}
+
+func (d Digest) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Runs: %d, passed: %d, failed: %d, pass rate: %.1f%%\n", d.Runs, d.Passed, d.Runs-d.Passed, 100*d.PassRate())
+	names := make([]string, 0, len(d.Checks))
+	for name := range d.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := d.Checks[name]
+		fmt.Fprintf(&b, "%s: passed: %d, failed: %d, skipped: %d, pass rate: %.1f%%\n", name, c.Passed, c.Failed, c.Skipped, 100*c.PassRate())
+	}
+	return b.String()
+}
+
+// StateStore keeps the State between runs.
+type StateStore interface {
+	// Load returns an empty state if none was saved yet.
+	Load(ctx context.Context) (*State, error)
+	Save(ctx context.Context, state *State) error
+}
+
+// LoadState loads the state from store. If that fails, it returns the error
+// with an empty state and a store that refuses to save it, so that the state
+// made of it doesn't overwrite the stored one.
+func LoadState(ctx context.Context, store StateStore) (*State, StateStore, error) {
+	state, err := store.Load(ctx)
+	if err != nil {
+		return &State{}, unloadedState{store}, err
+	}
+	return state, store, nil
+}
+
+// unloadedState is a store the state couldn't be loaded from.
+type unloadedState struct {
+	StateStore
+}
+
+func (unloadedState) Save(ctx context.Context, state *State) error {
+	return errors.New("not saving the state, as it couldn't be loaded")
+}
+
+// FileState stores the state as JSON in Path, e.g. in the history directory.
+type FileState struct {
+	Path string
+}
+
+func (f FileState) Load(ctx context.Context) (*State, error) {
+	state := &State{}
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("loading state from %s: %w", f.Path, err)
+	}
+	return state, nil
+}
+
+func (f FileState) Save(ctx context.Context, state *State) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(f.Path, data, 0o644)
+}
+
+// stateKey is the key of the ConfigMap data the state is stored in.
+const stateKey = "state.json"
+
+// ConfigMapState stores the state in a ConfigMap, for kibertas running
+// as a CronJob without a persistent volume.
+type ConfigMapState struct {
+	Clientset kubernetes.Interface
+	Namespace string
+	Name      string
+}
+
+func (c ConfigMapState) Load(ctx context.Context) (*State, error) {
+	state := &State{}
+	cm, err := c.Clientset.CoreV1().ConfigMaps(c.Namespace).Get(ctx, c.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data, ok := cm.Data[stateKey]; ok {
+		if err := json.Unmarshal([]byte(data), state); err != nil {
+			return nil, fmt.Errorf("loading state from ConfigMap %s/%s: %w", c.Namespace, c.Name, err)
+		}
+	}
+	return state, nil
+}
+
+func (c ConfigMapState) Save(ctx context.Context, state *State) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	configMaps := c.Clientset.CoreV1().ConfigMaps(c.Namespace)
+	cm, err := configMaps.Get(ctx, c.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, &apiv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: c.Name, Namespace: c.Namespace},
+			Data:       map[string]string{stateKey: string(data)},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[stateKey] = string(data)
+	_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
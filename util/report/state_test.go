@@ -0,0 +1,108 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newStateTestRun(started time.Time, failures map[string]error) *Run {
+	run := NewRun("test")
+	run.StartedAt = started
+	for _, name := range []string{"ingress", "fluent"} {
+		run.StartCheck(name, "").Finish(failures[name])
+	}
+	run.StartCheck("cert-manager", "").Skip("not installed")
+	run.FinishedAt = started.Add(10 * time.Minute)
+	return run
+}
+
+func TestStateRecord(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	state := &State{Checks: map[string]Status{"cert-manager": StatusFailed}}
+
+	for i := 0; i < 23; i++ {
+		var failures map[string]error
+		if i%4 == 0 {
+			failures = map[string]error{"fluent": errors.New("no logs")}
+		}
+		require.Nil(t, state.Record(newStateTestRun(start.Add(time.Duration(i)*time.Hour), failures), 24*time.Hour))
+	}
+	require.Equal(t, map[string]Status{"ingress": StatusPassed, "fluent": StatusPassed, "cert-manager": StatusFailed}, state.Checks)
+
+	digest := state.Record(newStateTestRun(start.Add(24*time.Hour), map[string]error{"ingress": errors.New("no address")}), 24*time.Hour)
+	require.NotNil(t, digest)
+	require.Nil(t, state.Digest)
+	require.Equal(t, StatusFailed, state.Checks["ingress"])
+
+	require.Equal(t, 24, digest.Runs)
+	require.Equal(t, 17, digest.Passed)
+	require.Equal(t, start, digest.Since)
+	require.Equal(t, start.Add(24*time.Hour+10*time.Minute), digest.Until)
+	require.Equal(t, CheckCounts{Passed: 23, Failed: 1}, *digest.Checks["ingress"])
+	require.Equal(t, CheckCounts{Passed: 18, Failed: 6}, *digest.Checks["fluent"])
+	require.Equal(t, CheckCounts{Skipped: 24}, *digest.Checks["cert-manager"])
+	require.Equal(t, `Runs: 24, passed: 17, failed: 7, pass rate: 70.8%
+cert-manager: passed: 0, failed: 0, skipped: 24, pass rate: 0.0%
+fluent: passed: 18, failed: 6, skipped: 0, pass rate: 75.0%
+ingress: passed: 23, failed: 1, skipped: 0, pass rate: 95.8%
+`, digest.String())
+}
+
+func TestRunChanged(t *testing.T) {
+	run := newStateTestRun(time.Now(), map[string]error{"fluent": errors.New("no logs")})
+	run.Previous = map[string]Status{"ingress": StatusFailed, "cert-manager": StatusFailed}
+
+	require.True(t, run.Changed(run.Checks[0]), "ingress passed after failing")
+	require.True(t, run.Changed(run.Checks[1]), "fluent is new and failed")
+	require.False(t, run.Changed(run.Checks[2]), "cert-manager was skipped")
+
+	run.Previous["fluent"] = StatusFailed
+	require.False(t, run.Changed(run.Checks[1]))
+}
+
+func TestStateStores(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range map[string]StateStore{
+		"file":      FileState{Path: filepath.Join(t.TempDir(), "history", "state.json")},
+		"configmap": ConfigMapState{Clientset: fake.NewClientset(), Namespace: "ops", Name: "kibertas-state"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			state, err := store.Load(ctx)
+			require.NoError(t, err)
+			require.Equal(t, &State{}, state)
+
+			state.Record(newStateTestRun(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), nil), 24*time.Hour)
+			require.NoError(t, store.Save(ctx, state))
+			require.NoError(t, store.Save(ctx, state))
+
+			loaded, err := store.Load(ctx)
+			require.NoError(t, err)
+			require.Equal(t, state.Checks, loaded.Checks)
+			require.Equal(t, 1, loaded.Digest.Runs)
+		})
+	}
+}
+
+func TestLoadStateError(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o644))
+
+	state, store, err := LoadState(ctx, FileState{Path: path})
+	require.Error(t, err)
+	require.Equal(t, &State{}, state)
+
+	// The state the run makes of an empty one doesn't replace the stored one.
+	state.Record(newStateTestRun(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), nil), 24*time.Hour)
+	require.Error(t, store.Save(ctx, state))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "{", string(data))
+}
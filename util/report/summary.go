@@ -0,0 +1,167 @@
+package report
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Summary aggregates repeated runs of the same checks, as in soak mode.
+type Summary struct {
+	Runs   int `json:"runs"`
+	Passed int `json:"passed"`
+	Failed int `json:"failed"`
+	// Phases has the duration statistics of every step, in order of first appearance.
+	Phases []PhaseStats `json:"phases"`
+	// Failures groups the failed checks by reason, most frequent first.
+	Failures []FailureGroup `json:"failures,omitempty"`
+}
+
+type PhaseStats struct {
+	Check string        `json:"check"`
+	Step  string        `json:"step"`
+	Count int           `json:"count"`
+	P50   time.Duration `json:"p50"`
+	P95   time.Duration `json:"p95"`
+	Max   time.Duration `json:"max"`
+}
+
+type FailureGroup struct {
+	Check  string `json:"check"`
+	Step   string `json:"step"`
+	Reason string `json:"reason"`
+	Count  int    `json:"count"`
+}
+
+func (s Summary) SuccessRate() float64 {
+	if s.Runs == 0 {
+		return 0
+	}
+	return float64(s.Passed) / float64(s.Runs)
+}
+
+func (s Summary) FailureRate() float64 {
+	if s.Runs == 0 {
+		return 0
+	}
+	return float64(s.Failed) / float64(s.Runs)
+}
+
+func Summarize(runs []*Run) Summary {
+	s := Summary{Runs: len(runs)}
+
+	type phaseKey struct{ check, step string }
+	var order []phaseKey
+	durations := map[phaseKey][]time.Duration{}
+	failures := map[FailureGroup]int{}
+
+	for _, run := range runs {
+		if run.Status() == StatusFailed {
+			s.Failed++
+		} else {
+			s.Passed++
+		}
+
+		for _, c := range run.Checks {
+			for _, step := range c.Steps {
+				if step.Status != StatusPassed && step.Status != StatusFailed {
+					continue
+				}
+				k := phaseKey{c.Name, step.Name}
+				if _, ok := durations[k]; !ok {
+					order = append(order, k)
+				}
+				durations[k] = append(durations[k], step.Duration())
+			}
+
+			if c.Status == StatusFailed {
+				g := FailureGroup{Check: c.Name, Reason: FailureReason(c.Error)}
+				if step := c.FailedStep(); step != nil {
+					g.Step = step.Name
+				}
+				failures[g]++
+			}
+		}
+	}
+
+	for _, k := range order {
+		d := durations[k]
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		s.Phases = append(s.Phases, PhaseStats{
+			Check: k.check,
+			Step:  k.step,
+			Count: len(d),
+			P50:   percentile(d, 50),
+			P95:   percentile(d, 95),
+			Max:   d[len(d)-1],
+		})
+	}
+
+	for g, n := range failures {
+		g.Count = n
+		s.Failures = append(s.Failures, g)
+	}
+	sort.Slice(s.Failures, func(i, j int) bool {
+		a, b := s.Failures[i], s.Failures[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Check+a.Step+a.Reason < b.Check+b.Step+b.Reason
+	})
+
+	return s
+}
+
+// percentile returns the nearest-rank percentile of sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+var (
+	podSuffix    = regexp.MustCompile(`-[a-z0-9]{8,10}-[a-z0-9]{5}\b`)
+	randomSuffix = regexp.MustCompile(`-[a-z0-9]{5}\b`)
+	number       = regexp.MustCompile(`\b[0-9]+\b`)
+)
+
+// FailureReason normalizes an error message so that failures of the same type
+// group together: timeouts are reported as such, and generated names and
+// numbers are masked.
+func FailureReason(err string) string {
+	switch {
+	case strings.Contains(err, "context deadline exceeded"), strings.Contains(err, "would exceed context deadline"):
+		return "timeout: " + firstClause(err)
+	case strings.Contains(err, "context canceled"):
+		return "canceled"
+	}
+	reason := podSuffix.ReplaceAllString(err, "-*")
+	reason = randomSuffix.ReplaceAllString(reason, "-*")
+	return number.ReplaceAllString(reason, "N")
+}
+
+// firstClause returns the outermost context of a wrapped error, e.g.
+// "waiting for Pods to be ready" of "waiting for Pods to be ready: context deadline exceeded".
+func firstClause(err string) string {
+	clause, _, _ := strings.Cut(err, ":")
+	return clause
+}
+
+func (s Summary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Runs: %d, passed: %d, failed: %d, success rate: %.1f%%\n", s.Runs, s.Passed, s.Failed, 100*s.SuccessRate())
+	for _, p := range s.Phases {
+		fmt.Fprintf(&b, "%s/%s: n=%d p50=%s p95=%s max=%s\n", p.Check, p.Step, p.Count, p.P50, p.P95, p.Max)
+	}
+	if len(s.Failures) > 0 {
+		b.WriteString("Failures:\n")
+		for _, f := range s.Failures {
+			fmt.Fprintf(&b, "%dx %s/%s: %s\n", f.Count, f.Check, f.Step, f.Reason)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,61 @@
+package report
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarize(t *testing.T) {
+	var runs []*Run
+	for i := 0; i < 3; i++ {
+		runs = append(runs, newTestRun(t))
+	}
+	passing := NewRun("test-cluster")
+	c := passing.StartCheck("fluent", "fluent-test")
+	require.NoError(t, c.Step("check s3 object", func() error { return nil }))
+	c.Finish(nil)
+	runs = append(runs, passing)
+
+	s := Summarize(runs)
+	require.Equal(t, 4, s.Runs)
+	require.Equal(t, 1, s.Passed)
+	require.Equal(t, 3, s.Failed)
+	require.InDelta(t, 0.25, s.SuccessRate(), 1e-9)
+
+	// Skipped steps have no duration to report.
+	require.Len(t, s.Phases, 2)
+	require.Equal(t, "ingress", s.Phases[0].Check)
+	require.Equal(t, "create namespace", s.Phases[0].Step)
+	require.Equal(t, 3, s.Phases[0].Count)
+	require.Equal(t, "check s3 object", s.Phases[1].Step)
+	require.Equal(t, 4, s.Phases[1].Count)
+
+	require.Equal(t, []FailureGroup{{Check: "fluent", Step: "check s3 object", Reason: "timed out", Count: 3}}, s.Failures)
+	require.Contains(t, s.String(), "3x fluent/check s3 object: timed out")
+}
+
+func TestPercentile(t *testing.T) {
+	var d []time.Duration
+	for i := 1; i <= 100; i++ {
+		d = append(d, time.Duration(i)*time.Second)
+	}
+	require.Equal(t, 50*time.Second, percentile(d, 50))
+	require.Equal(t, 95*time.Second, percentile(d, 95))
+	require.Equal(t, time.Second, percentile(d[:1], 95))
+	require.Equal(t, 2*time.Second, percentile(d[:2], 95))
+}
+
+func TestFailureReason(t *testing.T) {
+	for err, want := range map[string]string{
+		"waiting for Pods to be ready: context deadline exceeded":      "timeout: waiting for Pods to be ready",
+		"client rate limiter Wait returned an error: context canceled": "canceled",
+		`pods "sample-7d9f8b6c5d-x2k9q" not found`:                     `pods "sample-*" not found`,
+		fmt.Sprintf("expected %d replicas, got %d", 3, 2):              "expected N replicas, got N",
+		`namespace "ingress-test-20261018-ab1c2" not found`:            `namespace "ingress-test-*" not found`,
+	} {
+		require.Equal(t, want, FailureReason(err), err)
+	}
+}